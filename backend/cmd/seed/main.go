@@ -3,12 +3,29 @@ package main
 import (
 	"calendar-backend/internal/models"
 	"calendar-backend/internal/repository"
+	"flag"
 	"log"
+	"os"
 	"time"
 )
 
 func main() {
-	repository.InitDB()
+	icsPath := flag.String("ics", "", "import events from an iCalendar (.ics) file instead of the built-in seed data")
+	flag.Parse()
+
+	if err := repository.InitDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	owner, err := repository.DefaultUser()
+	if err != nil {
+		log.Fatalf("Failed to load the default user: %v", err)
+	}
+
+	if *icsPath != "" {
+		seedFromICS(owner, *icsPath)
+		return
+	}
 
 	// Today's events
 	today := time.Now()
@@ -19,6 +36,11 @@ func main() {
 	}
 
 	for _, event := range events {
+		event.UserID = owner.ID
+		if _, err := repository.AssignCalendar(repository.ForUser(owner.ID), &event); err != nil {
+			log.Printf("Error assigning event %s to a calendar: %v\n", event.Title, err)
+			continue
+		}
 		result := repository.DB.Create(&event)
 		if result.Error != nil {
 			log.Printf("Error creating event %s: %v\n", event.Title, result.Error)
@@ -29,3 +51,27 @@ func main() {
 
 	log.Println("Seed completed successfully!")
 }
+
+// seedFromICS upserts the events of an .ics file into the calendar of owner,
+// so running it again with the same file doesn't create duplicates.
+func seedFromICS(owner *models.User, path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	report, err := repository.ImportICS(repository.ForUser(owner.ID), owner.ID, file)
+	if err != nil {
+		log.Fatalf("Failed to import %s: %v", path, err)
+	}
+
+	for _, result := range report.Results {
+		if result.Reason != "" {
+			log.Printf("%s: %s (%s)\n", result.Status, result.Title, result.Reason)
+		} else {
+			log.Printf("%s: %s\n", result.Status, result.Title)
+		}
+	}
+	log.Printf("Import completed: %d created, %d updated, %d skipped\n", report.Created, report.Updated, report.Skipped)
+}
@@ -4,9 +4,12 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
 	"calendar-backend/internal/handlers"
+	"calendar-backend/internal/imip"
 	"calendar-backend/internal/repository"
 
 	"github.com/gofiber/fiber/v2"
@@ -32,12 +35,46 @@ func main() {
 	}
 	log.Println("✅ Database initialized successfully")
 
+	// Purge the trash periodically
+	if days := os.Getenv("TRASH_RETENTION_DAYS"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			log.Fatalf("❌ Invalid TRASH_RETENTION_DAYS: %q", days)
+		}
+		repository.TrashRetention = time.Duration(n) * 24 * time.Hour
+	}
+	go purgeTrash(time.Hour)
+
+	// Email invitations to attendees and read their replies (iMIP)
+	interval := 30 * time.Second
+	if value := os.Getenv("IMIP_INTERVAL"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			log.Fatalf("❌ Invalid IMIP_INTERVAL: %q", value)
+		}
+		interval = d
+	}
+	smtpConfig, err := imip.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("❌ Invalid SMTP configuration: %v", err)
+	}
+	if smtpConfig != nil {
+		repository.SendInvitations = true
+		go deliverMail(smtpConfig, interval)
+		log.Printf("✅ Sending invitations through %s:%d", smtpConfig.Host, smtpConfig.Port)
+	}
+	if dir := os.Getenv("IMIP_INBOX_DIR"); dir != "" {
+		go readInbox(dir, interval)
+		log.Printf("✅ Reading invitation replies from %s", dir)
+	}
+
 	// Initialize AI provider
 	handlers.InitAIProvider()
 	log.Println("✅ AI provider initialized successfully")
 
 	// Create Fiber app with custom config
 	app := fiber.New(fiber.Config{
+		RequestMethods: append(append([]string{}, fiber.DefaultMethods...), handlers.CalDAVMethods...),
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			log.Printf("❌ Error occurred: %v", err)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -51,22 +88,88 @@ func main() {
 		Format: "[${time}] ${status} - ${method} ${path}\n",
 	}))
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "http://localhost:3000",
-		AllowHeaders: "Origin, Content-Type, Accept",
-		AllowMethods: "GET, POST, PUT, DELETE",
+		AllowOrigins:     "http://localhost:3000",
+		AllowHeaders:     "Origin, Content-Type, Accept, Authorization, If-Match",
+		AllowMethods:     "GET, POST, PUT, PATCH, DELETE",
+		AllowCredentials: true,
 	}))
 
 	// Setup routes
 	api := app.Group("/api")
+
+	// Routes that don't need a logged in user come before RequireAuth
+	api.Post("/auth/register", handlers.Register)
+	api.Post("/auth/login", handlers.Login)
+	api.Get("/calendar.ics", handlers.FeedAuth, handlers.ExportICS)
+
+	// Admin endpoints, enabled by setting ADMIN_TOKEN
+	admin := api.Group("/admin", handlers.RequireAdmin)
+	admin.Delete("/events/:id", handlers.HardDeleteEvent)
+
+	api.Use(handlers.RequireAuth)
+	api.Post("/auth/logout", handlers.Logout)
+	api.Get("/auth/me", handlers.Me)
+	api.Put("/auth/password", handlers.ChangePassword)
+	api.Get("/auth/tokens", handlers.ListTokens)
+	api.Post("/auth/tokens", handlers.CreateToken)
+	api.Delete("/auth/tokens/:id", handlers.DeleteToken)
+
 	events := api.Group("/events")
 
 	events.Get("/", handlers.GetEvents)
 	events.Post("/", handlers.CreateEvent)
+	events.Get("/trash", handlers.ListTrash)
+	events.Get("/:id", handlers.GetEvent)
 	events.Put("/:id", handlers.UpdateEvent)
+	events.Patch("/:id", handlers.PatchEvent)
 	events.Delete("/:id", handlers.DeleteEvent)
+	events.Post("/:id/restore", handlers.RestoreEvent)
+	events.Get("/:id/history", handlers.GetEventHistory)
+	events.Post("/:id/respond", handlers.RespondToInvitation)
+	api.Get("/invitations", handlers.ListInvitations)
+	api.Get("/history", handlers.ListHistory)
+	api.Get("/availability", handlers.GetAvailability)
+
+	calendars := api.Group("/calendars")
+	calendars.Get("/", handlers.ListCalendars)
+	calendars.Post("/", handlers.CreateCalendar)
+	calendars.Get("/:id", handlers.GetCalendar)
+	calendars.Patch("/:id", handlers.UpdateCalendar)
+	calendars.Delete("/:id", handlers.DeleteCalendar)
+	calendars.Get("/:id/shares", handlers.ListShares)
+	calendars.Put("/:id/shares", handlers.ShareCalendar)
+	calendars.Delete("/:id/shares/:userId", handlers.Unshare)
+
+	// iCalendar feed for subscribing clients
+	api.Get("/calendar/feed", handlers.GetFeed)
+	api.Post("/calendar/feed", handlers.RegenerateFeed)
+	api.Delete("/calendar/feed", handlers.DeleteFeed)
+	api.Post("/import/ics", handlers.ImportICS)
+
+	// CalDAV access for native calendar clients
+	app.All("/.well-known/caldav", handlers.CalDAVWellKnown)
+	dav := app.Group("/caldav")
+	dav.Options("/*", handlers.CalDAVOptions)
+	dav.Use(handlers.RequireDAVAuth)
+	dav.Add("PROPFIND", "/", handlers.PropfindRoot)
+	dav.Add("PROPFIND", "/calendar", handlers.PropfindCollection)
+	dav.Add("PROPFIND", "/calendar/:name", handlers.PropfindObject)
+	dav.Add("REPORT", "/calendar", handlers.CalDAVReport)
+	dav.Get("/calendar/:name", handlers.GetCalendarObject)
+	dav.Put("/calendar/:name", handlers.PutCalendarObject)
+	dav.Delete("/calendar/:name", handlers.DeleteCalendarObject)
 
 	// Add chat endpoint
 	api.Post("/chat", handlers.HandleChat)
+	api.Get("/chat/conversations", handlers.ListConversations)
+	api.Get("/chat/conversations/:id", handlers.GetConversation)
+	api.Delete("/chat/conversations/:id", handlers.DeleteConversation)
+	api.Get("/chat/actions", handlers.ListActions)
+	api.Post("/chat/actions/:id/confirm", handlers.ConfirmAction)
+	api.Post("/chat/actions/:id/reject", handlers.RejectAction)
+	api.Post("/chat/undo", handlers.UndoLastAction)
+	api.Get("/chat/settings", handlers.GetAutoApply)
+	api.Put("/chat/settings", handlers.SetAutoApply)
 
 	// Add basic health check endpoint
 	app.Get("/", func(c *fiber.Ctx) error {
@@ -90,3 +193,67 @@ func main() {
 		log.Printf("❌ Server error: %v", err)
 	}
 }
+
+// purgeTrash removes events that have been in the trash longer than the
+// retention, at startup and then every interval.
+func purgeTrash(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, err := repository.PurgeTrash(time.Now().Add(-repository.TrashRetention))
+		if err != nil {
+			log.Printf("❌ Failed to purge trash: %v", err)
+		} else if purged > 0 {
+			log.Printf("🗑️ Purged %d events from the trash", purged)
+		}
+		<-ticker.C
+	}
+}
+
+// deliverMail sends the messages in the outbox every interval.
+func deliverMail(config *imip.Config, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		mails, err := repository.PendingMail(100)
+		if err != nil {
+			log.Printf("❌ Failed to load outbox: %v", err)
+		}
+		for i := range mails {
+			mail := &mails[i]
+			sendErr := config.Send(&imip.Message{
+				From:      config.From,
+				Organizer: mail.Organizer,
+				To:        mail.Recipients,
+				Subject:   mail.Subject,
+				Body:      mail.Body,
+				Method:    mail.Method,
+				Calendar:  []byte(mail.Calendar),
+			})
+			if sendErr != nil {
+				log.Printf("❌ Failed to send %s for event %s: %v", mail.Method, mail.EventID, sendErr)
+			} else {
+				log.Printf("📧 Sent %s for event %s to %d attendees", mail.Method, mail.EventID, len(mail.Recipients))
+			}
+			if err := repository.MarkMailSent(mail, sendErr); err != nil {
+				log.Printf("❌ %v", err)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// readInbox applies the invitation replies dropped into dir every interval.
+func readInbox(dir string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		applied, err := imip.ProcessInbox(dir, repository.ApplyResponses)
+		if err != nil {
+			log.Printf("❌ Failed to process inbox: %v", err)
+		} else if applied > 0 {
+			log.Printf("📬 Applied %d invitation replies", applied)
+		}
+		<-ticker.C
+	}
+}
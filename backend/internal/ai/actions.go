@@ -0,0 +1,364 @@
+package ai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/recurrence"
+	"calendar-backend/internal/repository"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Statuses of an ActionResult.
+const (
+	ResultApplied    = "applied"
+	ResultFailed     = "failed"
+	ResultRolledBack = "rolled_back" // succeeded, but undone because another action failed
+	ResultNotRun     = "not_run"     // never attempted because an earlier action failed
+)
+
+// ActionResult reports the outcome of one action of a batch.
+type ActionResult struct {
+	Type    string `json:"type"`
+	Title   string `json:"title,omitempty"`
+	EventID string `json:"event_id,omitempty"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	// Fields lists the invalid fields when the action failed validation.
+	Fields []models.FieldError `json:"fields,omitempty"`
+	// Conflicts are the busy events the applied change overlaps.
+	Conflicts []models.Event `json:"conflicts,omitempty"`
+}
+
+// ExecuteCalendarActions applies a batch of calendar actions in a single
+// transaction: either all of them are applied or, if one fails, none are.
+// The changes are made in db, the calendar of the user, and audited as made
+// by actor. On success the IDs of created events are stored back into the
+// actions.
+func ExecuteCalendarActions(db *gorm.DB, actions []CalendarAction, actor models.Actor) ([]ActionResult, error) {
+	results := make([]ActionResult, len(actions))
+	for i, action := range actions {
+		results[i] = ActionResult{Type: action.Type, Title: action.Title, EventID: action.EventID, Status: ResultNotRun}
+	}
+
+	// Work on a copy so IDs of rolled back creates don't leak into the actions.
+	executed := append([]CalendarAction{}, actions...)
+	failed := -1
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i := range executed {
+			conflicts, err := executeCalendarAction(tx, actor, &executed[i])
+			if err != nil {
+				failed = i
+				return err
+			}
+			results[i].EventID = executed[i].EventID
+			results[i].Conflicts = conflicts
+			results[i].Status = ResultApplied
+		}
+		return nil
+	})
+	if err != nil {
+		for i := 0; i < failed; i++ {
+			results[i].Status = ResultRolledBack
+			results[i].EventID = actions[i].EventID
+		}
+		if failed >= 0 {
+			results[failed].Status = ResultFailed
+			results[failed].Error = err.Error()
+			var invalid *models.ValidationError
+			if errors.As(err, &invalid) {
+				results[failed].Fields = invalid.Fields
+			}
+			return results, fmt.Errorf("action %d (%s) failed: %v", failed+1, actions[failed].Type, err)
+		}
+		return results, err
+	}
+
+	copy(actions, executed)
+	return results, nil
+}
+
+// ConflictNotes describes the conflicts reported in results for the chat
+// reply, one note per overlapping event.
+func ConflictNotes(results []ActionResult) []string {
+	var notes []string
+	for _, result := range results {
+		for _, conflict := range result.Conflicts {
+			notes = append(notes, conflictNote(result.Title, &conflict, conflict.FormatTime))
+		}
+	}
+	return notes
+}
+
+// conflictNote describes a conflict of the event titled title, rendering
+// the conflict's times with format.
+func conflictNote(title string, conflict *models.Event, format func(time.Time) string) string {
+	subject := "The changed event"
+	if title != "" {
+		subject = fmt.Sprintf("%q", title)
+	}
+	return fmt.Sprintf("%s overlaps %q (%s to %s)", subject, conflict.Title, format(conflict.Start), format(conflict.End))
+}
+
+// errDryRun rolls back the transaction of previewAction.
+var errDryRun = errors.New("dry run")
+
+// previewAction applies the action to db in a transaction that is rolled
+// back, to find out whether the user userID can apply it and which busy
+// events it would overlap.
+func previewAction(db *gorm.DB, userID string, action CalendarAction) ([]models.Event, error) {
+	log.Printf("Previewing calendar action %s %s\n", action.Type, action.EventID)
+	var conflicts []models.Event
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if conflicts, err = executeCalendarAction(tx, models.Actor{Type: models.ActorAI, UserID: userID}, &action); err != nil {
+			return err
+		}
+		return errDryRun
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return nil, err
+	}
+	return conflicts, nil
+}
+
+// executeCalendarAction applies a single action inside tx on behalf of actor
+// and returns the busy events the created or updated event now overlaps. For
+// "create" actions the new event's ID is stored back into action.EventID.
+// Events of calendars shared with the user are changed in their owner's
+// calendar, provided the user may edit them.
+func executeCalendarAction(tx *gorm.DB, actor models.Actor, action *CalendarAction) ([]models.Event, error) {
+	log.Printf("Executing calendar action: %+v\n", action)
+
+	switch action.Type {
+	case "response":
+		// Do nothing, just return the message
+		return nil, nil
+	case "create":
+		event := models.Event{
+			ID:          uuid.New().String(),
+			UserID:      actor.UserID,
+			Title:       action.Title,
+			Description: action.Description,
+			Start:       action.Start.UTC(),
+			End:         action.End.UTC(),
+			TimeZone:    action.TimeZone,
+			AllDay:      action.AllDay != nil && *action.AllDay,
+			RRule:       action.RRule,
+		}
+		event.Attendees, _ = mergeAttendees(nil, action.Invite, nil)
+		if action.Calendar != "" {
+			found, err := findCalendar(tx, actor.UserID, action.Calendar)
+			if err != nil {
+				return nil, err
+			}
+			owner, calendar, err := repository.CalendarAccess(tx, actor.UserID, found.ID, models.RoleEdit)
+			if err != nil {
+				return nil, err
+			}
+			tx, event.UserID, event.CalendarID = owner, calendar.UserID, calendar.ID
+		}
+		calendar, err := repository.AssignCalendar(tx, &event)
+		if err != nil {
+			return nil, err
+		}
+		calendar.ApplyTimeZone(&event)
+		if err := repository.FillOrganizer(tx, &event); err != nil {
+			return nil, err
+		}
+		if err := event.Validate(); err != nil {
+			return nil, err
+		}
+		log.Printf("Creating event: %+v\n", event)
+		audit, err := repository.BeginAudit(tx)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Create(&event).Error; err != nil {
+			log.Printf("Error creating event: %v\n", err)
+			return nil, err
+		}
+		if err := audit.Record(actor, event.ID); err != nil {
+			return nil, err
+		}
+		action.EventID = event.ID
+		log.Printf("Successfully created event with ID: %s\n", event.ID)
+		return visibleConflicts(tx, actor.UserID, &event)
+
+	case "update":
+		log.Printf("Updating event with ID: %s (scope: %s)\n", action.EventID, action.Scope)
+		scope, err := repository.ParseScope(action.Scope)
+		if err != nil {
+			return nil, err
+		}
+		tx, _, err := repository.EventAccess(tx, actor.UserID, action.EventID, models.RoleEdit)
+		if err != nil {
+			return nil, err
+		}
+		// Only send the fields the model filled in so omitted ones keep their values.
+		updates := map[string]interface{}{}
+		if action.Title != "" {
+			updates["title"] = action.Title
+		}
+		if action.Description != "" {
+			updates["description"] = action.Description
+		}
+		if !action.Start.IsZero() {
+			updates["start"] = action.Start.UTC()
+		}
+		if !action.End.IsZero() {
+			updates["end"] = action.End.UTC()
+		}
+		if action.AllDay != nil {
+			updates["all_day"] = *action.AllDay
+			updates["floating"] = false
+			updates["time_zone"] = action.TimeZone
+		} else if action.TimeZone != "" {
+			updates["time_zone"] = action.TimeZone
+		}
+		if action.RRule != "" {
+			if _, err := recurrence.Parse(action.RRule); err != nil {
+				return nil, fmt.Errorf("invalid rrule: %v", err)
+			}
+			updates["rrule"] = action.RRule
+		}
+		if action.Calendar != "" {
+			calendar, err := findCalendar(tx, actor.UserID, action.Calendar)
+			if err != nil {
+				return nil, err
+			}
+			updates["calendar_id"] = calendar.ID
+		}
+		if len(action.Invite) > 0 || len(action.Uninvite) > 0 {
+			current, err := repository.GetEvent(tx, action.EventID)
+			if err != nil {
+				return nil, err
+			}
+			attendees, err := mergeAttendees(current.Attendees, action.Invite, action.Uninvite)
+			if err != nil {
+				return nil, err
+			}
+			// Map updates bypass the column's JSON serializer.
+			data, err := json.Marshal(attendees)
+			if err != nil {
+				return nil, err
+			}
+			updates["attendees"] = string(data)
+		}
+		audit, err := repository.BeginAudit(tx, action.EventID)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := repository.UpdateEvent(tx, action.EventID, updates, scope, action.RecurrenceID)
+		if err != nil {
+			log.Printf("Error updating event: %v\n", err)
+			return nil, err
+		}
+		if err := repository.FillOrganizer(tx, updated); err != nil {
+			return nil, err
+		}
+		if err := updated.Validate(); err != nil {
+			return nil, err
+		}
+		if err := audit.Record(actor, updated.ID); err != nil {
+			return nil, err
+		}
+		log.Printf("Successfully updated event with ID: %s\n", updated.ID)
+		return visibleConflicts(tx, actor.UserID, updated)
+
+	case "delete":
+		log.Printf("Deleting event with ID: %s (scope: %s)\n", action.EventID, action.Scope)
+		scope, err := repository.ParseScope(action.Scope)
+		if err != nil {
+			return nil, err
+		}
+		tx, _, err := repository.EventAccess(tx, actor.UserID, action.EventID, models.RoleEdit)
+		if err != nil {
+			return nil, err
+		}
+		audit, err := repository.BeginAudit(tx, action.EventID)
+		if err != nil {
+			return nil, err
+		}
+		if err := repository.DeleteEvent(tx, action.EventID, scope, action.RecurrenceID); err != nil {
+			log.Printf("Error deleting event: %v\n", err)
+			return nil, err
+		}
+		if err := audit.Record(actor); err != nil {
+			return nil, err
+		}
+		log.Printf("Successfully deleted event with ID: %s\n", action.EventID)
+		return nil, nil
+
+	default:
+		err := fmt.Errorf("unknown action type: %s", action.Type)
+		log.Printf("Error: %v\n", err)
+		return nil, err
+	}
+}
+
+// mergeAttendees returns attendees with the emails in invite added, as
+// required attendees that haven't responded yet, and the attendees named in
+// uninvite, by email or name, removed.
+func mergeAttendees(attendees []models.Attendee, invite, uninvite []string) ([]models.Attendee, error) {
+	event := models.Event{Attendees: append([]models.Attendee{}, attendees...)}
+	for _, email := range invite {
+		if event.Attendee(email) == nil {
+			event.Attendees = append(event.Attendees, models.Attendee{Email: email})
+		}
+	}
+	for _, person := range uninvite {
+		kept := event.Attendees[:0]
+		for _, attendee := range event.Attendees {
+			if attendee.Email != models.NormalizeEmail(person) && !strings.EqualFold(attendee.Name, strings.TrimSpace(person)) {
+				kept = append(kept, attendee)
+			}
+		}
+		if len(kept) == len(event.Attendees) {
+			return nil, fmt.Errorf("%q is not invited to the event", person)
+		}
+		event.Attendees = kept
+	}
+	event.NormalizeAttendees()
+	return event.Attendees, nil
+}
+
+// findCalendar looks up a calendar the user userID may add events to by the
+// name the model gave, listing the calendars there are if none has that name.
+func findCalendar(tx *gorm.DB, userID, name string) (*models.Calendar, error) {
+	access, err := repository.LoadAccess(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if calendar := access.FindByName(name, models.RoleEdit); calendar != nil {
+		return calendar, nil
+	}
+	var names []string
+	for _, calendar := range access.Calendars {
+		if calendar.Role.Allows(models.RoleEdit) {
+			names = append(names, fmt.Sprintf("%q", calendar.Name))
+		}
+	}
+	return nil, fmt.Errorf("there is no calendar named %q you can add events to; the calendars are %s", name, strings.Join(names, ", "))
+}
+
+// visibleConflicts returns the busy events the event overlaps as the user
+// userID may see them.
+func visibleConflicts(tx *gorm.DB, userID string, event *models.Event) ([]models.Event, error) {
+	conflicts, err := repository.FindConflicts(tx, event)
+	if err != nil || len(conflicts) == 0 {
+		return conflicts, err
+	}
+	access, err := repository.LoadAccess(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return access.Filter(conflicts), nil
+}
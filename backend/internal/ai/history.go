@@ -0,0 +1,17 @@
+package ai
+
+import "calendar-backend/internal/models"
+
+// HistoryContent renders a stored chat message for the model. Assistant
+// replies carry the calendar actions that were proposed or taken, including the
+// affected event_id, so follow-ups like "make it 3pm instead" can be resolved.
+func HistoryContent(message models.ChatMessage) string {
+	if len(message.Actions) == 0 {
+		return message.Content
+	}
+	status := message.ActionStatus
+	if status == "" {
+		status = "taken"
+	}
+	return message.Content + "\n\n[calendar actions " + status + ": " + string(message.Actions) + "]"
+}
@@ -7,18 +7,24 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"regexp"
 	"strings"
 	"time"
 
 	"calendar-backend/internal/models"
-	"calendar-backend/internal/repository"
 
-	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
-// AIProvider interface defines methods that any AI provider must implement
+// AIProvider interface defines methods that any AI provider must implement.
+// db is the calendar of the user userID the tools look events up in, along
+// with the calendars shared with them, and history holds the earlier turns
+// of the conversation, oldest first.
 type AIProvider interface {
-	Query(prompt string, timezone string) (string, *CalendarAction, error)
+	Query(db *gorm.DB, userID string, history []models.ChatMessage, prompt string, timezone string) (string, []CalendarAction, error)
+	// Actor identifies the provider and model as the author of the changes
+	// they propose.
+	Actor() models.Actor
 }
 
 type OllamaProvider struct {
@@ -26,170 +32,114 @@ type OllamaProvider struct {
 	Model   string
 }
 
-type OllamaRequest struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-	System string `json:"system"`
-	Stream bool   `json:"stream"`
+type OllamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// Thinking holds the reasoning of thinking models, which newer Ollama
+	// versions return separately from the content.
+	Thinking  string           `json:"thinking,omitempty"`
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"` // For role "tool"
 }
 
-type OllamaStreamResponse struct {
-	Response   string `json:"response"`
-	Done       bool   `json:"done"`
-	DoneReason string `json:"done_reason,omitempty"`
+type OllamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"` // A JSON object, unlike OpenAI
+	} `json:"function"`
 }
 
-type CalendarAction struct {
-	Type        string    `json:"type"` // "response", "create", "update", or "delete"
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Start       time.Time `json:"start"`
-	End         time.Time `json:"end"`
-	EventID     string    `json:"event_id,omitempty"` // For update/delete
+type OllamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []OllamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Tools    []Tool          `json:"tools,omitempty"`
+	// Format constrains the reply to a JSON schema.
+	Format *Schema `json:"format,omitempty"`
 }
 
-type AIResponse struct {
-	Message string          `json:"message"` // The text response
-	Action  *CalendarAction `json:"action"`  // Optional calendar action
+type OllamaStreamResponse struct {
+	Message    OllamaMessage `json:"message"`
+	Done       bool          `json:"done"`
+	DoneReason string        `json:"done_reason,omitempty"`
+	Error      string        `json:"error,omitempty"`
 }
 
-type EventInfo struct {
+type CalendarAction struct {
+	Type        string    `json:"type"` // "response", "create", "update", "delete" or "undo"
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	Start       time.Time `json:"start"`
 	End         time.Time `json:"end"`
+	// TimeZone anchors the event to a zone; all-day events span whole dates
+	// from Start to the midnight at End, stored as if in UTC.
+	TimeZone string `json:"timezone,omitempty"`
+	AllDay   *bool  `json:"all_day,omitempty"`
+	RRule    string `json:"rrule,omitempty"`    // For recurring events
+	EventID  string `json:"event_id,omitempty"` // For update/delete
+	// Calendar names the calendar a created event goes in, or an updated
+	// event moves to.
+	Calendar string `json:"calendar,omitempty"`
+	// Invite lists the emails of people to add as attendees, Uninvite the
+	// emails or names of attendees to remove.
+	Invite   []string `json:"invite,omitempty"`
+	Uninvite []string `json:"uninvite,omitempty"`
+	// For update/delete of recurring events: "this", "following" or "all",
+	// plus the start time of the targeted occurrence.
+	Scope        string     `json:"scope,omitempty"`
+	RecurrenceID *time.Time `json:"recurrence_id,omitempty"`
 }
 
-const BaseSystemPrompt = `You are a helpful calendar assistant. You can help users manage their schedule, 
-create events, and provide suggestions about time management. Please provide concise and practical responses.
-
-Once you create the events, ask the user if it is correct. If it is not, ask the user for the changes they would like to make.
-
-IMPORTANT: The current date is {{.CurrentDate}} and the user's time zone is {{.TimeZone}}. The user will give their event times in their local time zone.  
-Convert these times to UTC and respond with the UTC times. For example, if the user says "I have a meeting at 2 PM" and their time zone is EST, 
-convert that to UTC by adding 5 hours (since EST is UTC-5). So the UTC time would be 7:00 PM.  Therefore the start time would be 2025-01-02T19:00:00Z and the end time would be 2025-01-02T20:00:00Z.
-
-IMPORTANT: You MUST respond with a valid JSON object containing a "message" field and optionally an "action" field.
-DO NOT include any thinking process or markdown outside the JSON.
-
-IMPORTANT: All events must be in the future.
-
-Example response formats:
-
-For simple responses (no calendar action):
-{
-    "message": "Your next meeting is at 2 PM today!",
-    "action": {
-        "type": "response"
-    }
-}
-
-For calendar modifications:
-{
-    "message": "I've added your ballet class to the calendar! The time slot from 2 PM to 3 PM is free.",
-    "action": {
-        "type": "create",
-        "title": "Ballet Class",
-        "description": "Weekly dance session",
-        "start": "2025-01-31T14:00:00Z",
-        "end": "2025-01-31T15:00:00Z"
-    }
+// AIResponse is the final answer of JSON-mode models, with calendar changes
+// proposed inline rather than through tool calls.
+type AIResponse struct {
+	Message string            `json:"message"`           // The text response
+	Actions []ActionArguments `json:"actions,omitempty"` // Calendar actions, applied together
 }
 
-When responding to schedule-related queries:
-1. Format messages in markdown (inside the JSON "message" field)
-2. Use bullet points for time slots
-3. Highlight important events or conflicts
-4. Keep responses concise but informative
-
-When modifying the calendar:
-1. Always include both "message" and "action" fields in your JSON response
-2. Set action "type" to one of: "create", "update", or "delete"
-3. Include all necessary event details (title, description, start, end times)
-4. For updates and deletions, include the event_id
-5. Format times in RFC3339 format
-6. Check for conflicts before suggesting times
-
-Current Schedule:
-{{.Schedule}}`
-
-func GetFormattedSchedule() (string, error) {
-	var events []EventInfo
-	if err := repository.DB.Model(&models.Event{}).Find(&events).Error; err != nil {
-		return "", fmt.Errorf("failed to fetch events: %v", err)
-	}
-
-	var schedule strings.Builder
-	schedule.WriteString("Here are the current events:\n")
-
-	for _, event := range events {
-		schedule.WriteString(fmt.Sprintf("- %s: %s to %s (%s)\n",
-			event.Title,
-			event.Start.Format("Mon Jan 2 3:04 PM"),
-			event.End.Format("3:04 PM"),
-			event.Description))
-	}
-
-	return schedule.String(), nil
+// ollamaAnswerPrompt is appended to ToolSystemPrompt: small local models are
+// more reliable when their final answer is a JSON object, which also lets
+// models without tool support propose changes inline.
+const ollamaAnswerPrompt = `
+
+When you are done calling tools, answer with a JSON object with a "message" field holding your reply to the user.
+Models that can't call tools may instead list calendar changes in an "actions" array, e.g.
+{"message": "Shall I add your ballet class?", "actions": [{"type": "create", "title": "Ballet Class", "start": "2025-01-31T14:00:00", "end": "2025-01-31T15:00:00"}]}
+DO NOT include any thinking process or markdown outside the JSON.`
+
+// ResponseSchema describes the final answer expected from JSON-mode models.
+var ResponseSchema = &Schema{
+	Type: "object",
+	Properties: map[string]*Schema{
+		"message": {Type: "string", Description: "Reply to the user, in markdown"},
+		"actions": {Type: "array", Items: &Schema{
+			Type: "object",
+			Properties: map[string]*Schema{
+				"type":          {Type: "string", Enum: []string{"create", "update", "delete", "undo"}},
+				"title":         {Type: "string"},
+				"description":   {Type: "string"},
+				"start":         localTime("Event start"),
+				"end":           localTime("Event end"),
+				"timezone":      timezoneSchema,
+				"all_day":       allDaySchema,
+				"rrule":         {Type: "string"},
+				"calendar":      calendarSchema,
+				"invite":        inviteSchema,
+				"uninvite":      uninviteSchema,
+				"event_id":      {Type: "string"},
+				"scope":         scopeSchema,
+				"recurrence_id": recurrenceIDSchema,
+			},
+			Required: []string{"type"},
+		}},
+	},
+	Required: []string{"message"},
 }
 
-func executeCalendarAction(action *CalendarAction) error {
-	log.Printf("Executing calendar action: %+v\n", action)
-
-	switch action.Type {
-	case "response":
-		// Do nothing, just return the message
-		return nil
-	case "create":
-		event := models.Event{
-			ID:          uuid.New().String(),
-			Title:       action.Title,
-			Description: action.Description,
-			Start:       action.Start.UTC(),
-			End:         action.End.UTC(),
-			Color:       "var(--tokyo-purple)", // Add default color
-		}
-		log.Printf("Creating event: %+v\n", event)
-		if err := repository.DB.Create(&event).Error; err != nil {
-			log.Printf("Error creating event: %v\n", err)
-			return err
-		}
-		log.Printf("Successfully created event with ID: %s\n", event.ID)
-		return nil
-
-	case "update":
-		log.Printf("Updating event with ID: %s\n", action.EventID)
-		result := repository.DB.Model(&models.Event{}).
-			Where("id = ?", action.EventID).
-			Updates(map[string]interface{}{
-				"title":       action.Title,
-				"description": action.Description,
-				"start":       action.Start.UTC(),
-				"end":         action.End.UTC(),
-			})
-		if result.Error != nil {
-			log.Printf("Error updating event: %v\n", result.Error)
-			return result.Error
-		}
-		log.Printf("Successfully updated event. Rows affected: %d\n", result.RowsAffected)
-		return nil
-
-	case "delete":
-		log.Printf("Deleting event with ID: %s\n", action.EventID)
-		result := repository.DB.Delete(&models.Event{}, "id = ?", action.EventID)
-		if result.Error != nil {
-			log.Printf("Error deleting event: %v\n", result.Error)
-			return result.Error
-		}
-		log.Printf("Successfully deleted event. Rows affected: %d\n", result.RowsAffected)
-		return nil
-
-	default:
-		err := fmt.Errorf("unknown action type: %s", action.Type)
-		log.Printf("Error: %v\n", err)
-		return err
-	}
-}
+var (
+	thinkBlock = regexp.MustCompile(`(?is)<think(?:ing)?>.*?</think(?:ing)?>`)
+	codeFence  = regexp.MustCompile("(?s)^```[a-zA-Z]*\\s*(.*?)\\s*```$")
+)
 
 func NewOllamaProvider(baseURL string, model string) *OllamaProvider {
 	return &OllamaProvider{
@@ -198,91 +148,185 @@ func NewOllamaProvider(baseURL string, model string) *OllamaProvider {
 	}
 }
 
-func (p *OllamaProvider) Query(prompt string, timezone string) (string, *CalendarAction, error) {
-	schedule, err := GetFormattedSchedule()
+func (p *OllamaProvider) Actor() models.Actor {
+	return models.Actor{Type: models.ActorAI, Provider: "ollama", Model: p.Model}
+}
+
+// Query runs one chat turn against /api/chat. The model can call the calendar
+// tools; its final answer must match ResponseSchema, and if it doesn't, the
+// answer is requested again with the schema enforced through "format".
+func (p *OllamaProvider) Query(db *gorm.DB, userID string, history []models.ChatMessage, prompt string, timezone string) (string, []CalendarAction, error) {
+	session, err := newToolSession(db, userID, timezone)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to get schedule: %v", err)
+		return "", nil, err
+	}
+
+	messages := []OllamaMessage{{Role: "system", Content: session.systemPrompt(ollamaAnswerPrompt)}}
+	for _, message := range history {
+		messages = append(messages, OllamaMessage{Role: message.Role, Content: HistoryContent(message)})
 	}
+	messages = append(messages, OllamaMessage{Role: "user", Content: prompt})
+
+	request := OllamaChatRequest{Model: p.Model, Stream: true, Tools: CalendarTools}
+	for round := 0; round < maxToolRounds; round++ {
+		request.Messages = messages
+		reply, err := p.chat(request)
+		if err != nil {
+			return "", nil, err
+		}
 
-	currentSystemPrompt := strings.Replace(BaseSystemPrompt, "{{.Schedule}}", schedule, 1)
-	currentSystemPrompt = strings.Replace(currentSystemPrompt, "{{.CurrentDate}}", time.Now().Format("2006-01-02"), 1)
-	currentSystemPrompt = strings.Replace(currentSystemPrompt, "{{.TimeZone}}", timezone, 1)
+		if len(reply.ToolCalls) > 0 {
+			messages = append(messages, OllamaMessage{Role: "assistant", Content: reply.Content, ToolCalls: reply.ToolCalls})
+			for _, call := range reply.ToolCalls {
+				messages = append(messages, OllamaMessage{
+					Role:     "tool",
+					ToolName: call.Function.Name,
+					Content:  session.call(call.Function.Name, toolArguments(call.Function.Arguments)),
+				})
+			}
+		} else {
+			answer, err := session.parseAnswer(reply.Content)
+			if err == nil {
+				return session.reply(answer.Message), session.actions, nil
+			}
 
-	request := OllamaRequest{
-		Model:  p.Model,
-		Prompt: prompt,
-		System: currentSystemPrompt,
-		Stream: true,
+			// Ask again with the answer constrained to the schema, and
+			// without tools since a tool call can't match it.
+			log.Printf("Invalid answer from Ollama, retrying with format enforced: %v\n", err)
+			session.invalid++
+			messages = append(messages,
+				OllamaMessage{Role: "assistant", Content: stripReasoning(reply.Content)},
+				OllamaMessage{Role: "user", Content: fmt.Sprintf("Your answer was invalid (%v). Answer again with only the JSON object.", err)},
+			)
+			request.Tools = nil
+			request.Format = ResponseSchema
+		}
+
+		if session.invalid > maxInvalidToolCalls {
+			return "", nil, fmt.Errorf("model made %d invalid calls or answers", session.invalid)
+		}
 	}
+	return "", nil, fmt.Errorf("model did not answer within %d rounds", maxToolRounds)
+}
 
+// chat sends one streamed /api/chat request and assembles the reply. The
+// reasoning of thinking models is logged and left out of the content.
+func (p *OllamaProvider) chat(request OllamaChatRequest) (*OllamaMessage, error) {
 	jsonData, err := json.Marshal(request)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to marshal request: %v", err)
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
 	}
 
-	resp, err := http.Post(p.BaseURL+"/api/generate", "application/json", bytes.NewBuffer(jsonData))
+	resp, err := http.Post(p.BaseURL+"/api/chat", "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to make request to Ollama: %v", err)
+		return nil, fmt.Errorf("failed to make request to Ollama: %v", err)
 	}
 	defer resp.Body.Close()
 
-	var fullResponse strings.Builder
+	if resp.StatusCode != http.StatusOK {
+		var failure OllamaStreamResponse
+		_ = json.NewDecoder(resp.Body).Decode(&failure)
+		return nil, fmt.Errorf("Ollama returned status code %d: %s", resp.StatusCode, failure.Error)
+	}
+
+	reply := &OllamaMessage{Role: "assistant"}
+	var content, thinking strings.Builder
 	scanner := bufio.NewScanner(resp.Body)
 	scanner.Buffer(make([]byte, 1024*1024), 1024*1024) // Increase scanner buffer
 
-	var aiResponse AIResponse
+	done := false
 	for scanner.Scan() {
 		var streamResp OllamaStreamResponse
 		if err := json.Unmarshal(scanner.Bytes(), &streamResp); err != nil {
+			log.Printf("Skipping malformed stream line: %v\n", err)
 			continue
 		}
+		if streamResp.Error != "" {
+			return nil, fmt.Errorf("Ollama error: %s", streamResp.Error)
+		}
 
+		content.WriteString(streamResp.Message.Content)
+		thinking.WriteString(streamResp.Message.Thinking)
+		reply.ToolCalls = append(reply.ToolCalls, streamResp.Message.ToolCalls...)
 		if streamResp.Done {
-			log.Printf("Full response received: %s\n", fullResponse.String())
-
-			// Clean up the response to extract JSON
-			response := fullResponse.String()
-			response = strings.TrimSpace(response)
-
-			// If response starts with a thinking process, try to find JSON
-			if strings.HasPrefix(response, "<think>") {
-				if start := strings.Index(response, "{"); start != -1 {
-					if end := strings.LastIndex(response, "}"); end != -1 {
-						response = response[start : end+1]
-					}
-				}
-			}
-
-			// Try to parse the cleaned response
-			if err := json.Unmarshal([]byte(response), &aiResponse); err != nil {
-				log.Printf("Failed to parse JSON response: %v\n", err)
-				log.Printf("Attempted to parse: %s\n", response)
-
-				// If parsing fails, create a simple message response
-				aiResponse = AIResponse{
-					Message: strings.TrimPrefix(strings.TrimSuffix(response, "</think>"), "<think>"),
-				}
-			}
-		} else {
-			fullResponse.WriteString(streamResp.Response)
+			done = true
+			break
 		}
 	}
-
 	if err := scanner.Err(); err != nil {
-		return "", nil, fmt.Errorf("error reading response: %v", err)
+		return nil, fmt.Errorf("error reading response: %v", err)
+	}
+	if !done {
+		return nil, fmt.Errorf("response from Ollama ended unexpectedly")
 	}
 
-	log.Printf("Parsed AI Response: %+v\n", aiResponse)
+	if thinking.Len() > 0 {
+		log.Printf("Model reasoning: %s\n", thinking.String())
+	}
+	reply.Content = content.String()
+	log.Printf("Full response received: %s (%d tool call(s))\n", reply.Content, len(reply.ToolCalls))
+	return reply, nil
+}
 
-	// Execute calendar action if present
-	if aiResponse.Action != nil {
-		log.Printf("Executing calendar action from AI response\n")
-		if err := executeCalendarAction(aiResponse.Action); err != nil {
-			return "", nil, fmt.Errorf("failed to execute calendar action: %v", err)
+// toolArguments normalises tool call arguments, which some models send as a
+// JSON encoded string rather than an object.
+func toolArguments(raw json.RawMessage) []byte {
+	var encoded string
+	if err := json.Unmarshal(raw, &encoded); err == nil {
+		return []byte(encoded)
+	}
+	return raw
+}
+
+// parseAnswer extracts the final JSON answer from a reply, dropping any
+// reasoning the model wrote into the content, validates it and adds its
+// inline actions to the session's proposals.
+func (s *toolSession) parseAnswer(content string) (*AIResponse, error) {
+	answer := stripReasoning(content)
+	if answer == "" {
+		return nil, fmt.Errorf("the answer is empty")
+	}
+	if err := ResponseSchema.ValidateJSON([]byte(answer)); err != nil {
+		return nil, err
+	}
+
+	var response AIResponse
+	if err := json.Unmarshal([]byte(answer), &response); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	// Only keep the inline actions if all of them are valid, so a retry
+	// doesn't propose the good ones twice.
+	proposed, notes := len(s.actions), len(s.notes)
+	for i, action := range response.Actions {
+		if err := s.propose(action); err != nil {
+			s.actions, s.notes = s.actions[:proposed], s.notes[:notes]
+			return nil, fmt.Errorf("actions[%d]: %v", i, err)
 		}
-	} else {
-		log.Printf("No calendar action in AI response\n")
 	}
+	return &response, nil
+}
 
-	return aiResponse.Message, aiResponse.Action, nil
+// stripReasoning removes <think> blocks, including a reasoning prefix whose
+// opening tag was part of the prompt template and an unterminated trailing
+// block, along with markdown code fences around the answer.
+func stripReasoning(content string) string {
+	content = thinkBlock.ReplaceAllString(content, "")
+	lower := strings.ToLower(content)
+	for _, tag := range []string{"</think>", "</thinking>"} {
+		if i := strings.LastIndex(lower, tag); i >= 0 {
+			content = content[i+len(tag):]
+			lower = lower[i+len(tag):]
+		}
+	}
+	for _, tag := range []string{"<think>", "<thinking>"} {
+		if i := strings.Index(lower, tag); i >= 0 {
+			content = content[:i]
+			lower = lower[:i]
+		}
+	}
+	content = strings.TrimSpace(content)
+	if match := codeFence.FindStringSubmatch(content); match != nil {
+		content = match[1]
+	}
+	return strings.TrimSpace(content)
 }
@@ -0,0 +1,268 @@
+package ai
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/repository"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Streams recorded from /api/chat, one JSON object per line.
+const (
+	// A thinking model that returns its reasoning separately, and also
+	// writes some of it into the content.
+	thinkingStream = `{"model":"qwen3:8b","created_at":"2026-10-17T09:00:00.000Z","message":{"role":"assistant","content":"","thinking":"The user asks about "},"done":false}
+{"model":"qwen3:8b","created_at":"2026-10-17T09:00:00.050Z","message":{"role":"assistant","content":"","thinking":"their day."},"done":false}
+{"model":"qwen3:8b","created_at":"2026-10-17T09:00:00.100Z","message":{"role":"assistant","content":"<think>Nothing is"},"done":false}
+{"model":"qwen3:8b","created_at":"2026-10-17T09:00:00.150Z","message":{"role":"assistant","content":" planned.</think>\n{\"message\": \"Your day"},"done":false}
+{"model":"qwen3:8b","created_at":"2026-10-17T09:00:00.200Z","message":{"role":"assistant","content":" is free.\"}"},"done":false}
+{"model":"qwen3:8b","created_at":"2026-10-17T09:00:00.250Z","message":{"role":"assistant","content":""},"done_reason":"stop","done":true,"total_duration":250000000,"eval_count":42}
+`
+	toolCallStream = `{"model":"llama3.1:8b","created_at":"2026-10-17T09:00:00.000Z","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"list_events","arguments":{"start":"2026-10-20T00:00:00","end":"2026-10-21T00:00:00"}}}]},"done":false}
+{"model":"llama3.1:8b","created_at":"2026-10-17T09:00:00.100Z","message":{"role":"assistant","content":""},"done_reason":"stop","done":true}
+`
+	answerStream = `{"model":"llama3.1:8b","created_at":"2026-10-17T09:00:01.000Z","message":{"role":"assistant","content":"{\"message\": \"You have "},"done":false}
+{"model":"llama3.1:8b","created_at":"2026-10-17T09:00:01.050Z","message":{"role":"assistant","content":"Dentist at 09:00.\"}"},"done":false}
+{"model":"llama3.1:8b","created_at":"2026-10-17T09:00:01.100Z","message":{"role":"assistant","content":""},"done_reason":"stop","done":true}
+`
+	malformedStream = `{"model":"llama3.1:8b","created_at":"2026-10-17T09:00:00.000Z","message":{"role":"assistant","content":"{\"message\": "},"done":false}
+{"model":"llama3.1:8b","created_at":"2026-10-17T09:00:00.0
+{"model":"llama3.1:8b","created_at":"2026-10-17T09:00:00.100Z","message":{"role":"assistant","content":"\"Done.\"}"},"done":false}
+{"model":"llama3.1:8b","created_at":"2026-10-17T09:00:00.150Z","message":{"role":"assistant","content":""},"done_reason":"stop","done":true}
+`
+	truncatedStream = `{"model":"llama3.1:8b","created_at":"2026-10-17T09:00:00.000Z","message":{"role":"assistant","content":"{\"message\": "},"done":false}
+`
+	invalidAnswerStream = `{"model":"llama3.1:8b","created_at":"2026-10-17T09:00:00.000Z","message":{"role":"assistant","content":"Sure, your day is free!"},"done":false}
+{"model":"llama3.1:8b","created_at":"2026-10-17T09:00:00.100Z","message":{"role":"assistant","content":""},"done_reason":"stop","done":true}
+`
+)
+
+// ollamaStub replays one recorded stream per /api/chat request and records
+// the requests it was sent.
+type ollamaStub struct {
+	*httptest.Server
+	mu       sync.Mutex
+	streams  []string
+	requests []OllamaChatRequest
+}
+
+func newOllamaStub(t *testing.T, streams ...string) *ollamaStub {
+	stub := &ollamaStub{streams: streams}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		var request OllamaChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.requests = append(stub.requests, request)
+		if len(stub.streams) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "no more recorded streams"})
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte(stub.streams[0]))
+		stub.streams = stub.streams[1:]
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+// testCalendar returns a database with a user that has a dentist appointment
+// at 09:00 UTC on 20 October 2026.
+func testCalendar(t *testing.T) (*gorm.DB, string) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "calendar.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Calendar{}, &models.CalendarShare{}, &models.Event{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	userID := "user-1"
+	calendar, err := repository.DefaultCalendar(db, userID)
+	if err != nil {
+		t.Fatalf("failed to create calendar: %v", err)
+	}
+	event := models.Event{
+		ID: "dentist", UserID: userID, CalendarID: calendar.ID, Title: "Dentist",
+		Start: time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC),
+		End:   time.Date(2026, 10, 20, 10, 0, 0, 0, time.UTC),
+	}
+	if err := db.Create(&event).Error; err != nil {
+		t.Fatalf("failed to create event: %v", err)
+	}
+	return db, userID
+}
+
+func TestOllamaChatThinking(t *testing.T) {
+	stub := newOllamaStub(t, thinkingStream)
+	provider := NewOllamaProvider(stub.URL, "qwen3:8b")
+
+	reply, err := provider.chat(OllamaChatRequest{Model: "qwen3:8b", Stream: true})
+	if err != nil {
+		t.Fatalf("chat failed: %v", err)
+	}
+	want := "<think>Nothing is planned.</think>\n{\"message\": \"Your day is free.\"}"
+	if reply.Content != want {
+		t.Errorf("content = %q, want %q", reply.Content, want)
+	}
+	if reply.Thinking != "" {
+		t.Errorf("thinking = %q, want it left out of the reply", reply.Thinking)
+	}
+}
+
+func TestOllamaQueryThinking(t *testing.T) {
+	db, userID := testCalendar(t)
+	stub := newOllamaStub(t, thinkingStream)
+	provider := NewOllamaProvider(stub.URL, "qwen3:8b")
+
+	message, actions, err := provider.Query(db, userID, nil, "What's on today?", "UTC")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if message != "Your day is free." {
+		t.Errorf("message = %q, want the answer without reasoning", message)
+	}
+	if len(actions) != 0 {
+		t.Errorf("got %d actions, want none", len(actions))
+	}
+}
+
+func TestOllamaQueryToolCall(t *testing.T) {
+	db, userID := testCalendar(t)
+	stub := newOllamaStub(t, toolCallStream, answerStream)
+	provider := NewOllamaProvider(stub.URL, "llama3.1:8b")
+
+	message, _, err := provider.Query(db, userID, nil, "What do I have on Tuesday?", "UTC")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if message != "You have Dentist at 09:00." {
+		t.Errorf("message = %q", message)
+	}
+	if len(stub.requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(stub.requests))
+	}
+	first := stub.requests[0]
+	if !first.Stream || len(first.Tools) == 0 || first.Format != nil {
+		t.Errorf("first request should stream with tools and no format: %+v", first)
+	}
+
+	// The tool call and its result are sent back along with the conversation.
+	messages := stub.requests[1].Messages
+	if len(messages) != 4 {
+		t.Fatalf("got %d messages in the second request, want 4", len(messages))
+	}
+	call, result := messages[2], messages[3]
+	if call.Role != "assistant" || len(call.ToolCalls) != 1 || call.ToolCalls[0].Function.Name != "list_events" {
+		t.Errorf("message 2 should be the tool call: %+v", call)
+	}
+	if result.Role != "tool" || result.ToolName != "list_events" {
+		t.Errorf("message 3 should be the tool result: %+v", result)
+	}
+	var listed struct {
+		Events []scheduleEntry `json:"events"`
+	}
+	if err := json.Unmarshal([]byte(result.Content), &listed); err != nil {
+		t.Fatalf("invalid tool result %q: %v", result.Content, err)
+	}
+	if len(listed.Events) != 1 || listed.Events[0].EventID != "dentist" {
+		t.Errorf("tool result = %s, want the dentist appointment", result.Content)
+	}
+}
+
+func TestOllamaQueryRetriesInvalidAnswer(t *testing.T) {
+	db, userID := testCalendar(t)
+	stub := newOllamaStub(t, invalidAnswerStream, answerStream)
+	provider := NewOllamaProvider(stub.URL, "llama3.1:8b")
+
+	message, _, err := provider.Query(db, userID, nil, "What's on today?", "UTC")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if message != "You have Dentist at 09:00." {
+		t.Errorf("message = %q", message)
+	}
+	if len(stub.requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(stub.requests))
+	}
+	if retry := stub.requests[1]; retry.Format == nil || len(retry.Tools) != 0 {
+		t.Errorf("retry should enforce the schema without tools: %+v", retry)
+	}
+}
+
+func TestOllamaChatSkipsMalformedLines(t *testing.T) {
+	stub := newOllamaStub(t, malformedStream)
+	provider := NewOllamaProvider(stub.URL, "llama3.1:8b")
+
+	reply, err := provider.chat(OllamaChatRequest{Model: "llama3.1:8b", Stream: true})
+	if err != nil {
+		t.Fatalf("chat failed: %v", err)
+	}
+	if want := `{"message": "Done."}`; reply.Content != want {
+		t.Errorf("content = %q, want %q", reply.Content, want)
+	}
+}
+
+func TestOllamaChatErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{
+			name: "status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":"model \"llama3.1:8b\" not found, try pulling it first"}`))
+			},
+			want: `Ollama returned status code 404: model "llama3.1:8b" not found`,
+		},
+		{
+			name: "stream error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"error":"an error was encountered while running the model"}` + "\n"))
+			},
+			want: "Ollama error: an error was encountered while running the model",
+		},
+		{
+			name: "truncated",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(truncatedStream))
+			},
+			want: "response from Ollama ended unexpectedly",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(test.handler)
+			defer server.Close()
+			provider := NewOllamaProvider(server.URL, "llama3.1:8b")
+
+			_, err := provider.chat(OllamaChatRequest{Model: "llama3.1:8b", Stream: true})
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
-	"time"
+
+	"calendar-backend/internal/models"
+
+	"gorm.io/gorm"
 )
 
 type OpenAIProvider struct {
@@ -15,21 +19,32 @@ type OpenAIProvider struct {
 }
 
 type OpenAIMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string           `json:"role"`
+	Content    string           `json:"content,omitempty"`
+	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type OpenAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"` // JSON encoded
+	} `json:"function"`
 }
 
 type OpenAIRequest struct {
 	Model       string          `json:"model"`
 	Messages    []OpenAIMessage `json:"messages"`
+	Tools       []Tool          `json:"tools,omitempty"`
 	Temperature float64         `json:"temperature"`
 }
 
 type OpenAIResponse struct {
 	Choices []struct {
-		Message struct {
-			Content string `json:"content"`
-		} `json:"message"`
+		Message      OpenAIMessage `json:"message"`
+		FinishReason string        `json:"finish_reason"`
 	} `json:"choices"`
 }
 
@@ -41,82 +56,66 @@ func NewOpenAIProvider(baseURL, model, apiKey string) *OpenAIProvider {
 	}
 }
 
-func (p *OpenAIProvider) Query(prompt string, timezone string) (string, *CalendarAction, error) {
-	schedule, err := GetFormattedSchedule()
+func (p *OpenAIProvider) Actor() models.Actor {
+	return models.Actor{Type: models.ActorAI, Provider: "openai", Model: p.Model}
+}
+
+// Query runs one chat turn using the tools API. The model looks up events
+// through list_events and find_free_time, and the calendar changes it asks
+// for through the other tools are returned as proposals.
+func (p *OpenAIProvider) Query(db *gorm.DB, userID string, history []models.ChatMessage, prompt string, timezone string) (string, []CalendarAction, error) {
+	session, err := newToolSession(db, userID, timezone)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to get schedule: %v", err)
+		return "", nil, err
 	}
 
-	currentSystemPrompt := fmt.Sprintf(`You are a helpful calendar assistant. You can help users manage their schedule, 
-create events, and provide suggestions about time management. Please provide concise and practical responses.
-
-Once you create the events, ask the user if it is correct. If it is not, ask the user for the changes they would like to make.
-
-IMPORTANT: The current date is %s and the user's time zone is %s. The user will give their event times in their local time zone.  
-Convert these times to UTC and respond with the UTC times. For example, if the user says "I have a meeting at 2 PM" and their time zone is EST, 
-convert that to UTC by adding 5 hours (since EST is UTC-5). So the UTC time would be 7:00 PM.  Therefore the start time would be 2025-01-02T19:00:00Z and the end time would be 2025-01-02T20:00:00Z.
-
-IMPORTANT: You MUST respond with a valid JSON object containing a "message" field and optionally an "action" field.
-DO NOT include any thinking process or markdown outside the JSON.
-
-IMPORTANT: All events must be in the future.
-
-Example response formats:
+	messages := []OpenAIMessage{{Role: "system", Content: session.systemPrompt("")}}
+	for _, message := range history {
+		messages = append(messages, OpenAIMessage{Role: message.Role, Content: HistoryContent(message)})
+	}
+	messages = append(messages, OpenAIMessage{Role: "user", Content: prompt})
 
-For simple responses (no calendar action):
-{
-    "message": "Your next meeting is at 2 PM today!",
-    "action": {
-        "type": "response"
-    }
-}
+	for round := 0; round < maxToolRounds; round++ {
+		reply, err := p.complete(messages)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(reply.ToolCalls) == 0 {
+			return session.reply(reply.Content), session.actions, nil
+		}
 
-For calendar modifications:
-{
-    "message": "I've added your ballet class to the calendar! The time slot from 2 PM to 3 PM is free.",
-    "action": {
-        "type": "create",
-        "title": "Ballet Class",
-        "description": "Weekly dance session",
-        "start": "2025-01-31T14:00:00Z",
-        "end": "2025-01-31T15:00:00Z"
-    }
+		messages = append(messages, *reply)
+		for _, call := range reply.ToolCalls {
+			messages = append(messages, OpenAIMessage{
+				Role:       "tool",
+				ToolCallID: call.ID,
+				Content:    session.call(call.Function.Name, []byte(call.Function.Arguments)),
+			})
+		}
+		if session.invalid > maxInvalidToolCalls {
+			return "", nil, fmt.Errorf("model made %d invalid tool calls", session.invalid)
+		}
+	}
+	return "", nil, fmt.Errorf("model did not answer within %d tool rounds", maxToolRounds)
 }
 
-When responding to schedule-related queries:
-1. Format messages in markdown (inside the JSON "message" field)
-2. Use bullet points for time slots
-3. Highlight important events or conflicts
-4. Keep responses concise but informative
-
-When modifying the calendar:
-1. Always include both "message" and "action" fields in your JSON response
-2. Set action "type" to one of: "create", "update", or "delete"
-3. Include all necessary event details (title, description, start, end times)
-4. For updates and deletions, include the event_id
-5. Format times in RFC3339 format with 'Z' suffix for UTC times
-6. Check for conflicts before suggesting times
-
-Current Schedule:
-%s`, time.Now().Format("2006-01-02"), timezone, schedule)
-
+// complete sends the conversation so far and returns the model's next message.
+func (p *OpenAIProvider) complete(messages []OpenAIMessage) (*OpenAIMessage, error) {
 	request := OpenAIRequest{
-		Model: p.Model,
-		Messages: []OpenAIMessage{
-			{Role: "system", Content: currentSystemPrompt},
-			{Role: "user", Content: prompt},
-		},
+		Model:       p.Model,
+		Messages:    messages,
+		Tools:       CalendarTools,
 		Temperature: 0.7,
 	}
 
 	jsonData, err := json.Marshal(request)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to marshal request: %v", err)
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
 	}
 
 	req, err := http.NewRequest("POST", p.BaseURL+"/v1/chat/completions", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -125,43 +124,24 @@ Current Schedule:
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to make request: %v", err)
+		return nil, fmt.Errorf("failed to make request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", nil, fmt.Errorf("OpenAI API returned status code %d", resp.StatusCode)
+		return nil, fmt.Errorf("OpenAI API returned status code %d", resp.StatusCode)
 	}
 
 	var openAIResp OpenAIResponse
 	if err := json.NewDecoder(resp.Body).Decode(&openAIResp); err != nil {
-		return "", nil, fmt.Errorf("failed to decode response: %v", err)
+		return nil, fmt.Errorf("failed to decode response: %v", err)
 	}
 
 	if len(openAIResp.Choices) == 0 {
-		return "", nil, fmt.Errorf("no response choices returned")
-	}
-
-	content := openAIResp.Choices[0].Message.Content
-
-	// Parse the response as JSON
-	var aiResponse AIResponse
-	if err := json.Unmarshal([]byte(content), &aiResponse); err != nil {
-		// If parsing fails, wrap the content in our own JSON structure
-		aiResponse = AIResponse{
-			Message: content,
-			Action: &CalendarAction{
-				Type: "response",
-			},
-		}
-	}
-
-	// Execute calendar action if present
-	if aiResponse.Action != nil {
-		if err := executeCalendarAction(aiResponse.Action); err != nil {
-			return "", nil, fmt.Errorf("failed to execute calendar action: %v", err)
-		}
+		return nil, fmt.Errorf("no response choices returned")
 	}
 
-	return aiResponse.Message, aiResponse.Action, nil
+	choice := openAIResp.Choices[0]
+	log.Printf("OpenAI reply (finish reason %s): %d tool call(s)\n", choice.FinishReason, len(choice.Message.ToolCalls))
+	return &choice.Message, nil
 }
@@ -0,0 +1,623 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"calendar-backend/internal/localtime"
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/recurrence"
+	"calendar-backend/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+// Tool is a function the model can call, in the format shared by the OpenAI
+// and Ollama chat APIs.
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Parameters  *Schema `json:"parameters"`
+}
+
+const (
+	// maxToolRounds bounds the request/tool-call round trips of one chat turn.
+	maxToolRounds = 6
+	// maxInvalidToolCalls is how many malformed tool calls the model may
+	// retry before the turn is given up.
+	maxInvalidToolCalls = 3
+	// maxListWindow bounds the range list_events and find_free_time accept.
+	maxListWindow = 366 * 24 * time.Hour
+)
+
+// ToolSystemPrompt is the system prompt for providers that use tool calling
+// rather than answering with JSON.
+const ToolSystemPrompt = `You are a helpful calendar assistant. You can help users manage their schedule,
+create events, and provide suggestions about time management. Please provide concise and practical responses.
+
+IMPORTANT: The current date is {{.CurrentDate}} and the user's time zone is {{.TimeZone}}. Do NOT convert times to UTC yourself.
+Pass times to the tools as local wall-clock times without a UTC offset, e.g. "2025-01-31T14:00:00" for 2 PM, and the server converts them.
+They are taken to be in the user's time zone; for an event in another time zone (e.g. a flight leaving Tokyo at 9 AM Tokyo time) also pass
+that zone's IANA name, e.g. "Asia/Tokyo", in "timezone". Times returned by the tools are local times in the user's time zone.
+For all-day events such as holidays or birthdays set "all_day" to true and pass dates like "2025-01-31" as start and end, where end is the last day.
+
+IMPORTANT: All events must be in the future.
+
+Use the tools to work with the calendar:
+1. Call list_events to look up the user's events for the dates in question before answering schedule questions or changing events
+2. Call find_free_time to find open slots before suggesting times, never guess them (pass "working_hours" such as "Mon-Fri 09:00-17:00" when the user means work time); proposed changes that overlap busy events come back with warnings, which you must tell the user about
+3. Call create_event, update_event and delete_event to change the calendar, once per event; a request touching several events needs several calls
+4. For recurring events pass an RFC 5545 "rrule" such as "FREQ=WEEKLY;BYDAY=MO,WE" or "FREQ=DAILY;COUNT=10"
+5. When updating or deleting a recurring event, set "scope" to "this" (only that occurrence), "following" (that occurrence and all later ones) or "all" (the whole series), and set "recurrence_id" to the occurrence's recurrence_id from list_events
+6. If a tool returns an error, fix the arguments and call it again
+7. When the user asks to undo your last change (e.g. "undo that"), call undo_last_change; it is not a proposal but carried out right away
+8. The user's calendars are {{.Calendars}}. New events go to the first one unless the user names another (e.g. "add to my Work calendar"); then pass its name in "calendar", which also moves an event with update_event
+9. Events from calendars shared with the user may be marked "read_only" in list_events and can't be changed. Those titled "Busy" only tell when someone is busy: never guess what they are about
+10. To invite people to an event pass their email addresses or names in "invite" of create_event or update_event, and in "uninvite" to remove them; if a name is unknown or ambiguous, ask the user for the email address
+11. list_events shows the attendees of events with their "status": "needs-action" means they haven't responded yet, otherwise they accepted, declined or tentatively accepted
+
+Calendar changes are NOT applied immediately: they are shown to the user as a proposal that they confirm or reject, all together.
+After proposing changes, describe them and ask the user to confirm. Never claim that a change has already been made.
+
+Reply to the user in markdown, using bullet points for time slots. Keep responses concise but informative.`
+
+func boolPtr(b bool) *bool        { return &b }
+func floatPtr(f float64) *float64 { return &f }
+func dateTime(description string) *Schema {
+	return &Schema{Type: "string", Format: "date-time", Description: description}
+}
+func localTime(description string) *Schema {
+	return &Schema{Type: "string", Pattern: localtime.Pattern, Description: description + ", as a local time such as 2025-01-31T14:00:00"}
+}
+
+var (
+	scopeSchema = &Schema{
+		Type:        "string",
+		Enum:        []string{"this", "following", "all"},
+		Description: "For recurring events: change only this occurrence, this and all following ones, or the whole series",
+	}
+	recurrenceIDSchema = dateTime("For recurring events: the recurrence_id of the targeted occurrence, as returned by list_events")
+	timezoneSchema     = &Schema{Type: "string", Description: "IANA time zone of the given times, e.g. Asia/Tokyo; defaults to the user's time zone"}
+	allDaySchema       = &Schema{Type: "boolean", Description: "Whether the event lasts whole days; start and end are then dates and end is the last day"}
+	calendarSchema     = &Schema{Type: "string", Description: "Name of the calendar the event goes in, e.g. Work; defaults to the user's default calendar"}
+	inviteSchema       = &Schema{Type: "array", Items: &Schema{Type: "string"}, Description: "People to invite, by email address or by name, e.g. [\"sam@example.com\", \"Alex\"]"}
+	uninviteSchema     = &Schema{Type: "array", Items: &Schema{Type: "string"}, Description: "Attendees to remove from the event, by email address or by name"}
+)
+
+// rangeSchema describes tools taking a start/end range plus extra arguments.
+func rangeSchema(extra map[string]*Schema, required ...string) *Schema {
+	properties := map[string]*Schema{
+		"start":    localTime("Start of the range"),
+		"end":      localTime("End of the range"),
+		"timezone": timezoneSchema,
+	}
+	for name, schema := range extra {
+		properties[name] = schema
+	}
+	return &Schema{
+		Type:                 "object",
+		Properties:           properties,
+		Required:             append([]string{"start", "end"}, required...),
+		AdditionalProperties: boolPtr(false),
+	}
+}
+
+// CalendarTools are the tools offered to the model on every chat turn.
+var CalendarTools = []Tool{
+	{Type: "function", Function: ToolFunction{
+		Name:        "list_events",
+		Description: "List the events (with recurring events expanded into occurrences) overlapping a date range",
+		Parameters:  rangeSchema(nil),
+	}},
+	{Type: "function", Function: ToolFunction{
+		Name:        "find_free_time",
+		Description: "Find free slots of at least the given length within a date range, optionally only within working hours",
+		Parameters: rangeSchema(map[string]*Schema{
+			"duration_minutes": {Type: "integer", Minimum: floatPtr(1), Description: "Minimum length of a free slot in minutes"},
+			"working_hours": {
+				Type:        "string",
+				Description: "Only look for slots within these hours, taken in the range's time zone, e.g. \"09:00-17:00\" or \"Mon-Fri 09:00-17:00\"",
+			},
+		}, "duration_minutes"),
+	}},
+	{Type: "function", Function: ToolFunction{
+		Name:        "create_event",
+		Description: "Propose creating an event",
+		Parameters: &Schema{
+			Type: "object",
+			Properties: map[string]*Schema{
+				"title":       {Type: "string", Description: "Event title"},
+				"description": {Type: "string", Description: "Event description"},
+				"start":       localTime("Event start"),
+				"end":         localTime("Event end"),
+				"timezone":    timezoneSchema,
+				"all_day":     allDaySchema,
+				"rrule":       {Type: "string", Description: "RFC 5545 recurrence rule for recurring events"},
+				"calendar":    calendarSchema,
+				"invite":      inviteSchema,
+			},
+			Required:             []string{"title", "start", "end"},
+			AdditionalProperties: boolPtr(false),
+		},
+	}},
+	{Type: "function", Function: ToolFunction{
+		Name:        "update_event",
+		Description: "Propose changing an event; only the given fields are changed",
+		Parameters: &Schema{
+			Type: "object",
+			Properties: map[string]*Schema{
+				"event_id":      {Type: "string", Description: "ID of the event, as returned by list_events"},
+				"title":         {Type: "string", Description: "New title"},
+				"description":   {Type: "string", Description: "New description"},
+				"start":         localTime("New start"),
+				"end":           localTime("New end"),
+				"timezone":      timezoneSchema,
+				"all_day":       allDaySchema,
+				"rrule":         {Type: "string", Description: "New RFC 5545 recurrence rule"},
+				"calendar":      calendarSchema,
+				"invite":        inviteSchema,
+				"uninvite":      uninviteSchema,
+				"scope":         scopeSchema,
+				"recurrence_id": recurrenceIDSchema,
+			},
+			Required:             []string{"event_id"},
+			AdditionalProperties: boolPtr(false),
+		},
+	}},
+	{Type: "function", Function: ToolFunction{
+		Name:        "delete_event",
+		Description: "Propose deleting an event",
+		Parameters: &Schema{
+			Type: "object",
+			Properties: map[string]*Schema{
+				"event_id":      {Type: "string", Description: "ID of the event, as returned by list_events"},
+				"scope":         scopeSchema,
+				"recurrence_id": recurrenceIDSchema,
+			},
+			Required:             []string{"event_id"},
+			AdditionalProperties: boolPtr(false),
+		},
+	}},
+	{Type: "function", Function: ToolFunction{
+		Name:        "undo_last_change",
+		Description: "Undo the most recent change made to the calendar in this conversation, once the user has asked for it",
+		Parameters: &Schema{
+			Type:                 "object",
+			Properties:           map[string]*Schema{},
+			AdditionalProperties: boolPtr(false),
+		},
+	}},
+}
+
+// scheduleEntry is how events are described to the model by list_events.
+// Start and End are local times in the user's time zone, except for all-day
+// events (dates, End being the last day) and floating events (their own wall
+// clock). TimeZone is the event's own zone, if it has one.
+type scheduleEntry struct {
+	EventID      string            `json:"event_id"`
+	Title        string            `json:"title"`
+	Description  string            `json:"description,omitempty"`
+	Start        string            `json:"start"`
+	End          string            `json:"end"`
+	AllDay       bool              `json:"all_day,omitempty"`
+	Floating     bool              `json:"floating,omitempty"`
+	TimeZone     string            `json:"timezone,omitempty"`
+	RecurrenceID *time.Time        `json:"recurrence_id,omitempty"`
+	RRule        string            `json:"rrule,omitempty"`
+	Calendar     string            `json:"calendar,omitempty"`
+	Organizer    string            `json:"organizer,omitempty"`
+	Attendees    []models.Attendee `json:"attendees,omitempty"`
+	// ReadOnly marks events of calendars the user may not change.
+	ReadOnly bool `json:"read_only,omitempty"`
+}
+
+// ActionArguments is a calendar change as the model describes it, with
+// wall-clock times in TimeZone, or in the user's time zone if it is empty.
+type ActionArguments struct {
+	Type         string     `json:"type"`
+	Title        string     `json:"title"`
+	Description  string     `json:"description"`
+	Start        string     `json:"start,omitempty"`
+	End          string     `json:"end,omitempty"`
+	TimeZone     string     `json:"timezone,omitempty"`
+	AllDay       *bool      `json:"all_day,omitempty"`
+	RRule        string     `json:"rrule,omitempty"`
+	Calendar     string     `json:"calendar,omitempty"`
+	Invite       []string   `json:"invite,omitempty"`
+	Uninvite     []string   `json:"uninvite,omitempty"`
+	EventID      string     `json:"event_id,omitempty"`
+	Scope        string     `json:"scope,omitempty"`
+	RecurrenceID *time.Time `json:"recurrence_id,omitempty"`
+}
+
+// toolSession runs the tool calls of one chat turn. Lookups are answered
+// straight away while calendar changes are collected as proposals. Times are
+// exchanged with the model as wall-clock times in the user's time zone, and
+// db only sees the user's calendar. Events of calendars shared with the user
+// are looked up through access, which hides what the user may not see.
+type toolSession struct {
+	db      *gorm.DB
+	access  *repository.Access
+	loc     *time.Location
+	actions []CalendarAction
+	notes   []string
+	invalid int
+}
+
+func newToolSession(db *gorm.DB, userID, timezone string) (*toolSession, error) {
+	loc, err := localtime.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+	access, err := repository.LoadAccess(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &toolSession{db: db, access: access, loc: loc}, nil
+}
+
+// systemPrompt fills in ToolSystemPrompt (plus any extra instructions) for
+// the user's time zone and calendars.
+func (s *toolSession) systemPrompt(extra string) string {
+	prompt := strings.Replace(ToolSystemPrompt+extra, "{{.CurrentDate}}", time.Now().In(s.loc).Format("2006-01-02"), 1)
+	prompt = strings.Replace(prompt, "{{.TimeZone}}", s.loc.String(), 1)
+	return strings.Replace(prompt, "{{.Calendars}}", s.calendarNames(), 1)
+}
+
+// calendarNames lists the names of the user's calendars for the prompt, the
+// default one first, followed by those shared with the user.
+func (s *toolSession) calendarNames() string {
+	names := make([]string, len(s.access.Calendars))
+	for i, calendar := range s.access.Calendars {
+		names[i] = fmt.Sprintf("%q", calendar.Name)
+		if calendar.Role != models.RoleOwner {
+			names[i] += fmt.Sprintf(" (shared by %s, %s)", calendar.Owner, roleDescriptions[calendar.Role])
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// roleDescriptions tell the model what the user may do with a shared calendar.
+var roleDescriptions = map[models.Role]string{
+	models.RoleFreeBusy: "busy times only",
+	models.RoleRead:     "read only",
+	models.RoleEdit:     "can edit events",
+	models.RoleManage:   "can edit events",
+}
+
+// reply appends the notes gathered while converting times, such as DST
+// adjustments, to the model's answer so the user sees them.
+func (s *toolSession) reply(message string) string {
+	for _, note := range s.notes {
+		message += "\n\nNote: " + note + "."
+	}
+	return message
+}
+
+// call runs the named tool and returns the content to hand back to the
+// model. Invalid calls are answered with an error the model can act on.
+func (s *toolSession) call(name string, arguments []byte) string {
+	log.Printf("Tool call %s: %s\n", name, arguments)
+	notes := len(s.notes)
+	result, err := s.run(name, arguments)
+	if err != nil {
+		s.invalid++
+		s.notes = s.notes[:notes]
+		log.Printf("Tool call %s rejected: %v\n", name, err)
+		result = map[string]interface{}{"error": err.Error()}
+	} else if len(s.notes) > notes {
+		result["warnings"] = s.notes[notes:]
+	}
+	content, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Sprintf(`{"error": %q}`, err.Error())
+	}
+	return string(content)
+}
+
+func (s *toolSession) run(name string, arguments []byte) (map[string]interface{}, error) {
+	var tool *Tool
+	for i := range CalendarTools {
+		if CalendarTools[i].Function.Name == name {
+			tool = &CalendarTools[i]
+		}
+	}
+	if tool == nil {
+		return nil, fmt.Errorf("unknown tool %q", name)
+	}
+	if len(strings.TrimSpace(string(arguments))) == 0 {
+		arguments = []byte("{}")
+	}
+	if err := tool.Function.Parameters.ValidateJSON(arguments); err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "list_events", "find_free_time":
+		var args struct {
+			Start           string `json:"start"`
+			End             string `json:"end"`
+			TimeZone        string `json:"timezone"`
+			DurationMinutes int    `json:"duration_minutes"`
+			WorkingHours    string `json:"working_hours"`
+		}
+		if err := json.Unmarshal(arguments, &args); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %v", err)
+		}
+		start, end, err := s.resolveRange(args.Start, args.End, args.TimeZone)
+		if err != nil {
+			return nil, err
+		}
+		if !end.After(start) {
+			return nil, fmt.Errorf("end must be after start")
+		}
+		if end.Sub(start) > maxListWindow {
+			return nil, fmt.Errorf("the range must not be longer than a year")
+		}
+		if name == "find_free_time" {
+			var hours *repository.WorkingHours
+			if args.WorkingHours != "" {
+				if hours, err = repository.ParseWorkingHours(args.WorkingHours); err != nil {
+					return nil, err
+				}
+			}
+			loc := s.loc
+			if args.TimeZone != "" {
+				loc, _ = localtime.LoadLocation(args.TimeZone)
+			}
+			return s.freeTime(start, end, time.Duration(args.DurationMinutes)*time.Minute, hours, loc)
+		}
+		return s.listEvents(start, end)
+
+	case "undo_last_change":
+		if err := s.propose(ActionArguments{Type: "undo"}); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"status": "requested",
+			"note":   "The change will be undone when you reply, unless its events were edited since; the outcome is added to your reply.",
+		}, nil
+
+	default:
+		var args ActionArguments
+		if err := json.Unmarshal(arguments, &args); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %v", err)
+		}
+		args.Type = strings.TrimSuffix(name, "_event")
+		if err := s.propose(args); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"status": "proposed",
+			"note":   "The change will be shown to the user for confirmation together with the other proposed changes.",
+		}, nil
+	}
+}
+
+// propose converts an action's local times and adds it to the proposals.
+// New timed events are anchored to the given time zone, or the user's.
+// Undo requests are passed on as they are, since undoing is up to the caller
+// that knows the conversation.
+func (s *toolSession) propose(args ActionArguments) error {
+	if args.Type == "undo" {
+		s.actions = append(s.actions, CalendarAction{Type: "undo"})
+		return nil
+	}
+	allDay := args.AllDay != nil && *args.AllDay
+	var start, end time.Time
+	var err error
+	if allDay {
+		start, end, err = dateRange(args.Start, args.End)
+	} else {
+		start, end, err = s.resolveRange(args.Start, args.End, args.TimeZone)
+	}
+	if err != nil {
+		return err
+	}
+	invite, err := s.resolvePeople(args.Invite)
+	if err != nil {
+		return err
+	}
+
+	timezone := args.TimeZone
+	if timezone == "" && !allDay && (args.Type == "create" || args.AllDay != nil) && s.loc != time.UTC {
+		timezone = s.loc.String()
+	}
+	action := CalendarAction{
+		Type:         args.Type,
+		Title:        args.Title,
+		Description:  args.Description,
+		Start:        start,
+		End:          end,
+		TimeZone:     timezone,
+		AllDay:       args.AllDay,
+		RRule:        args.RRule,
+		Calendar:     args.Calendar,
+		Invite:       invite,
+		Uninvite:     args.Uninvite,
+		EventID:      args.EventID,
+		Scope:        args.Scope,
+		RecurrenceID: args.RecurrenceID,
+	}
+	if err := validateAction(&action); err != nil {
+		return err
+	}
+	if action.Type != "delete" {
+		conflicts, err := previewAction(s.db, s.access.UserID, action)
+		if err != nil {
+			return err
+		}
+		for i := range conflicts {
+			s.notes = append(s.notes, conflictNote(action.Title, &conflicts[i], s.formatTime(&conflicts[i])))
+		}
+	}
+	s.actions = append(s.actions, action)
+	return nil
+}
+
+// resolvePeople turns the people the model wants to invite into emails.
+// Names are looked up among the users and the people the user has invited
+// before; the model is asked for the email if that doesn't single one out.
+func (s *toolSession) resolvePeople(people []string) ([]string, error) {
+	emails := make([]string, 0, len(people))
+	for _, person := range people {
+		if strings.Contains(person, "@") {
+			emails = append(emails, models.NormalizeEmail(person))
+			continue
+		}
+		found, err := repository.FindPeople(s.db, s.access.UserID, person)
+		if err != nil {
+			return nil, err
+		}
+		switch len(found) {
+		case 0:
+			return nil, fmt.Errorf("no one called %q is known; ask the user for their email address", person)
+		case 1:
+			emails = append(emails, found[0].Email)
+		default:
+			candidates := make([]string, len(found))
+			for i, candidate := range found {
+				candidates[i] = candidate.Email
+				if candidate.Name != "" {
+					candidates[i] = fmt.Sprintf("%s <%s>", candidate.Name, candidate.Email)
+				}
+			}
+			return nil, fmt.Errorf("%q could be any of %s; ask the user which one they mean", person, strings.Join(candidates, ", "))
+		}
+	}
+	return emails, nil
+}
+
+// resolveRange converts local start and end times, either of which may be
+// empty, to UTC instants, recording DST notes along the way.
+func (s *toolSession) resolveRange(start, end, timezone string) (time.Time, time.Time, error) {
+	loc := s.loc
+	if timezone != "" {
+		var err error
+		if loc, err = localtime.LoadLocation(timezone); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+
+	var times [2]time.Time
+	for i, value := range []string{start, end} {
+		if value == "" {
+			continue
+		}
+		t, note, err := localtime.Parse(value, loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		if note != "" {
+			s.notes = append(s.notes, note)
+		}
+		times[i] = t.UTC()
+	}
+	return times[0], times[1], nil
+}
+
+// dateRange reads the dates of an all-day event, end being the last day
+// (the start day if empty), and returns the midnights the event spans.
+func dateRange(start, end string) (time.Time, time.Time, error) {
+	if start == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("start is required for all-day events")
+	}
+	first, err := localtime.ParseWall(start)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	last := first
+	if end != "" {
+		if last, err = localtime.ParseWall(end); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	return first.Truncate(24 * time.Hour), last.Truncate(24*time.Hour).AddDate(0, 0, 1), nil
+}
+
+func (s *toolSession) listEvents(from, to time.Time) (map[string]interface{}, error) {
+	events, err := s.access.FindEvents(s.db, repository.EventFilter{From: &from, To: &to})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]scheduleEntry, 0, len(events))
+	for _, event := range events {
+		format := s.formatTime(&event)
+		entry := scheduleEntry{
+			EventID:      event.ID,
+			Title:        event.Title,
+			Description:  event.Description,
+			Start:        format(event.Start),
+			End:          format(event.End),
+			AllDay:       event.AllDay,
+			Floating:     event.Floating,
+			TimeZone:     event.TimeZone,
+			RecurrenceID: event.RecurrenceID,
+			RRule:        event.RRule,
+			Organizer:    event.Organizer,
+			Attendees:    event.Attendees,
+		}
+		if calendar := s.access.Calendar(event.CalendarID); calendar != nil {
+			entry.Calendar = calendar.Name
+			entry.ReadOnly = !calendar.Role.Allows(models.RoleEdit)
+		}
+		if event.AllDay {
+			entry.Start = event.Start.UTC().Format(localtime.DateLayout)
+			entry.End = event.End.UTC().AddDate(0, 0, -1).Format(localtime.DateLayout)
+		}
+		entries = append(entries, entry)
+	}
+	return map[string]interface{}{"timezone": s.loc.String(), "events": entries}, nil
+}
+
+// formatTime returns how the event's times are shown to the model: in the
+// user's time zone, or for floating events as their own wall clock.
+func (s *toolSession) formatTime(event *models.Event) func(time.Time) string {
+	loc := s.loc
+	if event.Floating {
+		loc = time.UTC
+	}
+	return func(t time.Time) string { return localtime.Format(t, loc) }
+}
+
+// freeTime finds free slots, with working hours taken in loc.
+func (s *toolSession) freeTime(from, to time.Time, minDuration time.Duration, hours *repository.WorkingHours, loc *time.Location) (map[string]interface{}, error) {
+	slots, err := s.access.FreeSlots(s.db, from, to, minDuration, hours, loc)
+	if err != nil {
+		return nil, err
+	}
+	free := make([]map[string]string, 0, len(slots))
+	for _, slot := range slots {
+		free = append(free, map[string]string{
+			"start": localtime.Format(slot.Start, s.loc),
+			"end":   localtime.Format(slot.End, s.loc),
+		})
+	}
+	return map[string]interface{}{"timezone": s.loc.String(), "free": free}, nil
+}
+
+// validateAction checks what the schema can't express, so mistakes are
+// reported to the model while it can still fix them.
+func validateAction(action *CalendarAction) error {
+	if action.Type == "create" && strings.TrimSpace(action.Title) == "" {
+		return fmt.Errorf("title must not be empty")
+	}
+	if !action.Start.IsZero() && !action.End.IsZero() && !action.End.After(action.Start) {
+		return fmt.Errorf("end must be after start")
+	}
+	if action.RRule != "" {
+		if _, err := recurrence.Parse(action.RRule); err != nil {
+			return fmt.Errorf("invalid rrule: %v", err)
+		}
+	}
+	_, err := repository.ParseScope(action.Scope)
+	return err
+}
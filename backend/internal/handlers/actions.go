@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"calendar-backend/internal/ai"
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/repository"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// actionTypes are the calendar actions that can be proposed and confirmed.
+var actionTypes = map[string]bool{"create": true, "update": true, "delete": true}
+
+// proposeActions stores the actions returned by the AI as one pending batch,
+// and applies it straight away if auto-apply is enabled for every action type
+// in it. The actor names the AI, the user and the conversation and message
+// the actions were proposed in, and db is the user's calendar.
+func proposeActions(db *gorm.DB, actor models.Actor, actions []ai.CalendarAction) (*models.PendingAction, []ai.ActionResult, error) {
+	data, err := json.Marshal(actions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode actions: %v", err)
+	}
+	pending, err := repository.CreatePendingAction(db, actor, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	settings, err := repository.AutoApplySettings(db)
+	if err != nil {
+		log.Printf("Error reading auto-apply settings: %v", err)
+		return pending, nil, nil
+	}
+	for _, action := range actions {
+		if !settings[action.Type] {
+			return pending, nil, nil
+		}
+	}
+
+	log.Printf("Auto-applying actions %s", pending.ID)
+	if pending, err = repository.ClaimPendingAction(db, pending.ID, models.ActionApplied); err != nil {
+		return nil, nil, err
+	}
+	results, err := applyActions(db, pending, actions)
+	return pending, results, err
+}
+
+// applyActions executes a claimed batch and records the outcome. Execution
+// errors are stored on the pending action rather than returned.
+func applyActions(db *gorm.DB, pending *models.PendingAction, actions []ai.CalendarAction) ([]ai.ActionResult, error) {
+	results, execErr := ai.ExecuteCalendarActions(db, actions, pending.Actor())
+	if execErr != nil {
+		log.Printf("Error applying actions %s: %v", pending.ID, execErr)
+	}
+	data, err := json.Marshal(actions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode actions: %v", err)
+	}
+	resultData, err := json.Marshal(results)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode results: %v", err)
+	}
+	return results, repository.FinishPendingAction(db, pending, data, resultData, execErr)
+}
+
+// ListActions returns proposed actions, filtered by the optional
+// conversationId and status query parameters.
+func ListActions(c *fiber.Ctx) error {
+	actions, err := repository.ListPendingActions(userDB(c), c.Query("conversationId"), c.Query("status"))
+	if err != nil {
+		log.Printf("Error listing actions: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list actions",
+		})
+	}
+	return c.JSON(actions)
+}
+
+// ConfirmAction applies a pending batch of actions to the calendar.
+func ConfirmAction(c *fiber.Ctx) error {
+	db := userDB(c)
+	pending, err := repository.ClaimPendingAction(db, c.Params("id"), models.ActionApplied)
+	if err != nil {
+		return actionError(c, err, pending)
+	}
+
+	var actions []ai.CalendarAction
+	if err := json.Unmarshal(pending.Actions, &actions); err != nil {
+		err = fmt.Errorf("stored actions are invalid: %v", err)
+		if ferr := repository.FinishPendingAction(db, pending, nil, nil, err); ferr != nil {
+			log.Printf("Error updating action %s: %v", pending.ID, ferr)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(pending)
+	}
+	results, err := applyActions(db, pending, actions)
+	if err != nil {
+		log.Printf("Error recording action %s: %v", pending.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to record action outcome",
+		})
+	}
+
+	// Conflicts were pointed out when the changes were proposed, but the
+	// calendar may have changed since.
+	content := fmt.Sprintf("Applied the %d proposed change(s).", len(actions))
+	for _, note := range ai.ConflictNotes(results) {
+		content += "\n\nNote: " + note + "."
+	}
+	if pending.Status == models.ActionFailed {
+		content = fmt.Sprintf("Failed to apply the proposed changes, so none were made: %s", pending.Error)
+	}
+	recordOutcome(db, pending, content)
+
+	if pending.Status == models.ActionFailed {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(pending)
+	}
+	return c.JSON(pending)
+}
+
+// RejectAction discards a pending batch without touching the calendar.
+func RejectAction(c *fiber.Ctx) error {
+	db := userDB(c)
+	pending, err := repository.ClaimPendingAction(db, c.Params("id"), models.ActionRejected)
+	if err != nil {
+		return actionError(c, err, pending)
+	}
+	recordOutcome(db, pending, "The user rejected the proposed changes.")
+	return c.JSON(pending)
+}
+
+// recordOutcome adds a note to the action's conversation so the assistant
+// knows on later turns whether its proposal went through.
+func recordOutcome(db *gorm.DB, pending *models.PendingAction, content string) {
+	err := repository.AppendMessages(db, pending.ConversationID, &models.ChatMessage{
+		Role:         "assistant",
+		Content:      content,
+		Actions:      pending.Actions,
+		ActionID:     pending.ID,
+		ActionStatus: pending.Status,
+	})
+	if err != nil {
+		log.Printf("Error saving outcome of action %s: %v", pending.ID, err)
+	}
+}
+
+// GetAutoApply returns which action types are applied without confirmation.
+func GetAutoApply(c *fiber.Ctx) error {
+	stored, err := repository.AutoApplySettings(userDB(c))
+	if err != nil {
+		log.Printf("Error loading auto-apply settings: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load settings",
+		})
+	}
+	settings := make(map[string]bool, len(actionTypes))
+	for actionType := range actionTypes {
+		settings[actionType] = stored[actionType]
+	}
+	return c.JSON(fiber.Map{"autoApply": settings})
+}
+
+// SetAutoApply enables or disables auto-apply per action type, e.g.
+// {"autoApply": {"create": true}}. Types left out keep their setting.
+func SetAutoApply(c *fiber.Ctx) error {
+	var req struct {
+		AutoApply map[string]bool `json:"autoApply"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	for actionType := range req.AutoApply {
+		if !actionTypes[actionType] {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Unknown action type %q", actionType),
+			})
+		}
+	}
+	if err := repository.SetAutoApply(userDB(c), currentUser(c).ID, req.AutoApply); err != nil {
+		log.Printf("Error saving auto-apply settings: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save settings",
+		})
+	}
+	return GetAutoApply(c)
+}
+
+func actionError(c *fiber.Ctx, err error, pending *models.PendingAction) error {
+	switch {
+	case errors.Is(err, repository.ErrActionNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Action not found",
+		})
+	case errors.Is(err, repository.ErrActionResolved):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":  "Action has already been " + pending.Status,
+			"action": pending,
+		})
+	}
+	log.Printf("Action error: %v", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to update action",
+	})
+}
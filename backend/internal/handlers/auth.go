@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/repository"
+	"encoding/base64"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// sessionCookie holds the session token of browser logins.
+const sessionCookie = "session"
+
+type RegisterRequest struct {
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type PasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+type TokenRequest struct {
+	Name      string     `json:"name"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// currentUser returns the user authenticated by RequireAuth.
+func currentUser(c *fiber.Ctx) *models.User {
+	user, _ := c.Locals("user").(*models.User)
+	return user
+}
+
+// userDB returns the database as seen by the authenticated user, i.e. with
+// every query restricted to the user's rows.
+func userDB(c *fiber.Ctx) *gorm.DB {
+	return repository.ForUser(currentUser(c).ID)
+}
+
+// Register creates an account. Sign-up is only open when ALLOW_SIGNUP is
+// "true"; otherwise accounts are created by other means (e.g. the default
+// user created at startup).
+func Register(c *fiber.Ctx) error {
+	if os.Getenv("ALLOW_SIGNUP") != "true" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Sign-up is disabled",
+		})
+	}
+	var req RegisterRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	invalid := &models.ValidationError{}
+	if !strings.Contains(req.Email, "@") {
+		invalid.Add("email", "must be an email address")
+	}
+	if len(req.Password) < models.MinPasswordLength {
+		invalid.Add("password", "must be at least %d characters", models.MinPasswordLength)
+	}
+	if invalid.Err() != nil {
+		return validationError(c, invalid)
+	}
+
+	user, err := repository.CreateUser(req.Email, req.Name, req.Password)
+	if errors.Is(err, repository.ErrEmailTaken) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		log.Printf("Error creating user %s: %v", req.Email, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create account",
+		})
+	}
+	log.Printf("Registered user %s", user.Email)
+	return c.Status(fiber.StatusCreated).JSON(user)
+}
+
+// Login checks an email and password and starts a session. The session
+// token is set as an HttpOnly cookie for browsers and returned for other
+// clients, which send it as a bearer token.
+func Login(c *fiber.Ctx) error {
+	var req LoginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	user, err := repository.Authenticate(req.Email, req.Password)
+	if errors.Is(err, repository.ErrInvalidCredentials) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		log.Printf("Error logging in %s: %v", req.Email, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to log in",
+		})
+	}
+
+	token, session, err := repository.CreateSession(user.ID)
+	if err != nil {
+		log.Printf("Error creating session for %s: %v", user.Email, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to log in",
+		})
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HTTPOnly: true,
+		Secure:   c.Protocol() == "https",
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return c.JSON(fiber.Map{
+		"token":     token,
+		"expiresAt": session.ExpiresAt,
+		"user":      user,
+	})
+}
+
+// Logout ends the current session. API tokens are revoked with DeleteToken.
+func Logout(c *fiber.Ctx) error {
+	if token := sessionToken(c); token != "" && !strings.HasPrefix(token, repository.APITokenPrefix) {
+		if err := repository.DeleteSession(token); err != nil {
+			log.Printf("Error logging out: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to log out",
+			})
+		}
+	}
+	c.ClearCookie(sessionCookie)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Me returns the authenticated user.
+func Me(c *fiber.Ctx) error {
+	return c.JSON(currentUser(c))
+}
+
+// ChangePassword sets a new password, given the current one. Other sessions
+// of the user are logged out.
+func ChangePassword(c *fiber.Ctx) error {
+	var req PasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if len(req.NewPassword) < models.MinPasswordLength {
+		invalid := &models.ValidationError{}
+		invalid.Add("newPassword", "must be at least %d characters", models.MinPasswordLength)
+		return validationError(c, invalid)
+	}
+
+	user := currentUser(c)
+	err := repository.ChangePassword(user, req.CurrentPassword, req.NewPassword, sessionToken(c))
+	if errors.Is(err, repository.ErrInvalidCredentials) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "The current password is wrong",
+		})
+	}
+	if err != nil {
+		log.Printf("Error changing password of %s: %v", user.Email, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to change password",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ListTokens returns the user's API tokens, without the tokens themselves.
+func ListTokens(c *fiber.Ctx) error {
+	tokens, err := repository.ListAPITokens(currentUser(c).ID)
+	if err != nil {
+		log.Printf("Error listing tokens: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list tokens",
+		})
+	}
+	return c.JSON(tokens)
+}
+
+// CreateToken issues an API token, e.g. for a script or a CalDAV client. The
+// token is only returned in this response.
+func CreateToken(c *fiber.Ctx) error {
+	var req TokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		invalid := &models.ValidationError{}
+		invalid.Add("name", "is required")
+		return validationError(c, invalid)
+	}
+
+	token, apiToken, err := repository.CreateAPIToken(currentUser(c).ID, req.Name, req.ExpiresAt)
+	if err != nil {
+		log.Printf("Error creating token: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create token",
+		})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"token":     token,
+		"id":        apiToken.ID,
+		"name":      apiToken.Name,
+		"expiresAt": apiToken.ExpiresAt,
+		"createdAt": apiToken.CreatedAt,
+	})
+}
+
+// DeleteToken revokes one of the user's API tokens.
+func DeleteToken(c *fiber.Ctx) error {
+	err := repository.DeleteAPIToken(currentUser(c).ID, c.Params("id"))
+	if errors.Is(err, repository.ErrTokenNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Token not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error deleting token: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete token",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// sessionToken returns the bearer token of the request, or else the session
+// cookie.
+func sessionToken(c *fiber.Ctx) string {
+	header := c.Get(fiber.HeaderAuthorization)
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return c.Cookies(sessionCookie)
+}
+
+// authenticate identifies the user of the request from a bearer token (a
+// session or API token), the session cookie or HTTP Basic credentials, where
+// the password may also be an API token for clients that only do Basic auth.
+func authenticate(c *fiber.Ctx) (*models.User, error) {
+	header := c.Get(fiber.HeaderAuthorization)
+	if strings.HasPrefix(header, "Basic ") {
+		email, password, ok := basicCredentials(header)
+		if !ok {
+			return nil, repository.ErrInvalidCredentials
+		}
+		if strings.HasPrefix(password, repository.APITokenPrefix) {
+			user, err := repository.UserForToken(password)
+			if err != nil {
+				return nil, err
+			}
+			if !strings.EqualFold(user.Email, strings.TrimSpace(email)) {
+				return nil, repository.ErrInvalidCredentials
+			}
+			return user, nil
+		}
+		return repository.Authenticate(email, password)
+	}
+	return repository.UserForToken(sessionToken(c))
+}
+
+// basicCredentials decodes the user and password of a Basic Authorization
+// header.
+func basicCredentials(header string) (string, string, bool) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header, "Basic "))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+// RequireAuth rejects requests without valid credentials and makes the user
+// available to the handlers.
+func RequireAuth(c *fiber.Ctx) error {
+	user, err := authenticate(c)
+	if err != nil {
+		return authError(c, err)
+	}
+	c.Locals("user", user)
+	return c.Next()
+}
+
+// RequireDAVAuth is RequireAuth for CalDAV clients, which need to be asked
+// for Basic credentials.
+func RequireDAVAuth(c *fiber.Ctx) error {
+	user, err := authenticate(c)
+	if err != nil {
+		c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="Calendar Bot", charset="UTF-8"`)
+		return authError(c, err)
+	}
+	c.Locals("user", user)
+	return c.Next()
+}
+
+// FeedAuth lets calendar subscriptions in with the feed token of the user
+// in the token query parameter, since they can't log in. Other requests are
+// authenticated as usual.
+func FeedAuth(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		return RequireAuth(c)
+	}
+	user, err := repository.UserForFeedToken(token)
+	if err != nil {
+		return authError(c, err)
+	}
+	c.Locals("user", user)
+	return c.Next()
+}
+
+func authError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, repository.ErrInvalidToken) || errors.Is(err, repository.ErrInvalidCredentials) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+	log.Printf("Error authenticating request: %v", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to authenticate",
+	})
+}
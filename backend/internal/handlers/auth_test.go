@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/repository"
+)
+
+func basicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func TestEventsOfOtherUsers(t *testing.T) {
+	app := testServer(t)
+	_, ownerToken := login(t, "alex@example.com")
+	other, otherSession := login(t, "sam@example.com")
+	apiToken, _, err := repository.CreateAPIToken(other.ID, "sync", nil)
+	if err != nil {
+		t.Fatalf("failed to create API token: %v", err)
+	}
+
+	var event models.Event
+	status := send(t, app, http.MethodPost, "/api/events/", "Bearer "+ownerToken, map[string]interface{}{
+		"title": "Dentist", "start": "2026-10-20T09:00:00Z", "end": "2026-10-20T10:00:00Z",
+	}, &event)
+	if status != http.StatusCreated && status != http.StatusOK {
+		t.Fatalf("creating the event gave %d", status)
+	}
+
+	credentials := map[string]string{
+		"session":            "Bearer " + otherSession,
+		"API token":          "Bearer " + apiToken,
+		"password":           basicAuth("sam@example.com", "correct horse battery staple"),
+		"API token as Basic": basicAuth("sam@example.com", apiToken),
+	}
+	for name, authorization := range credentials {
+		t.Run(name, func(t *testing.T) {
+			var events []models.Event
+			if status := send(t, app, http.MethodGet, "/api/events/", authorization, nil, &events); status != http.StatusOK {
+				t.Fatalf("listing events gave %d", status)
+			}
+			if len(events) != 0 {
+				t.Errorf("listed %d events of another user", len(events))
+			}
+
+			path := "/api/events/" + event.ID
+			change := map[string]interface{}{"title": "Hijacked", "start": "2026-10-20T09:00:00Z", "end": "2026-10-20T10:00:00Z"}
+			requests := []struct {
+				method string
+				body   interface{}
+			}{
+				{http.MethodGet, nil},
+				{http.MethodPut, change},
+				{http.MethodPatch, map[string]interface{}{"title": "Hijacked"}},
+				{http.MethodDelete, nil},
+			}
+			for _, request := range requests {
+				if status := send(t, app, request.method, path, authorization, request.body, nil); status != http.StatusNotFound {
+					t.Errorf("%s of another user's event gave %d, want %d", request.method, status, http.StatusNotFound)
+				}
+			}
+		})
+	}
+
+	var stored models.Event
+	if status := send(t, app, http.MethodGet, "/api/events/"+event.ID, "Bearer "+ownerToken, nil, &stored); status != http.StatusOK {
+		t.Fatalf("the owner fetching the event gave %d", status)
+	}
+	if stored.Title != "Dentist" {
+		t.Errorf("event is titled %q, want it unchanged", stored.Title)
+	}
+	if status := send(t, app, http.MethodGet, "/api/events/", "", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("listing events without credentials gave %d, want %d", status, http.StatusUnauthorized)
+	}
+}
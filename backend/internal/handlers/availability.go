@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"calendar-backend/internal/localtime"
+	"calendar-backend/internal/repository"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultSlotDuration   = 30 * time.Minute
+	maxAvailabilityWindow = 366 * 24 * time.Hour
+)
+
+// GetAvailability returns the free slots of at least duration between from
+// and to. Times are computed in the caller's time zone (the timezone query
+// parameter, UTC by default): from/to may be local times in it, working
+// hours such as "Mon-Fri 09:00-17:00" apply to its wall clock and the slots
+// are returned with its offset.
+func GetAvailability(c *fiber.Ctx) error {
+	loc, err := localtime.LoadLocation(c.Query("timezone"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	from, err := parseAvailabilityTime(c, "from", loc)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	to, err := parseAvailabilityTime(c, "to", loc)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if !to.After(from) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "'to' must be after 'from'",
+		})
+	}
+	if to.Sub(from) > maxAvailabilityWindow {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "The range must not be longer than a year",
+		})
+	}
+
+	duration, err := parseSlotDuration(c.Query("duration"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var hours *repository.WorkingHours
+	if value := c.Query("workingHours"); value != "" {
+		if hours, err = repository.ParseWorkingHours(value); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	var slots []repository.TimeSlot
+	access, err := userAccess(c)
+	if err == nil {
+		slots, err = access.FreeSlots(repository.DB, from, to, duration, hours, loc)
+	}
+	if err != nil {
+		log.Printf("Error computing availability: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to compute availability",
+		})
+	}
+	for i := range slots {
+		slots[i].Start = slots[i].Start.In(loc)
+		slots[i].End = slots[i].End.In(loc)
+	}
+	return c.JSON(fiber.Map{
+		"timezone": loc.String(),
+		"duration": int(duration / time.Minute),
+		"slots":    slots,
+	})
+}
+
+// parseAvailabilityTime reads a required RFC3339 timestamp, or a local time
+// or date in loc.
+func parseAvailabilityTime(c *fiber.Ctx, key string, loc *time.Location) (time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return time.Time{}, fmt.Errorf("'%s' is required", key)
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, _, err := localtime.Parse(value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid '%s' parameter, expected an RFC3339 timestamp or a local time", key)
+	}
+	return t, nil
+}
+
+// parseSlotDuration reads a duration given in minutes ("60") or as a Go
+// duration ("1h30m").
+func parseSlotDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultSlotDuration, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		minutes, convErr := strconv.Atoi(value)
+		if convErr != nil {
+			return 0, fmt.Errorf("invalid 'duration' parameter, expected minutes or e.g. 1h30m")
+		}
+		duration = time.Duration(minutes) * time.Minute
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("'duration' must be positive")
+	}
+	return duration, nil
+}
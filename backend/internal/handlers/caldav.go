@@ -0,0 +1,687 @@
+package handlers
+
+import (
+	"bytes"
+	"calendar-backend/internal/ical"
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/repository"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// CalDAV exposes the events of the authenticated user as a single calendar
+// collection. Every
+// series (a non-recurring event, or a recurring master with its overrides) is
+// one calendar object resource named after its UID.
+const (
+	caldavRoot       = "/caldav/"
+	caldavCollection = "/caldav/calendar/"
+	caldavName       = "Calendar Bot"
+)
+
+// CalDAVMethods are the WebDAV verbs Fiber has to be configured to route.
+var CalDAVMethods = []string{"PROPFIND", "REPORT"}
+
+const (
+	nsDAV    = "DAV:"
+	nsCalDAV = "urn:ietf:params:xml:ns:caldav"
+	nsCS     = "http://calendarserver.org/ns/"
+	nsApple  = "http://apple.com/ns/ical/"
+)
+
+var nsPrefixes = map[string]string{
+	nsDAV:    "d",
+	nsCalDAV: "c",
+	nsCS:     "cs",
+	nsApple:  "ical",
+}
+
+type davKind int
+
+const (
+	davRoot davKind = iota
+	davCollection
+	davObject
+)
+
+// davResource is anything a PROPFIND or REPORT can describe.
+type davResource struct {
+	kind   davKind
+	href   string
+	series []models.Event
+	data   []byte
+	etag   string
+	ctag   string
+}
+
+// davRequest is the parsed body of a PROPFIND or REPORT.
+type davRequest struct {
+	kind    string
+	props   []xml.Name
+	allProp bool
+	hrefs   []string
+	from    *time.Time
+	to      *time.Time
+}
+
+// CalDAVOptions advertises WebDAV and CalDAV support.
+func CalDAVOptions(c *fiber.Ctx) error {
+	c.Set("DAV", "1, 3, calendar-access")
+	c.Set(fiber.HeaderAllow, "OPTIONS, GET, PUT, DELETE, PROPFIND, REPORT")
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// CalDAVWellKnown points clients doing service discovery (RFC 6764) at the root.
+func CalDAVWellKnown(c *fiber.Ctx) error {
+	return c.Redirect(caldavRoot, fiber.StatusMovedPermanently)
+}
+
+// PropfindRoot describes the root, which doubles as the principal and the
+// calendar home, and with Depth: 1 lists the calendar collection.
+func PropfindRoot(c *fiber.Ctx) error {
+	req, err := parseDAVRequest(c.Body())
+	if err != nil {
+		return davError(c, fiber.StatusBadRequest, err.Error())
+	}
+	access, err := userAccess(c)
+	if err != nil {
+		return objectError(c, err)
+	}
+
+	resources := []*davResource{{kind: davRoot, href: caldavRoot}}
+	if c.Get("Depth", "1") != "0" {
+		collection, err := collectionResource(access)
+		if err != nil {
+			return davError(c, fiber.StatusInternalServerError, "Failed to load calendar")
+		}
+		resources = append(resources, collection)
+	}
+	return sendMultistatus(c, req, resources)
+}
+
+// PropfindCollection describes the calendar collection and, unless Depth is
+// 0, every calendar object in it.
+func PropfindCollection(c *fiber.Ctx) error {
+	req, err := parseDAVRequest(c.Body())
+	if err != nil {
+		return davError(c, fiber.StatusBadRequest, err.Error())
+	}
+	access, err := userAccess(c)
+	if err != nil {
+		return objectError(c, err)
+	}
+
+	collection, err := collectionResource(access)
+	if err != nil {
+		return davError(c, fiber.StatusInternalServerError, "Failed to load calendar")
+	}
+	resources := []*davResource{collection}
+
+	if c.Get("Depth", "1") != "0" {
+		objects, err := objectResources(access, repository.EventFilter{})
+		if err != nil {
+			log.Printf("CalDAV: failed to list objects: %v", err)
+			return davError(c, fiber.StatusInternalServerError, "Failed to load events")
+		}
+		resources = append(resources, objects...)
+	}
+	return sendMultistatus(c, req, resources)
+}
+
+// PropfindObject describes a single calendar object.
+func PropfindObject(c *fiber.Ctx) error {
+	req, err := parseDAVRequest(c.Body())
+	if err != nil {
+		return davError(c, fiber.StatusBadRequest, err.Error())
+	}
+	access, err := userAccess(c)
+	if err != nil {
+		return objectError(c, err)
+	}
+
+	resource, err := objectResource(access, objectUID(c))
+	if err != nil {
+		return objectError(c, err)
+	}
+	return sendMultistatus(c, req, []*davResource{resource})
+}
+
+// CalDAVReport answers calendar-query (optionally limited by a time-range)
+// and calendar-multiget reports on the collection.
+func CalDAVReport(c *fiber.Ctx) error {
+	req, err := parseDAVRequest(c.Body())
+	if err != nil {
+		return davError(c, fiber.StatusBadRequest, err.Error())
+	}
+	access, err := userAccess(c)
+	if err != nil {
+		return objectError(c, err)
+	}
+
+	switch req.kind {
+	case "calendar-query":
+		objects, err := objectResources(access, repository.EventFilter{From: req.from, To: req.to})
+		if err != nil {
+			log.Printf("CalDAV: calendar-query failed: %v", err)
+			return davError(c, fiber.StatusInternalServerError, "Failed to load events")
+		}
+		return sendMultistatus(c, req, objects)
+
+	case "calendar-multiget":
+		var resources []*davResource
+		var missing []string
+		for _, href := range req.hrefs {
+			uid, ok := uidFromHref(href)
+			if !ok {
+				missing = append(missing, href)
+				continue
+			}
+			resource, err := objectResource(access, uid)
+			if errors.Is(err, repository.ErrNotFound) {
+				missing = append(missing, href)
+				continue
+			}
+			if err != nil {
+				return davError(c, fiber.StatusInternalServerError, "Failed to load events")
+			}
+			resources = append(resources, resource)
+		}
+		return sendMultistatus(c, req, resources, missing...)
+
+	default:
+		return davError(c, fiber.StatusForbidden, fmt.Sprintf("Unsupported report %q", req.kind))
+	}
+}
+
+// GetCalendarObject returns the iCalendar data of a single object.
+func GetCalendarObject(c *fiber.Ctx) error {
+	access, err := userAccess(c)
+	if err != nil {
+		return objectError(c, err)
+	}
+	resource, err := objectResource(access, objectUID(c))
+	if err != nil {
+		return objectError(c, err)
+	}
+
+	c.Set(fiber.HeaderETag, resource.etag)
+	c.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+	return c.Send(resource.data)
+}
+
+// PutCalendarObject creates or replaces a calendar object. If-Match and
+// If-None-Match are honoured so clients don't overwrite each other's changes.
+func PutCalendarObject(c *fiber.Ctx) error {
+	uid := objectUID(c)
+	access, err := userAccess(c)
+	if err != nil {
+		return objectError(c, err)
+	}
+
+	current, err := objectResource(access, uid)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return objectError(c, err)
+	}
+	if status := checkPreconditions(c, current); status != 0 {
+		return c.SendStatus(status)
+	}
+
+	roots, err := ical.Parse(bytes.NewReader(c.Body()))
+	if err != nil {
+		return davError(c, fiber.StatusBadRequest, err.Error())
+	}
+	events, errs := ical.Events(roots)
+	if len(errs) > 0 {
+		return davError(c, fiber.StatusBadRequest, errs[0].Error())
+	}
+	if len(events) == 0 {
+		return davError(c, fiber.StatusBadRequest, "No VEVENT found")
+	}
+	for _, event := range events {
+		eventUID := event.ID
+		if event.IsOverride() {
+			eventUID = event.RecurringEventID
+		}
+		if eventUID != uid {
+			return davError(c, fiber.StatusBadRequest, fmt.Sprintf("UID %q does not match the resource name", eventUID))
+		}
+	}
+
+	// Objects of calendars shared with the user stay with their owner; new
+	// ones go to the user's default calendar.
+	db, ownerID := userDB(c), currentUser(c).ID
+	if current != nil {
+		if db, _, err = repository.EventAccess(repository.DB, ownerID, uid, models.RoleEdit); err != nil {
+			return objectError(c, err)
+		}
+		ownerID = current.series[0].UserID
+	}
+	created, err := repository.ReplaceSeries(db, ownerID, uid, events, requestActor(c))
+	if errors.Is(err, repository.ErrRejected) {
+		return davError(c, fiber.StatusForbidden, err.Error())
+	}
+	if err != nil {
+		log.Printf("CalDAV: failed to store %s: %v", uid, err)
+		return davError(c, fiber.StatusInternalServerError, "Failed to store event")
+	}
+
+	stored, err := objectResource(access, uid)
+	if err != nil {
+		return objectError(c, err)
+	}
+	c.Set(fiber.HeaderETag, stored.etag)
+	if created {
+		return c.SendStatus(fiber.StatusCreated)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// DeleteCalendarObject deletes a calendar object, i.e. a whole series.
+func DeleteCalendarObject(c *fiber.Ctx) error {
+	uid := objectUID(c)
+	access, err := userAccess(c)
+	if err != nil {
+		return objectError(c, err)
+	}
+
+	current, err := objectResource(access, uid)
+	if err != nil {
+		return objectError(c, err)
+	}
+	if status := checkPreconditions(c, current); status != 0 {
+		return c.SendStatus(status)
+	}
+
+	err = repository.DB.Transaction(func(tx *gorm.DB) error {
+		tx, err := editAccess(c, tx, uid)
+		if err != nil {
+			return err
+		}
+		audit, err := repository.BeginAudit(tx, uid)
+		if err != nil {
+			return err
+		}
+		if err := repository.DeleteEvent(tx, uid, repository.ScopeAll, nil); err != nil {
+			return err
+		}
+		return audit.Record(requestActor(c))
+	})
+	if err != nil {
+		return objectError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// checkPreconditions evaluates If-Match / If-None-Match against the current
+// resource (nil if it doesn't exist) and returns a failure status, or 0.
+func checkPreconditions(c *fiber.Ctx, current *davResource) int {
+	if match := c.Get(fiber.HeaderIfMatch); match != "" {
+		if current == nil || (match != "*" && !etagListContains(match, current.etag)) {
+			return fiber.StatusPreconditionFailed
+		}
+	}
+	if noneMatch := c.Get(fiber.HeaderIfNoneMatch); noneMatch != "" && current != nil {
+		if noneMatch == "*" || etagListContains(noneMatch, current.etag) {
+			return fiber.StatusPreconditionFailed
+		}
+	}
+	return 0
+}
+
+func etagListContains(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func objectUID(c *fiber.Ctx) string {
+	name, err := url.PathUnescape(c.Params("name"))
+	if err != nil {
+		name = c.Params("name")
+	}
+	return strings.TrimSuffix(name, ".ics")
+}
+
+func uidFromHref(href string) (string, bool) {
+	if parsed, err := url.Parse(href); err == nil {
+		href = parsed.Path
+	}
+	if !strings.HasPrefix(href, caldavCollection) {
+		return "", false
+	}
+	name, err := url.PathUnescape(strings.TrimPrefix(href, caldavCollection))
+	if err != nil || !strings.HasSuffix(name, ".ics") {
+		return "", false
+	}
+	return strings.TrimSuffix(name, ".ics"), true
+}
+
+func objectHref(uid string) string {
+	return caldavCollection + url.PathEscape(uid) + ".ics"
+}
+
+func collectionResource(access *repository.Access) (*davResource, error) {
+	last, count, err := repository.LastChange(access.Events(repository.DB))
+	if err != nil {
+		return nil, err
+	}
+	return &davResource{
+		kind: davCollection,
+		href: caldavCollection,
+		ctag: fmt.Sprintf(`"%d-%d"`, last.UnixNano(), count),
+	}, nil
+}
+
+// objectResource returns the calendar object of the series uid as the user
+// may see it.
+func objectResource(access *repository.Access, uid string) (*davResource, error) {
+	series, err := repository.FindSeries(access.Events(repository.DB), uid)
+	if err != nil {
+		return nil, err
+	}
+	return newObjectResource(uid, access.Filter(series), access.Reminders()), nil
+}
+
+func newObjectResource(uid string, series []models.Event, reminders map[string]time.Duration) *davResource {
+	data := ical.Encode(ical.Calendar{Events: series, Reminders: reminders})
+	sum := sha1.Sum(data)
+	return &davResource{
+		kind:   davObject,
+		href:   objectHref(uid),
+		series: series,
+		data:   data,
+		etag:   `"` + hex.EncodeToString(sum[:]) + `"`,
+	}
+}
+
+// objectResources lists the calendar objects with at least one occurrence
+// inside the filter's window (or all of them without a window).
+func objectResources(access *repository.Access, filter repository.EventFilter) ([]*davResource, error) {
+	rows, err := access.FindEventRows(repository.DB, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var uids []string
+	seen := map[string]bool{}
+	for _, row := range rows {
+		uid := row.ID
+		if row.IsOverride() {
+			uid = row.RecurringEventID
+		}
+		if seen[uid] {
+			continue
+		}
+		if row.IsRecurring() && filter.HasWindow() {
+			occurrences, err := repository.ExpandEvent(row, *filter.From, *filter.To, nil)
+			if err != nil || len(occurrences) == 0 {
+				continue
+			}
+		}
+		seen[uid] = true
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+
+	resources := make([]*davResource, 0, len(uids))
+	for _, uid := range uids {
+		resource, err := objectResource(access, uid)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+func objectError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, repository.ErrNotFound) {
+		return davError(c, fiber.StatusNotFound, "Calendar object not found")
+	}
+	if errors.Is(err, repository.ErrForbidden) {
+		return davError(c, fiber.StatusForbidden, err.Error())
+	}
+	log.Printf("CalDAV: %v", err)
+	return davError(c, fiber.StatusInternalServerError, "Failed to load event")
+}
+
+func davError(c *fiber.Ctx, status int, message string) error {
+	c.Set(fiber.HeaderContentType, "text/plain; charset=utf-8")
+	return c.Status(status).SendString(message)
+}
+
+// parseDAVRequest extracts what we need from PROPFIND and REPORT bodies: the
+// requested properties, hrefs for multiget and an optional time-range.
+// An empty body is treated as <allprop/>.
+func parseDAVRequest(body []byte) (*davRequest, error) {
+	req := &davRequest{}
+	if len(bytes.TrimSpace(body)) == 0 {
+		req.kind = "propfind"
+		req.allProp = true
+		return req, nil
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	var stack []xml.Name
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("invalid XML body: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if len(stack) == 0 {
+				req.kind = t.Name.Local
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				if parent.Space == nsDAV && parent.Local == "prop" && len(stack) == 2 {
+					req.props = append(req.props, t.Name)
+				}
+			}
+			switch {
+			case t.Name.Space == nsDAV && t.Name.Local == "allprop":
+				req.allProp = true
+			case t.Name.Space == nsDAV && t.Name.Local == "href":
+				var href string
+				if err := decoder.DecodeElement(&href, &t); err != nil {
+					return nil, fmt.Errorf("invalid href: %v", err)
+				}
+				req.hrefs = append(req.hrefs, strings.TrimSpace(href))
+				continue
+			case t.Name.Space == nsCalDAV && t.Name.Local == "time-range":
+				for _, attr := range t.Attr {
+					value, err := time.Parse("20060102T150405Z", attr.Value)
+					if err != nil {
+						return nil, fmt.Errorf("invalid time-range %s: %q", attr.Name.Local, attr.Value)
+					}
+					switch attr.Name.Local {
+					case "start":
+						req.from = &value
+					case "end":
+						req.to = &value
+					}
+				}
+			}
+			stack = append(stack, t.Name)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	if req.kind == "propfind" && len(req.props) == 0 {
+		req.allProp = true
+	}
+	return req, nil
+}
+
+// defaultProps are returned for <allprop/> requests.
+var defaultProps = map[davKind][]xml.Name{
+	davRoot: {
+		{Space: nsDAV, Local: "resourcetype"},
+		{Space: nsDAV, Local: "displayname"},
+		{Space: nsDAV, Local: "current-user-principal"},
+		{Space: nsCalDAV, Local: "calendar-home-set"},
+	},
+	davCollection: {
+		{Space: nsDAV, Local: "resourcetype"},
+		{Space: nsDAV, Local: "displayname"},
+		{Space: nsCS, Local: "getctag"},
+		{Space: nsCalDAV, Local: "supported-calendar-component-set"},
+	},
+	davObject: {
+		{Space: nsDAV, Local: "resourcetype"},
+		{Space: nsDAV, Local: "getetag"},
+		{Space: nsDAV, Local: "getcontenttype"},
+		{Space: nsDAV, Local: "getcontentlength"},
+		{Space: nsDAV, Local: "getlastmodified"},
+	},
+}
+
+// propValue renders the inner XML of a property, or reports that the
+// resource doesn't have it.
+func propValue(name xml.Name, res *davResource) (string, bool) {
+	principal := "<d:href>" + caldavRoot + "</d:href>"
+
+	switch name.Space + " " + name.Local {
+	case nsDAV + " resourcetype":
+		switch res.kind {
+		case davRoot:
+			return "<d:collection/><d:principal/>", true
+		case davCollection:
+			return "<d:collection/><c:calendar/>", true
+		}
+		return "", true
+	case nsDAV + " displayname":
+		if res.kind == davObject {
+			return escapeXML(res.series[0].Title), true
+		}
+		return caldavName, true
+	case nsDAV + " current-user-principal", nsDAV + " principal-URL", nsDAV + " owner":
+		return principal, true
+	case nsCalDAV + " calendar-home-set":
+		return principal, res.kind == davRoot
+	case nsDAV + " current-user-privilege-set":
+		return "<d:privilege><d:read/></d:privilege><d:privilege><d:write/></d:privilege>" +
+			"<d:privilege><d:write-content/></d:privilege><d:privilege><d:bind/></d:privilege>" +
+			"<d:privilege><d:unbind/></d:privilege>", true
+	case nsDAV + " supported-report-set":
+		return "<d:supported-report><d:report><c:calendar-query/></d:report></d:supported-report>" +
+			"<d:supported-report><d:report><c:calendar-multiget/></d:report></d:supported-report>", res.kind == davCollection
+	case nsCS + " getctag":
+		return escapeXML(res.ctag), res.kind == davCollection
+	case nsCalDAV + " supported-calendar-component-set":
+		return `<c:comp name="VEVENT"/>`, res.kind == davCollection
+	case nsApple + " calendar-color":
+		return "#BB9AF7", res.kind == davCollection
+	case nsDAV + " getetag":
+		return escapeXML(res.etag), res.kind == davObject
+	case nsDAV + " getcontenttype":
+		return "text/calendar; charset=utf-8; component=vevent", res.kind == davObject
+	case nsDAV + " getcontentlength":
+		return fmt.Sprint(len(res.data)), res.kind == davObject
+	case nsDAV + " getlastmodified":
+		if res.kind != davObject {
+			return "", false
+		}
+		last := res.series[0].UpdatedAt
+		for _, event := range res.series[1:] {
+			if event.UpdatedAt.After(last) {
+				last = event.UpdatedAt
+			}
+		}
+		return last.UTC().Format(time.RFC1123), true
+	case nsCalDAV + " calendar-data":
+		return escapeXML(string(res.data)), res.kind == davObject
+	}
+	return "", false
+}
+
+// sendMultistatus writes a 207 response with a propstat per resource, and a
+// 404 response for each href in missing.
+func sendMultistatus(c *fiber.Ctx, req *davRequest, resources []*davResource, missing ...string) error {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:c="` + nsCalDAV + `" xmlns:cs="` + nsCS + `" xmlns:ical="` + nsApple + `">`)
+
+	for _, res := range resources {
+		props := req.props
+		if req.allProp || len(props) == 0 {
+			props = defaultProps[res.kind]
+		}
+
+		var found, notFound strings.Builder
+		for i, name := range props {
+			value, ok := propValue(name, res)
+			if ok {
+				found.WriteString(element(name, value, i))
+			} else {
+				notFound.WriteString(element(name, "", i))
+			}
+		}
+
+		b.WriteString("<d:response><d:href>" + escapeXML(res.href) + "</d:href>")
+		if found.Len() > 0 {
+			b.WriteString("<d:propstat><d:prop>" + found.String() + "</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>")
+		}
+		if notFound.Len() > 0 {
+			b.WriteString("<d:propstat><d:prop>" + notFound.String() + "</d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>")
+		}
+		b.WriteString("</d:response>")
+	}
+	for _, href := range missing {
+		b.WriteString("<d:response><d:href>" + escapeXML(href) + "</d:href><d:status>HTTP/1.1 404 Not Found</d:status></d:response>")
+	}
+	b.WriteString("</d:multistatus>")
+
+	c.Set(fiber.HeaderContentType, "application/xml; charset=utf-8")
+	return c.Status(fiber.StatusMultiStatus).SendString(b.String())
+}
+
+// element renders a property element, declaring its namespace inline when it
+// isn't one of the well-known prefixes.
+func element(name xml.Name, value string, n int) string {
+	tag := name.Local
+	attrs := ""
+	if prefix, ok := nsPrefixes[name.Space]; ok {
+		tag = prefix + ":" + name.Local
+	} else if name.Space != "" {
+		prefix := fmt.Sprintf("x%d", n)
+		tag = prefix + ":" + name.Local
+		attrs = fmt.Sprintf(` xmlns:%s="%s"`, prefix, escapeXML(name.Space))
+	}
+	if value == "" {
+		return "<" + tag + attrs + "/>"
+	}
+	return "<" + tag + attrs + ">" + value + "</" + tag + ">"
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"bytes"
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/repository"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// userAccess loads the calendars the authenticated user can see.
+func userAccess(c *fiber.Ctx) (*repository.Access, error) {
+	return repository.LoadAccess(repository.DB, currentUser(c).ID)
+}
+
+// ListCalendars returns the user's calendars, the default one first, followed
+// by the calendars shared with them along with their role and the owner.
+func ListCalendars(c *fiber.Ctx) error {
+	access, err := userAccess(c)
+	if err != nil {
+		log.Printf("Error listing calendars: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list calendars",
+		})
+	}
+	calendars := access.Calendars
+	if calendars == nil {
+		calendars = []models.Calendar{}
+	}
+	return c.JSON(calendars)
+}
+
+// GetCalendar returns one of the user's calendars or one shared with them.
+func GetCalendar(c *fiber.Ctx) error {
+	access, err := userAccess(c)
+	if err != nil {
+		return calendarError(c, err, "Failed to fetch calendar")
+	}
+	calendar := access.Calendar(c.Params("id"))
+	if calendar == nil {
+		return calendarError(c, repository.ErrCalendarNotFound, "Failed to fetch calendar")
+	}
+	return c.JSON(calendar)
+}
+
+// CreateCalendar adds a calendar. Setting isDefault makes it the calendar
+// new events go to.
+func CreateCalendar(c *fiber.Ctx) error {
+	calendar := new(models.Calendar)
+	if err := c.BodyParser(calendar); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+	err := userDB(c).Transaction(func(tx *gorm.DB) error {
+		return repository.CreateCalendar(tx, currentUser(c).ID, calendar)
+	})
+	if err != nil {
+		return calendarError(c, err, "Failed to create calendar")
+	}
+	log.Printf("Created calendar %q", calendar.Name)
+	return c.Status(fiber.StatusCreated).JSON(calendar)
+}
+
+// UpdateCalendar changes the fields of a calendar given in the body, e.g.
+// {"hidden": true} to hide its events. A null defaultReminder removes the
+// reminder. Hiding a calendar shared with the user only hides it for them;
+// changing its other settings needs the manage role.
+func UpdateCalendar(c *fiber.Ctx) error {
+	user := currentUser(c)
+	var calendar *models.Calendar
+	err := repository.DB.Transaction(func(tx *gorm.DB) error {
+		access, err := repository.LoadAccess(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		current := access.Calendar(c.Params("id"))
+		if current == nil {
+			return repository.ErrCalendarNotFound
+		}
+		merged := *current
+		decoder := json.NewDecoder(bytes.NewReader(c.Body()))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&merged); err != nil {
+			return errBadCalendar{err}
+		}
+		// The ID comes from the URL; the timestamps, role and owner are
+		// managed by the server.
+		merged.ID, merged.CreatedAt, merged.UpdatedAt = current.ID, current.CreatedAt, current.UpdatedAt
+		merged.Role, merged.Owner = current.Role, current.Owner
+		shared := current.Role != models.RoleOwner
+		if shared {
+			if merged.IsDefault {
+				invalid := &models.ValidationError{}
+				invalid.Add("isDefault", "can only be set on your own calendars")
+				return invalid
+			}
+			if merged.Hidden != current.Hidden {
+				if err := repository.SetShareHidden(tx, current.ID, user.ID, merged.Hidden); err != nil {
+					return err
+				}
+			}
+			if !settingsChanged(current, &merged) {
+				calendar = &merged
+				return nil
+			}
+		}
+
+		db, stored, err := repository.CalendarAccess(tx, user.ID, current.ID, models.RoleManage)
+		if err != nil {
+			return err
+		}
+		updated := merged
+		if shared {
+			// The owner's visibility and default calendar stay theirs.
+			updated.Hidden, updated.IsDefault = stored.Hidden, stored.IsDefault
+		}
+		if err := repository.UpdateCalendar(db, &updated); err != nil {
+			return err
+		}
+		updated.Role, updated.Owner = current.Role, current.Owner
+		if shared {
+			updated.Hidden, updated.IsDefault = merged.Hidden, false
+		}
+		calendar = &updated
+		return nil
+	})
+	if err != nil {
+		return calendarError(c, err, "Failed to update calendar")
+	}
+	return c.JSON(calendar)
+}
+
+// DeleteCalendar deletes a calendar. Its events are moved to the calendar
+// given by moveTo or, without it, put in the trash. Only the owner of a
+// calendar can delete it.
+func DeleteCalendar(c *fiber.Ctx) error {
+	id := c.Params("id")
+	err := repository.DB.Transaction(func(tx *gorm.DB) error {
+		tx, _, err := repository.CalendarAccess(tx, currentUser(c).ID, id, models.RoleOwner)
+		if err != nil {
+			return err
+		}
+		ids, err := repository.CalendarEventIDs(tx, id)
+		if err != nil {
+			return err
+		}
+		audit, err := repository.BeginAudit(tx, ids...)
+		if err != nil {
+			return err
+		}
+		if err := repository.DeleteCalendar(tx, id, c.Query("moveTo")); err != nil {
+			return err
+		}
+		return audit.Record(requestActor(c))
+	})
+	if err != nil {
+		return calendarError(c, err, "Failed to delete calendar")
+	}
+	log.Printf("Deleted calendar %s", id)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ListShares returns who a calendar is shared with. It needs the manage role.
+func ListShares(c *fiber.Ctx) error {
+	db, calendar, err := repository.CalendarAccess(repository.DB, currentUser(c).ID, c.Params("id"), models.RoleManage)
+	if err != nil {
+		return calendarError(c, err, "Failed to list shares")
+	}
+	shares, err := repository.ListShares(db, calendar.ID)
+	if err != nil {
+		return calendarError(c, err, "Failed to list shares")
+	}
+	return c.JSON(shares)
+}
+
+// ShareCalendar shares a calendar with the user with the given email, e.g.
+// {"email": "sam@example.com", "role": "read"}, or changes their role. Roles
+// are freebusy, read, edit and manage. It needs the manage role.
+func ShareCalendar(c *fiber.Ctx) error {
+	var req struct {
+		Email string      `json:"email"`
+		Role  models.Role `json:"role"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+	var share *models.CalendarShare
+	err := repository.DB.Transaction(func(tx *gorm.DB) error {
+		_, calendar, err := repository.CalendarAccess(tx, currentUser(c).ID, c.Params("id"), models.RoleManage)
+		if err != nil {
+			return err
+		}
+		share, err = repository.ShareCalendar(tx, calendar, req.Email, req.Role)
+		return err
+	})
+	if err != nil {
+		return calendarError(c, err, "Failed to share calendar")
+	}
+	log.Printf("Shared calendar %s with %s as %s", share.CalendarID, share.Email, share.Role)
+	return c.JSON(share)
+}
+
+// Unshare stops sharing a calendar with a user. It needs the manage role,
+// except for users leaving a calendar shared with them.
+func Unshare(c *fiber.Ctx) error {
+	id, userID := c.Params("id"), c.Params("userId")
+	err := repository.DB.Transaction(func(tx *gorm.DB) error {
+		if userID != currentUser(c).ID {
+			if _, _, err := repository.CalendarAccess(tx, currentUser(c).ID, id, models.RoleManage); err != nil {
+				return err
+			}
+		}
+		return repository.Unshare(tx, id, userID)
+	})
+	if err != nil {
+		return calendarError(c, err, "Failed to unshare calendar")
+	}
+	log.Printf("Unshared calendar %s with user %s", id, userID)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// settingsChanged reports whether an update changes the settings of a
+// calendar that are shared by all the users who can see it.
+func settingsChanged(current, updated *models.Calendar) bool {
+	reminder := func(c *models.Calendar) int {
+		if c.DefaultReminder == nil {
+			return -1
+		}
+		return *c.DefaultReminder
+	}
+	return updated.Name != current.Name || updated.Color != current.Color ||
+		updated.TimeZone != current.TimeZone || reminder(updated) != reminder(current)
+}
+
+// errBadCalendar wraps a request body that isn't a calendar.
+type errBadCalendar struct{ err error }
+
+func (e errBadCalendar) Error() string { return "invalid calendar: " + e.err.Error() }
+
+func calendarError(c *fiber.Ctx, err error, message string) error {
+	var invalid *models.ValidationError
+	var bad errBadCalendar
+	switch {
+	case errors.As(err, &invalid):
+		return validationError(c, invalid)
+	case errors.As(err, &bad):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": bad.Error(),
+		})
+	case errors.Is(err, repository.ErrCalendarNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Calendar not found",
+		})
+	case errors.Is(err, repository.ErrShareNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Share not found",
+		})
+	case errors.Is(err, repository.ErrForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, repository.ErrDefaultCalendar):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	default:
+		log.Printf("%s: %v", message, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+}
@@ -2,20 +2,39 @@ package handlers
 
 import (
 	"calendar-backend/internal/ai"
+	"calendar-backend/internal/localtime"
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/repository"
+	"errors"
 	"log"
 	"os"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
+// maxHistoryMessages bounds how many earlier turns are sent to the AI provider.
+const maxHistoryMessages = 20
+
 type ChatRequest struct {
-	Message  string `json:"message"`
-	Timezone string `json:"timezone"`
+	Message        string `json:"message"`
+	Timezone       string `json:"timezone"`
+	ConversationID string `json:"conversationId,omitempty"`
 }
 
 type ChatResponse struct {
-	Message string             `json:"message"`
-	Action  *ai.CalendarAction `json:"action,omitempty"`
+	ConversationID string              `json:"conversationId"`
+	Message        string              `json:"message"`
+	Actions        []ai.CalendarAction `json:"actions,omitempty"`
+	// Action is the first of Actions, kept for clients that handle one action.
+	Action *ai.CalendarAction `json:"action,omitempty"`
+	// ActionID identifies the proposed batch for /api/chat/actions/:id/confirm
+	// and /reject. ActionStatus is "pending" unless it was auto-applied, in
+	// which case Results holds the outcome of each action.
+	ActionID     string            `json:"actionId,omitempty"`
+	ActionStatus string            `json:"actionStatus,omitempty"`
+	ActionError  string            `json:"actionError,omitempty"`
+	Results      []ai.ActionResult `json:"results,omitempty"`
 }
 
 var (
@@ -53,17 +72,135 @@ func HandleChat(c *fiber.Ctx) error {
 		})
 	}
 
-	// Query AI with user's message and timezone
-	message, action, err := aiProvider.Query(req.Message, req.Timezone)
+	// Times are exchanged with the AI in the user's zone, so it must be valid
+	if _, err := localtime.LoadLocation(req.Timezone); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Continue the given conversation. A new one is only started once the AI
+	// has answered, so that a failed request doesn't leave an empty one behind.
+	db := userDB(c)
+	conversationID := req.ConversationID
+	var history []models.ChatMessage
+	if conversationID != "" {
+		if _, err := repository.GetConversation(db, conversationID); err != nil {
+			return conversationError(c, err)
+		}
+
+		var err error
+		history, err = repository.RecentMessages(db, conversationID, maxHistoryMessages)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	// Query AI with the conversation so far, user's message and timezone
+	message, actions, err := aiProvider.Query(db, currentUser(c).ID, history, req.Message, req.Timezone)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
 
+	if conversationID == "" {
+		conversation, err := repository.CreateConversation(db, currentUser(c).ID, req.Message)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		conversationID = conversation.ID
+	}
+
+	userMessage := &models.ChatMessage{ID: uuid.New().String(), Role: "user", Content: req.Message}
+	actor := aiProvider.Actor()
+	actor.UserID = currentUser(c).ID
+	actor.ConversationID = conversationID
+	actor.MessageID = userMessage.ID
+
+	// Undo requests are carried out straight away, before the new changes
+	var proposed []ai.CalendarAction
+	for _, action := range actions {
+		if action.Type != "undo" {
+			proposed = append(proposed, action)
+			continue
+		}
+		message += "\n\n" + undoOutcome(db, conversationID, actor)
+	}
+	actions = proposed
+
+	response := ChatResponse{
+		ConversationID: conversationID,
+		Message:        message,
+		Actions:        actions,
+	}
+
+	// Calendar changes are only proposed here; they are applied once confirmed
+	reply := &models.ChatMessage{Role: "assistant", Content: message}
+	if len(actions) > 0 {
+		pending, results, err := proposeActions(db, actor, actions)
+		if err != nil {
+			log.Printf("Error proposing action for conversation %s: %v", conversationID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save proposed action",
+			})
+		}
+		reply.Actions = pending.Actions
+		reply.ActionID = pending.ID
+		reply.ActionStatus = pending.Status
+		response.Action = &actions[0]
+		response.ActionID = pending.ID
+		response.ActionStatus = pending.Status
+		response.ActionError = pending.Error
+		response.Results = results
+	}
+	err = repository.AppendMessages(db, conversationID, userMessage, reply)
+	if err != nil {
+		log.Printf("Error saving conversation %s: %v", conversationID, err)
+	}
+
 	// Return formatted response
-	return c.JSON(ChatResponse{
-		Message: message,
-		Action:  action,
+	return c.JSON(response)
+}
+
+func ListConversations(c *fiber.Ctx) error {
+	conversations, err := repository.ListConversations(userDB(c))
+	if err != nil {
+		log.Printf("Error listing conversations: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list conversations",
+		})
+	}
+	return c.JSON(conversations)
+}
+
+func GetConversation(c *fiber.Ctx) error {
+	conversation, err := repository.GetConversation(userDB(c), c.Params("id"))
+	if err != nil {
+		return conversationError(c, err)
+	}
+	return c.JSON(conversation)
+}
+
+func DeleteConversation(c *fiber.Ctx) error {
+	if err := repository.DeleteConversation(userDB(c), c.Params("id")); err != nil {
+		return conversationError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func conversationError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, repository.ErrConversationNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Conversation not found",
+		})
+	}
+	log.Printf("Conversation error: %v", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to load conversation",
 	})
 }
@@ -2,17 +2,117 @@ package handlers
 
 import (
 	"calendar-backend/internal/models"
+	"calendar-backend/internal/recurrence"
 	"calendar-backend/internal/repository"
+	"errors"
+	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
+// parseEventFilter reads the from/to, color, title, updatedSince and
+// calendars query parameters. Timestamps are expected in RFC3339 format and
+// calendars is a comma-separated list of calendar IDs.
+func parseEventFilter(c *fiber.Ctx) (repository.EventFilter, error) {
+	filter := repository.EventFilter{
+		Color: c.Query("color"),
+		Title: c.Query("title"),
+	}
+	for _, id := range strings.Split(c.Query("calendars"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			filter.CalendarIDs = append(filter.CalendarIDs, id)
+		}
+	}
+
+	var err error
+	if filter.From, err = parseTimeQuery(c, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseTimeQuery(c, "to"); err != nil {
+		return filter, err
+	}
+	if filter.UpdatedSince, err = parseTimeQuery(c, "updatedSince"); err != nil {
+		return filter, err
+	}
+
+	if filter.From != nil && filter.To != nil && !filter.To.After(*filter.From) {
+		return filter, fmt.Errorf("'to' must be after 'from'")
+	}
+	return filter, nil
+}
+
+func parseTimeQuery(c *fiber.Ctx, key string) (*time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid '%s' parameter, expected RFC3339 timestamp", key)
+	}
+	return &t, nil
+}
+
+// parseEvent reads an event from the request body. All-day events without a
+// later end last a single day, and attendees get the default role and status.
+func parseEvent(c *fiber.Ctx) (*models.Event, error) {
+	event := new(models.Event)
+	if err := c.BodyParser(event); err != nil {
+		return nil, err
+	}
+	event.NormalizeAttendees()
+	if event.AllDay && !event.Start.IsZero() && !event.End.After(event.Start) {
+		event.End = event.Start.AddDate(0, 0, 1)
+	}
+	return event, nil
+}
+
+// bodyError answers a request whose body couldn't be read as an event.
+func bodyError(c *fiber.Ctx, err error) error {
+	var invalid *models.ValidationError
+	if errors.As(err, &invalid) {
+		return validationError(c, invalid)
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"error": "Failed to parse request body",
+	})
+}
+
+// validationError answers with the field-level messages of a failed
+// validation, e.g. {"error": "...", "fields": [{"field": "title", "message": "is required"}]}.
+func validationError(c *fiber.Ctx, err *models.ValidationError) error {
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"error":  "Validation failed",
+		"fields": err.Fields,
+	})
+}
+
+// GetEvents returns the events matching the query parameters, from the
+// user's calendars and those shared with them. Events of hidden calendars are
+// left out unless calendars names the calendars.
 func GetEvents(c *fiber.Ctx) error {
+	filter, err := parseEventFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	access, err := userAccess(c)
 	var events []models.Event
-	result := repository.DB.Find(&events)
-	if result.Error != nil {
+	if err == nil {
+		if len(filter.CalendarIDs) == 0 {
+			filter.HiddenCalendarIDs = access.HiddenIDs()
+		}
+		events, err = access.FindEvents(repository.DB, filter)
+	}
+	if err != nil {
+		log.Printf("Error fetching events: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to fetch events",
 		})
@@ -21,66 +121,295 @@ func GetEvents(c *fiber.Ctx) error {
 	return c.JSON(events)
 }
 
+// GetEvent returns a stored event (a series master rather than its
+// occurrences) along with its ETag. Events of calendars shared as free/busy
+// only tell when they take place.
+func GetEvent(c *fiber.Ctx) error {
+	db, role, err := repository.EventAccess(repository.DB, currentUser(c).ID, c.Params("id"), models.RoleFreeBusy)
+	if err != nil {
+		return seriesError(c, err, "Failed to fetch event")
+	}
+	event, err := repository.GetEvent(db, c.Params("id"))
+	if err != nil {
+		return seriesError(c, err, "Failed to fetch event")
+	}
+	c.Set(fiber.HeaderETag, eventETag(event))
+	if !role.Allows(models.RoleRead) {
+		return c.JSON(event.FreeBusy())
+	}
+	return c.JSON(event)
+}
+
 func CreateEvent(c *fiber.Ctx) error {
-	event := new(models.Event)
-	if err := c.BodyParser(event); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Failed to parse request body",
-		})
+	event, err := parseEvent(c)
+	if err != nil {
+		return bodyError(c, err)
+	}
+	if err := event.Validate(); err != nil {
+		return seriesError(c, err, "Failed to create event")
 	}
 
 	event.ID = uuid.New().String()
-	result := repository.DB.Create(&event)
-	if result.Error != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create event",
-		})
+	// Times are stored in UTC: SQLite compares them as text, so the range
+	// queries of GetEvents would mismatch times stored with other offsets.
+	event.Start, event.End = event.Start.UTC(), event.End.UTC()
+	event.UserID = currentUser(c).ID
+	allowConflict := c.QueryBool("allowConflict")
+	err = userDB(c).Transaction(func(tx *gorm.DB) error {
+		// An event added to a shared calendar belongs to its owner.
+		if event.CalendarID != "" {
+			owner, calendar, err := calendarAccess(c, tx, event.CalendarID)
+			if err != nil {
+				return err
+			}
+			tx, event.UserID = owner, calendar.UserID
+		}
+		calendar, err := repository.AssignCalendar(tx, event)
+		if err != nil {
+			return err
+		}
+		calendar.ApplyTimeZone(event)
+		if err := repository.FillOrganizer(tx, event); err != nil {
+			return err
+		}
+		if !allowConflict {
+			if err := repository.CheckConflicts(tx, event); err != nil {
+				return err
+			}
+		}
+		audit, err := repository.BeginAudit(tx)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(event).Error; err != nil {
+			return err
+		}
+		return audit.Record(requestActor(c), event.ID)
+	})
+	if err != nil {
+		return seriesError(c, err, "Failed to create event")
 	}
 
+	c.Set(fiber.HeaderETag, eventETag(event))
 	return c.Status(fiber.StatusCreated).JSON(event)
 }
 
-func UpdateEvent(c *fiber.Ctx) error {
-	id := c.Params("id")
-	event := new(models.Event)
+// parseScope reads the scope and recurrenceId query parameters that select
+// which part of a recurring series an update or delete applies to.
+func parseScope(c *fiber.Ctx) (repository.Scope, *time.Time, error) {
+	scope, err := repository.ParseScope(c.Query("scope"))
+	if err != nil {
+		return "", nil, err
+	}
+	recurrenceID, err := parseTimeQuery(c, "recurrenceId")
+	if err != nil {
+		return "", nil, err
+	}
+	return scope, recurrenceID, nil
+}
 
-	if err := c.BodyParser(event); err != nil {
+// seriesError maps repository errors from creates and scoped updates and
+// deletes onto responses. Conflicts are reported with the overlapping events.
+func seriesError(c *fiber.Ctx, err error, message string) error {
+	var conflict *repository.ConflictError
+	var invalid *models.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		return validationError(c, invalid)
+	case errors.Is(err, errStaleEvent):
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.As(err, &conflict):
+		conflicts := conflict.Conflicts
+		if access, err := userAccess(c); err == nil {
+			conflicts = access.Filter(conflicts)
+		}
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":     "Event overlaps existing events; pass allowConflict=true to save it anyway",
+			"conflicts": conflicts,
+		})
+	case errors.Is(err, repository.ErrNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Event not found",
+		})
+	case errors.Is(err, repository.ErrForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, repository.ErrNotOccurrence), errors.Is(err, repository.ErrNeedsRecurrenceID):
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Failed to parse request body",
+			"error": err.Error(),
 		})
-	}
-
-	result := repository.DB.Model(&models.Event{}).Where("id = ?", id).Updates(event)
-	if result.Error != nil {
+	default:
+		log.Printf("%s: %v", message, err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to update event",
+			"error": message,
 		})
 	}
+}
 
-	if result.RowsAffected == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Event not found",
+func UpdateEvent(c *fiber.Ctx) error {
+	id := c.Params("id")
+	event, err := parseEvent(c)
+	if err != nil {
+		return bodyError(c, err)
+	}
+	event.Start, event.End = event.Start.UTC(), event.End.UTC()
+
+	// The ID comes from the URL; the timestamps are managed by the server.
+	if event.ID != "" && event.ID != id {
+		invalid := &models.ValidationError{}
+		invalid.Add("id", "cannot be changed")
+		return validationError(c, invalid)
+	}
+	event.ID = ""
+	event.CreatedAt, event.UpdatedAt = time.Time{}, time.Time{}
+	if event.IsRecurring() {
+		// Checked up front since splitting a series needs the new rule.
+		if _, err := recurrence.Parse(event.RRule); err != nil {
+			invalid := &models.ValidationError{}
+			invalid.Add("rrule", "is invalid: %v", err)
+			return validationError(c, invalid)
+		}
+	}
+
+	// Unlike a merge patch, PUT replaces the event: fields left out or set to
+	// their zero value are cleared. The event stays in its calendar unless
+	// another one is given.
+	changes, err := eventColumns(event)
+	if err != nil {
+		return bodyError(c, err)
+	}
+	if event.CalendarID == "" {
+		delete(changes, "calendar_id")
+	}
+
+	scope, recurrenceID, err := parseScope(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
 		})
 	}
 
-	return c.JSON(event)
+	// The update is validated and checked for conflicts once applied, since
+	// the scope decides which rows end up holding the changes.
+	allowConflict := c.QueryBool("allowConflict")
+	var updated *models.Event
+	err = repository.DB.Transaction(func(tx *gorm.DB) error {
+		tx, err := editAccess(c, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(c, tx, id); err != nil {
+			return err
+		}
+		audit, err := repository.BeginAudit(tx, id)
+		if err != nil {
+			return err
+		}
+		if updated, err = repository.UpdateEvent(tx, id, changes, scope, recurrenceID); err != nil {
+			return err
+		}
+		if _, _, err := calendarAccess(c, tx, updated.CalendarID); err != nil {
+			return err
+		}
+		if err := repository.FillOrganizer(tx, updated); err != nil {
+			return err
+		}
+		if err := updated.Validate(); err != nil {
+			return err
+		}
+		if !allowConflict {
+			if err := repository.CheckConflicts(tx, updated); err != nil {
+				return err
+			}
+		}
+		return audit.Record(requestActor(c), updated.ID)
+	})
+	if err != nil {
+		return seriesError(c, err, "Failed to update event")
+	}
+
+	c.Set(fiber.HeaderETag, eventETag(updated))
+	return c.JSON(updated)
 }
 
 func DeleteEvent(c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	result := repository.DB.Delete(&models.Event{}, "id = ?", id)
-	if result.Error != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to delete event",
+	scope, recurrenceID, err := parseScope(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
 		})
 	}
 
-	if result.RowsAffected == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Event not found",
-		})
+	err = repository.DB.Transaction(func(tx *gorm.DB) error {
+		tx, err := editAccess(c, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(c, tx, id); err != nil {
+			return err
+		}
+		audit, err := repository.BeginAudit(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := repository.DeleteEvent(tx, id, scope, recurrenceID); err != nil {
+			return err
+		}
+		return audit.Record(requestActor(c))
+	})
+	if err != nil {
+		return seriesError(c, err, "Failed to delete event")
 	}
 
 	return c.SendStatus(fiber.StatusNoContent)
 }
+
+// editAccess checks that the user may change the event id, which may be in a
+// calendar shared with them, and returns the handle on tx to change it with.
+func editAccess(c *fiber.Ctx, tx *gorm.DB, id string) (*gorm.DB, error) {
+	db, _, err := repository.EventAccess(tx, currentUser(c).ID, id, models.RoleEdit)
+	return db, err
+}
+
+// calendarAccess checks that the user may put events into a calendar. Unknown
+// calendars fail validation.
+func calendarAccess(c *fiber.Ctx, tx *gorm.DB, id string) (*gorm.DB, *models.Calendar, error) {
+	db, calendar, err := repository.CalendarAccess(tx, currentUser(c).ID, id, models.RoleEdit)
+	if errors.Is(err, repository.ErrCalendarNotFound) {
+		invalid := &models.ValidationError{}
+		invalid.Add("calendarId", "does not exist")
+		return nil, nil, invalid
+	}
+	return db, calendar, err
+}
+
+// errStaleEvent is returned when If-Match doesn't match the stored event.
+var errStaleEvent = errors.New("event has been changed since it was read; fetch it again and retry")
+
+// eventETag identifies a version of a stored event. UpdatedAt changes on
+// every write, with nanosecond precision.
+func eventETag(event *models.Event) string {
+	return fmt.Sprintf(`"%x"`, event.UpdatedAt.UnixNano())
+}
+
+// checkIfMatch enforces the request's If-Match header, if any, against the
+// stored event inside the transaction that is about to change it.
+func checkIfMatch(c *fiber.Ctx, tx *gorm.DB, id string) error {
+	match := c.Get(fiber.HeaderIfMatch)
+	if match == "" {
+		return nil
+	}
+	current, err := repository.GetEvent(tx, id)
+	if err != nil {
+		return err
+	}
+	if match != "*" && !etagListContains(match, eventETag(current)) {
+		return errStaleEvent
+	}
+	return nil
+}
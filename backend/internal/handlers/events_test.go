@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testServer serves the event routes over a fresh database, authenticated
+// like the API of the server.
+func testServer(t *testing.T) *fiber.App {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "calendar.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	err = db.AutoMigrate(&models.User{}, &models.Session{}, &models.APIToken{}, &models.Calendar{}, &models.CalendarShare{}, &models.Event{}, &models.AuditEntry{}, &models.OutgoingMail{})
+	if err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	previous := repository.DB
+	repository.DB = db
+	t.Cleanup(func() { repository.DB = previous })
+
+	app := fiber.New()
+	events := app.Group("/api/events", RequireAuth)
+	events.Get("/", GetEvents)
+	events.Post("/", CreateEvent)
+	events.Get("/:id", GetEvent)
+	events.Put("/:id", UpdateEvent)
+	events.Patch("/:id", PatchEvent)
+	events.Delete("/:id", DeleteEvent)
+	return app
+}
+
+// login creates a user and returns a session token of theirs.
+func login(t *testing.T, email string) (*models.User, string) {
+	t.Helper()
+	user, err := repository.CreateUser(email, "", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	token, _, err := repository.CreateSession(user.ID)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	return user, token
+}
+
+// send makes a request with the given Authorization header and a JSON body,
+// decoding the JSON answer into out if it is set. It returns the status.
+func send(t *testing.T, app *fiber.App, method, path, authorization string, body, out interface{}) int {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if authorization != "" {
+		req.Header.Set(fiber.HeaderAuthorization, authorization)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		message, _ := io.ReadAll(resp.Body)
+		t.Logf("%s %s gave %d: %s", method, path, resp.StatusCode, message)
+	} else if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("failed to decode the answer to %s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestUpdateEventReplaces(t *testing.T) {
+	app := testServer(t)
+	_, token := login(t, "alex@example.com")
+	auth := "Bearer " + token
+
+	var created models.Event
+	status := send(t, app, http.MethodPost, "/api/events/", auth, map[string]interface{}{
+		"title": "Offsite", "description": "Bring a laptop", "start": "2026-10-20", "end": "2026-10-21",
+		"allDay": true, "transparent": true, "color": "var(--tokyo-red)",
+	}, &created)
+	if status != http.StatusCreated && status != http.StatusOK {
+		t.Fatalf("creating the event gave %d", status)
+	}
+
+	// Fields left out are cleared rather than kept.
+	var replaced models.Event
+	status = send(t, app, http.MethodPut, "/api/events/"+created.ID, auth, map[string]interface{}{
+		"title": "Planning", "start": "2026-10-20T09:00:00Z", "end": "2026-10-20T10:00:00Z",
+	}, &replaced)
+	if status != http.StatusOK {
+		t.Fatalf("replacing the event gave %d", status)
+	}
+	stored, err := repository.GetEvent(repository.DB, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []*models.Event{&replaced, stored} {
+		if event.Title != "Planning" || event.Description != "" || event.AllDay || event.Transparent || event.Color != "" {
+			t.Errorf("replaced event is %+v, want only a title and times", event)
+		}
+		if event.CalendarID != created.CalendarID {
+			t.Errorf("event moved to calendar %q, want it kept in %q", event.CalendarID, created.CalendarID)
+		}
+	}
+
+	// A merge patch keeps them.
+	var patched models.Event
+	status = send(t, app, http.MethodPatch, "/api/events/"+created.ID, auth, map[string]interface{}{"description": "Agenda"}, &patched)
+	if status != http.StatusOK {
+		t.Fatalf("patching the event gave %d", status)
+	}
+	status = send(t, app, http.MethodPatch, "/api/events/"+created.ID, auth, map[string]interface{}{"title": "Q4 planning"}, &patched)
+	if status != http.StatusOK || patched.Title != "Q4 planning" || patched.Description != "Agenda" {
+		t.Errorf("patching the title gave %d with %q, %q, want the description kept", status, patched.Title, patched.Description)
+	}
+}
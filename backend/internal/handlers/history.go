@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/repository"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// requestActor identifies who makes the changes of a request in the audit log.
+func requestActor(c *fiber.Ctx) models.Actor {
+	return models.Actor{Type: models.ActorUser, UserID: currentUser(c).ID}
+}
+
+// GetEventHistory returns the audit entries of an event, oldest first,
+// including those of the overrides of a series. The history of an event in a
+// shared calendar needs read access to it.
+func GetEventHistory(c *fiber.Ctx) error {
+	id := c.Params("id")
+	db, _, err := repository.EventAccess(repository.DB, currentUser(c).ID, id, models.RoleRead)
+	if errors.Is(err, repository.ErrNotFound) {
+		// Purged events are gone but for their history, which only their
+		// owner sees.
+		db, err = userDB(c), nil
+	}
+	var entries []models.AuditEntry
+	if err == nil {
+		entries, err = repository.EventHistory(db, id)
+	}
+	switch {
+	case errors.Is(err, repository.ErrForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, repository.ErrNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Event not found",
+		})
+	case err != nil:
+		log.Printf("Error loading history of event %s: %v", id, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load history",
+		})
+	}
+	return c.JSON(entries)
+}
+
+// ListHistory returns the audit entries of all events, newest first. The
+// actor (e.g. "ai") and conversationId query parameters filter them, limit
+// and before page through them.
+func ListHistory(c *fiber.Ctx) error {
+	filter := repository.AuditFilter{
+		ActorType:      c.Query("actor"),
+		ConversationID: c.Query("conversationId"),
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "'limit' must be a positive number",
+			})
+		}
+		filter.Limit = limit
+	}
+	if value := c.Query("before"); value != "" {
+		before, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "'before' must be an entry ID",
+			})
+		}
+		filter.Before = uint(before)
+	}
+
+	entries, err := repository.ListAudit(userDB(c), filter)
+	if err != nil {
+		log.Printf("Error loading audit log: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load history",
+		})
+	}
+	return c.JSON(entries)
+}
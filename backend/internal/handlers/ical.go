@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"bytes"
+	"calendar-backend/internal/ical"
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/repository"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// feedRefreshInterval is advertised to subscribed clients as the polling interval.
+const feedRefreshInterval = time.Hour
+
+// ExportICS serves the calendar as an iCalendar feed. It accepts the same
+// filters as GetEvents, though hidden calendars are only hidden in the
+// calendar view, and recurring events are exported as RRULEs rather than
+// expanded occurrences. Events get the default reminder of their calendar.
+// Events of calendars shared as free/busy only are exported as busy time.
+func ExportICS(c *fiber.Ctx) error {
+	filter, err := parseEventFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	access, err := userAccess(c)
+	var events []models.Event
+	if err == nil {
+		events, err = access.FindEventRows(repository.DB, filter)
+	}
+	if err != nil {
+		log.Printf("Error fetching events for export: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to export events",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, `inline; filename="calendar.ics"`)
+	return c.Send(ical.Encode(ical.Calendar{
+		Name:            "Calendar Bot",
+		Method:          ical.MethodPublish,
+		RefreshInterval: feedRefreshInterval,
+		Events:          events,
+		Reminders:       access.Reminders(),
+	}))
+}
+
+// GetFeed reports whether the user's iCalendar feed is on. Its URL can't be
+// shown again, since only the hash of its token is stored; RegenerateFeed
+// issues a new one.
+func GetFeed(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"active": currentUser(c).FeedTokenHash != "",
+	})
+}
+
+// RegenerateFeed gives the user a new feed token and returns the
+// subscription URLs for the iCalendar feed, as plain HTTP(S) and as
+// webcal:// for clients that register that scheme. The URLs carry the token,
+// since subscribing clients can't log in, and stop any earlier URLs from
+// working. They are only ever returned here.
+func RegenerateFeed(c *fiber.Ctx) error {
+	token, err := repository.RegenerateFeedToken(currentUser(c))
+	if err != nil {
+		log.Printf("Error creating feed token: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create feed URL",
+		})
+	}
+	url := c.BaseURL() + "/api/calendar.ics?token=" + token
+	webcal := "webcal://" + strings.TrimPrefix(strings.TrimPrefix(url, "https://"), "http://")
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"url":    url,
+		"webcal": webcal,
+	})
+}
+
+// DeleteFeed turns the user's iCalendar feed off, e.g. after its URL leaked.
+func DeleteFeed(c *fiber.Ctx) error {
+	if err := repository.RevokeFeedToken(currentUser(c)); err != nil {
+		log.Printf("Error revoking feed token: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to turn the feed off",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ImportICS imports the VEVENTs of an iCalendar file, sent either as the raw
+// request body or as a multipart "file" field. Events are upserted by UID and
+// the response reports which were created, updated or skipped.
+func ImportICS(c *fiber.Ctx) error {
+	var body io.Reader = bytes.NewReader(c.Body())
+	if strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEMultipartForm) {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Missing 'file' form field",
+			})
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to read uploaded file",
+			})
+		}
+		defer file.Close()
+		body = file
+	}
+
+	report, err := repository.ImportICS(userDB(c), currentUser(c).ID, body)
+	if err != nil {
+		log.Printf("Error importing calendar: %v", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	log.Printf("Imported calendar: %d created, %d updated, %d skipped", report.Created, report.Updated, report.Skipped)
+	return c.JSON(report)
+}
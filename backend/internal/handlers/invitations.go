@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/repository"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ListInvitations returns the events the user is invited to as an attendee,
+// whoever's calendar they are in. It takes the same from, to, title and
+// color parameters as GetEvents, and status to only return the invitations
+// the user has given that response to, e.g. status=needs-action.
+func ListInvitations(c *fiber.Ctx) error {
+	filter, err := parseEventFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	status := models.PartStat(c.Query("status"))
+	if status != "" && !models.IsPartStat(status) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid 'status' parameter, expected one of: needs-action, accepted, declined, tentative",
+		})
+	}
+	email := currentUser(c).Email
+	filter.CalendarIDs = nil
+	filter.Attendee = email
+
+	events, err := repository.FindEvents(repository.DB, filter)
+	if err != nil {
+		log.Printf("Error fetching invitations: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch invitations",
+		})
+	}
+	invitations := []models.Event{}
+	for _, event := range events {
+		if attendee := event.Attendee(email); attendee != nil && (status == "" || attendee.Status == status) {
+			invitations = append(invitations, event)
+		}
+	}
+	return c.JSON(invitations)
+}
+
+// RespondToInvitation records the user's response to an event they are
+// invited to, e.g. {"status": "accepted"}. Like UpdateEvent it takes the
+// scope and recurrenceId parameters, so that a single occurrence of a
+// recurring event can be declined. Responding needs no access to the
+// calendar of the event.
+func RespondToInvitation(c *fiber.Ctx) error {
+	var req struct {
+		Status models.PartStat `json:"status"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+	scope, recurrenceID, err := parseScope(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	id, email := c.Params("id"), currentUser(c).Email
+	var updated *models.Event
+	err = repository.DB.Transaction(func(tx *gorm.DB) error {
+		tx, err := repository.InvitationAccess(tx, email, id)
+		if err != nil {
+			return err
+		}
+		audit, err := repository.BeginAudit(tx, id)
+		if err != nil {
+			return err
+		}
+		if updated, err = repository.RespondToEvent(tx, id, email, req.Status, scope, recurrenceID); err != nil {
+			return err
+		}
+		return audit.Record(requestActor(c), updated.ID)
+	})
+	if err != nil {
+		return seriesError(c, err, "Failed to respond to invitation")
+	}
+	log.Printf("%s responded %s to event %s", email, req.Status, updated.ID)
+	return c.JSON(updated)
+}
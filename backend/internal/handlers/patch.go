@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/repository"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// patchColumns maps the event fields a merge patch may change onto their
+// columns.
+var patchColumns = map[string]string{
+	"title":       "title",
+	"description": "description",
+	"start":       "start",
+	"end":         "end",
+	"timezone":    "time_zone",
+	"allDay":      "all_day",
+	"floating":    "floating",
+	"transparent": "transparent",
+	"color":       "color",
+	"calendarId":  "calendar_id",
+	"rrule":       "rrule",
+	"exdates":     "ex_dates",
+	"organizer":   "organizer",
+	"attendees":   "attendees",
+}
+
+// readOnlyFields may appear in a patch (e.g. when a client sends back an
+// event it fetched) but are left alone.
+var readOnlyFields = map[string]bool{
+	"id": true, "userId": true, "createdAt": true, "updatedAt": true, "recurringEventId": true, "recurrenceId": true,
+}
+
+// PatchEvent applies a JSON Merge Patch (RFC 7386) to an event: fields set
+// to null are cleared, fields left out keep their values. Like UpdateEvent it
+// honours the scope, If-Match and allowConflict parameters.
+func PatchEvent(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(c.Body(), &patch); err != nil || patch == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "The request body must be a JSON object",
+		})
+	}
+	invalid := &models.ValidationError{}
+	fields := make([]string, 0, len(patch))
+	for field := range patch {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		switch {
+		case field == "id" && string(patch[field]) != `"`+id+`"`:
+			invalid.Add("id", "cannot be changed")
+		case readOnlyFields[field]:
+			delete(patch, field)
+		case patchColumns[field] == "":
+			invalid.Add(field, "is not a known field")
+		}
+	}
+	if err := invalid.Err(); err != nil {
+		return validationError(c, invalid)
+	}
+
+	scope, recurrenceID, err := parseScope(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	allowConflict := c.QueryBool("allowConflict")
+	var updated *models.Event
+	err = repository.DB.Transaction(func(tx *gorm.DB) error {
+		tx, err := editAccess(c, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(c, tx, id); err != nil {
+			return err
+		}
+		current, err := repository.GetEvent(tx, id)
+		if err != nil {
+			return err
+		}
+		changes, err := mergeEvent(current, patch)
+		if err != nil {
+			return err
+		}
+		audit, err := repository.BeginAudit(tx, id)
+		if err != nil {
+			return err
+		}
+		if updated, err = repository.UpdateEvent(tx, id, changes, scope, recurrenceID); err != nil {
+			return err
+		}
+		if _, _, err := calendarAccess(c, tx, updated.CalendarID); err != nil {
+			return err
+		}
+		if err := repository.FillOrganizer(tx, updated); err != nil {
+			return err
+		}
+		if err := updated.Validate(); err != nil {
+			return err
+		}
+		if !allowConflict {
+			if err := repository.CheckConflicts(tx, updated); err != nil {
+				return err
+			}
+		}
+		return audit.Record(requestActor(c), updated.ID)
+	})
+	if err != nil {
+		return seriesError(c, err, "Failed to update event")
+	}
+
+	c.Set(fiber.HeaderETag, eventETag(updated))
+	return c.JSON(updated)
+}
+
+// mergeEvent applies the patch to the JSON form of the event and returns the
+// changed columns with their new values. Going through the JSON form means
+// times in the patch are read the way they would be for the merged event,
+// e.g. as dates if it is all-day.
+func mergeEvent(event *models.Event, patch map[string]json.RawMessage) (map[string]interface{}, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	for field, value := range patch {
+		if string(value) == "null" {
+			delete(document, field)
+		} else {
+			// Event fields are flat, so merging replaces whole values.
+			document[field] = value
+		}
+	}
+	if data, err = json.Marshal(document); err != nil {
+		return nil, err
+	}
+	var merged models.Event
+	if err := json.Unmarshal(data, &merged); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			invalid := &models.ValidationError{}
+			invalid.Add(typeErr.Field, "must be a %s", typeErr.Type)
+			return nil, invalid
+		}
+		return nil, err
+	}
+
+	merged.NormalizeAttendees()
+
+	columns, err := eventColumns(&merged)
+	if err != nil {
+		return nil, err
+	}
+	changes := make(map[string]interface{}, len(patch))
+	for field := range patch {
+		changes[patchColumns[field]] = columns[patchColumns[field]]
+	}
+	return changes, nil
+}
+
+// eventColumns returns the values of every field a client may set, keyed by
+// their column as Updates takes them.
+func eventColumns(event *models.Event) (map[string]interface{}, error) {
+	// Map updates bypass the column's JSON serializer.
+	exdates, err := json.Marshal(event.ExDates)
+	if err != nil {
+		return nil, err
+	}
+	attendees, err := json.Marshal(event.Attendees)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"title":       event.Title,
+		"description": event.Description,
+		"start":       event.Start,
+		"end":         event.End,
+		"time_zone":   event.TimeZone,
+		"all_day":     event.AllDay,
+		"floating":    event.Floating,
+		"transparent": event.Transparent,
+		"color":       event.Color,
+		"calendar_id": event.CalendarID,
+		"rrule":       event.RRule,
+		"ex_dates":    string(exdates),
+		"organizer":   event.Organizer,
+		"attendees":   string(attendees),
+	}, nil
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/repository"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ListTrash returns the deleted events that can still be restored.
+func ListTrash(c *fiber.Ctx) error {
+	items, err := repository.ListTrash(userDB(c))
+	if err != nil {
+		log.Printf("Error listing trash: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list trash",
+		})
+	}
+	return c.JSON(items)
+}
+
+// RestoreEvent takes an event out of the trash.
+func RestoreEvent(c *fiber.Ctx) error {
+	var restored *models.Event
+	err := repository.DB.Transaction(func(tx *gorm.DB) error {
+		tx, err := editAccess(c, tx, c.Params("id"))
+		if err != nil {
+			return err
+		}
+		audit, err := repository.BeginAudit(tx, c.Params("id"))
+		if err != nil {
+			return err
+		}
+		if restored, err = repository.RestoreEvent(tx, c.Params("id")); err != nil {
+			return err
+		}
+		return audit.Record(requestActor(c))
+	})
+	if errors.Is(err, repository.ErrNotInTrash) || errors.Is(err, repository.ErrSeriesDeleted) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return seriesError(c, err, "Failed to restore event")
+	}
+	log.Printf("Restored event %s", restored.ID)
+	c.Set(fiber.HeaderETag, eventETag(restored))
+	return c.JSON(restored)
+}
+
+// HardDeleteEvent permanently deletes an event, bypassing the trash.
+func HardDeleteEvent(c *fiber.Ctx) error {
+	id := c.Params("id")
+	err := repository.DB.Transaction(func(tx *gorm.DB) error {
+		audit, err := repository.BeginAudit(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := repository.HardDeleteEvent(tx, id); err != nil {
+			return err
+		}
+		return audit.Record(models.Actor{Type: models.ActorAdmin})
+	})
+	if err != nil {
+		return seriesError(c, err, "Failed to delete event")
+	}
+	log.Printf("Permanently deleted event %s", id)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RequireAdmin guards admin endpoints with the ADMIN_TOKEN environment
+// variable, passed as a bearer token. They are disabled when it is unset.
+func RequireAdmin(c *fiber.Ctx) error {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Admin endpoints are disabled; set ADMIN_TOKEN to enable them",
+		})
+	}
+	given := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid admin token",
+		})
+	}
+	return c.Next()
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/repository"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type UndoRequest struct {
+	ConversationID string `json:"conversationId"`
+}
+
+// UndoLastAction reverts the calendar changes the assistant applied most
+// recently in a conversation. It is refused with 409 if one of the events
+// has been edited since.
+func UndoLastAction(c *fiber.Ctx) error {
+	var req UndoRequest
+	if err := c.BodyParser(&req); err != nil || req.ConversationID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "conversationId is required",
+		})
+	}
+	db := userDB(c)
+	if _, err := repository.GetConversation(db, req.ConversationID); err != nil {
+		return conversationError(c, err)
+	}
+
+	actor := requestActor(c)
+	actor.ConversationID = req.ConversationID
+	pending, undone, err := repository.UndoLastAction(db, req.ConversationID, actor)
+	var edited *repository.EditedError
+	switch {
+	case errors.Is(err, repository.ErrNothingToUndo):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.As(err, &edited):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+			"event": edited.Event,
+		})
+	case errors.Is(err, repository.ErrActionResolved):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "The change is already being undone",
+		})
+	case errors.Is(err, repository.ErrForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case err != nil:
+		log.Printf("Error undoing last action of conversation %s: %v", req.ConversationID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to undo the last change",
+		})
+	}
+
+	message := describeUndo(undone)
+	recordOutcome(db, pending, message)
+	return c.JSON(fiber.Map{
+		"message": message,
+		"action":  pending,
+		"changes": undone,
+	})
+}
+
+// undoOutcome undoes the last change of the conversation on behalf of actor
+// in db and describes the outcome, successful or not, for the user.
+func undoOutcome(db *gorm.DB, conversationID string, actor models.Actor) string {
+	_, undone, err := repository.UndoLastAction(db, conversationID, actor)
+	var edited *repository.EditedError
+	switch {
+	case errors.Is(err, repository.ErrNothingToUndo), errors.Is(err, repository.ErrForbidden), errors.As(err, &edited):
+		return fmt.Sprintf("Nothing was undone: %s.", err)
+	case err != nil:
+		log.Printf("Error undoing last action of conversation %s: %v", conversationID, err)
+		return "Nothing was undone: the last change could not be reverted."
+	}
+	return describeUndo(undone)
+}
+
+// describeUndo lists the events an undo put back.
+func describeUndo(undone []repository.UndoneChange) string {
+	if len(undone) == 0 {
+		return "Undid the last change; it left no events to revert."
+	}
+	parts := make([]string, len(undone))
+	for i, change := range undone {
+		parts[i] = fmt.Sprintf("%s %q", change.Change, change.Title)
+	}
+	return "Undid the last change: " + strings.Join(parts, ", ") + "."
+}
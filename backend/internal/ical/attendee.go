@@ -0,0 +1,81 @@
+package ical
+
+import (
+	"calendar-backend/internal/models"
+	"strings"
+)
+
+// writeAttendees adds the ORGANIZER and an ATTENDEE per attendee of event.
+func writeAttendees(w *writer, event *models.Event) {
+	if event.Organizer != "" {
+		w.line("ORGANIZER", nil, "mailto:"+event.Organizer)
+	}
+	for _, attendee := range event.Attendees {
+		params := []string{
+			"ROLE=" + attendeeRoles[attendee.Role],
+			"PARTSTAT=" + strings.ToUpper(string(attendee.Status)),
+		}
+		if attendee.Status == models.PartStatNeedsAction {
+			params = append(params, "RSVP=TRUE")
+		}
+		if attendee.Name != "" {
+			params = append(params, "CN="+paramValue(attendee.Name))
+		}
+		w.line("ATTENDEE", params, "mailto:"+attendee.Email)
+	}
+}
+
+// readAttendees sets the organizer and attendees of event from the
+// ORGANIZER and ATTENDEE properties of vevent. Attendees that aren't
+// addressed by email, and unknown roles and statuses, are skipped or
+// replaced by the defaults.
+func readAttendees(vevent *Component, event *models.Event) {
+	if prop := vevent.Get("ORGANIZER"); prop != nil {
+		event.Organizer = CalAddress(prop.Value)
+	}
+	for _, prop := range vevent.GetAll("ATTENDEE") {
+		email := CalAddress(prop.Value)
+		if email == "" || event.Attendee(email) != nil {
+			continue
+		}
+		attendee := models.Attendee{Email: email, Name: prop.Params["CN"]}
+		for role, value := range attendeeRoles {
+			if strings.EqualFold(prop.Params["ROLE"], value) {
+				attendee.Role = role
+			}
+		}
+		if status := models.PartStat(strings.ToLower(prop.Params["PARTSTAT"])); models.IsPartStat(status) {
+			attendee.Status = status
+		}
+		event.Attendees = append(event.Attendees, attendee)
+	}
+	event.NormalizeAttendees()
+}
+
+// CalAddress returns the email of a CAL-ADDRESS value such as
+// "mailto:sam@example.com", or "" if it isn't a mailto URI.
+func CalAddress(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) < len("mailto:") || !strings.EqualFold(value[:len("mailto:")], "mailto:") {
+		return ""
+	}
+	return models.NormalizeEmail(value[len("mailto:"):])
+}
+
+// attendeeRoles maps attendee roles onto their ROLE parameter values.
+var attendeeRoles = map[models.AttendeeRole]string{
+	models.AttendeeChair:       "CHAIR",
+	models.AttendeeRequired:    "REQ-PARTICIPANT",
+	models.AttendeeOptional:    "OPT-PARTICIPANT",
+	models.AttendeeInformation: "NON-PARTICIPANT",
+}
+
+// paramValue quotes a parameter value if it contains characters that
+// separate parameters. Parameter values can't contain double quotes.
+func paramValue(s string) string {
+	s = strings.ReplaceAll(s, `"`, "'")
+	if strings.ContainsAny(s, ";:,") {
+		return `"` + s + `"`
+	}
+	return s
+}
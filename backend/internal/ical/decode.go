@@ -0,0 +1,379 @@
+package ical
+
+import (
+	"bufio"
+	"calendar-backend/internal/models"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Property is a single content line such as "DTSTART;TZID=Europe/Paris:20250101T090000".
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// Component is a parsed BEGIN/END block with its properties and children.
+type Component struct {
+	Name       string
+	Properties []Property
+	Children   []*Component
+}
+
+// Get returns the first property with the given name, or nil.
+func (c *Component) Get(name string) *Property {
+	for i := range c.Properties {
+		if c.Properties[i].Name == name {
+			return &c.Properties[i]
+		}
+	}
+	return nil
+}
+
+// GetAll returns every property with the given name.
+func (c *Component) GetAll(name string) []Property {
+	var props []Property
+	for _, prop := range c.Properties {
+		if prop.Name == name {
+			props = append(props, prop)
+		}
+	}
+	return props
+}
+
+// Parse reads an iCalendar stream and returns its top-level components
+// (normally a single VCALENDAR).
+func Parse(r io.Reader) ([]*Component, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var roots []*Component
+	var stack []*Component
+	for n, line := range lines {
+		prop, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n+1, err)
+		}
+
+		switch prop.Name {
+		case "BEGIN":
+			stack = append(stack, &Component{Name: strings.ToUpper(prop.Value)})
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(prop.Value) {
+				return nil, fmt.Errorf("line %d: unexpected END:%s", n+1, prop.Value)
+			}
+			done := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				roots = append(roots, done)
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, done)
+			}
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: property %s outside of a component", n+1, prop.Name)
+			}
+			current := stack[len(stack)-1]
+			current.Properties = append(current.Properties, prop)
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("unterminated component %s", stack[len(stack)-1].Name)
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no calendar data found")
+	}
+	return roots, nil
+}
+
+// unfold joins folded continuation lines and drops blank lines.
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			if len(lines) == 0 {
+				return nil, fmt.Errorf("continuation line without a preceding line")
+			}
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read calendar data: %v", err)
+	}
+	return lines, nil
+}
+
+// parseLine splits a content line into its name, parameters and value,
+// honouring quoted parameter values that may contain ':' or ';'.
+func parseLine(line string) (Property, error) {
+	prop := Property{Params: map[string]string{}}
+
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return prop, fmt.Errorf("malformed content line %q", line)
+	}
+	prop.Name = strings.ToUpper(line[:i])
+
+	for line[i] == ';' {
+		rest := line[i+1:]
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return prop, fmt.Errorf("malformed parameter in %q", line)
+		}
+		key := strings.ToUpper(rest[:eq])
+		rest = rest[eq+1:]
+
+		var value string
+		var consumed int
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return prop, fmt.Errorf("unterminated quoted parameter in %q", line)
+			}
+			value = rest[1 : end+1]
+			consumed = end + 2
+		} else {
+			end := strings.IndexAny(rest, ";:")
+			if end < 0 {
+				return prop, fmt.Errorf("missing value in %q", line)
+			}
+			value = rest[:end]
+			consumed = end
+		}
+		prop.Params[key] = value
+		i = len(line) - len(rest) + consumed
+		if i >= len(line) {
+			return prop, fmt.Errorf("missing value in %q", line)
+		}
+	}
+
+	if line[i] != ':' {
+		return prop, fmt.Errorf("malformed content line %q", line)
+	}
+	prop.Value = line[i+1:]
+	return prop, nil
+}
+
+// Events extracts every VEVENT from the parsed components. VEVENTs that can't
+// be mapped are reported through the returned errors without aborting the rest.
+func Events(roots []*Component) ([]models.Event, []error) {
+	var events []models.Event
+	var errs []error
+	for _, root := range roots {
+		for _, child := range root.Children {
+			if child.Name != "VEVENT" {
+				continue
+			}
+			event, err := ToEvent(child)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			events = append(events, *event)
+		}
+	}
+	return events, errs
+}
+
+// ToEvent maps a VEVENT onto a models.Event. The event ID is the UID, or for
+// overrides (VEVENTs with a RECURRENCE-ID) a UUID derived from the UID and the
+// recurrence ID, so that re-importing the same data targets the same rows.
+func ToEvent(vevent *Component) (*models.Event, error) {
+	uidProp := vevent.Get("UID")
+	if uidProp == nil || strings.TrimSpace(uidProp.Value) == "" {
+		return nil, fmt.Errorf("VEVENT %q has no UID", summary(vevent))
+	}
+	uid := strings.TrimSpace(uidProp.Value)
+
+	event := &models.Event{ID: uid}
+
+	dtstart := vevent.Get("DTSTART")
+	if dtstart == nil {
+		return nil, fmt.Errorf("VEVENT %s has no DTSTART", uid)
+	}
+	start, err := ParseDateTime(dtstart)
+	if err != nil {
+		return nil, fmt.Errorf("VEVENT %s: invalid DTSTART: %v", uid, err)
+	}
+	event.Start = start
+	value := strings.TrimSpace(dtstart.Value)
+	switch {
+	case dtstart.Params["VALUE"] == "DATE" || len(value) == len("20060102"):
+		event.AllDay = true
+	case dtstart.Params["TZID"] != "":
+		event.TimeZone = strings.TrimPrefix(dtstart.Params["TZID"], "/")
+	case !strings.HasSuffix(value, "Z"):
+		event.Floating = true
+	}
+
+	switch {
+	case vevent.Get("DTEND") != nil:
+		end, err := ParseDateTime(vevent.Get("DTEND"))
+		if err != nil {
+			return nil, fmt.Errorf("VEVENT %s: invalid DTEND: %v", uid, err)
+		}
+		event.End = end
+	case vevent.Get("DURATION") != nil:
+		duration, err := parseDuration(vevent.Get("DURATION").Value)
+		if err != nil {
+			return nil, fmt.Errorf("VEVENT %s: invalid DURATION: %v", uid, err)
+		}
+		event.End = start.Add(duration)
+	case event.AllDay:
+		event.End = start.AddDate(0, 0, 1)
+	default:
+		event.End = start
+	}
+	if event.End.Before(event.Start) {
+		return nil, fmt.Errorf("VEVENT %s ends before it starts", uid)
+	}
+
+	if prop := vevent.Get("SUMMARY"); prop != nil {
+		event.Title = unescapeText(prop.Value)
+	}
+	if prop := vevent.Get("DESCRIPTION"); prop != nil {
+		event.Description = unescapeText(prop.Value)
+	}
+	if prop := vevent.Get("X-EVENT-COLOR"); prop != nil {
+		event.Color = unescapeText(prop.Value)
+	}
+	if prop := vevent.Get("TRANSP"); prop != nil {
+		event.Transparent = strings.EqualFold(strings.TrimSpace(prop.Value), "TRANSPARENT")
+	}
+	if prop := vevent.Get("RRULE"); prop != nil {
+		event.RRule = prop.Value
+	}
+	readAttendees(vevent, event)
+	for _, prop := range vevent.GetAll("EXDATE") {
+		for _, value := range strings.Split(prop.Value, ",") {
+			exdate, err := ParseDateTime(&Property{Params: prop.Params, Value: value})
+			if err != nil {
+				return nil, fmt.Errorf("VEVENT %s: invalid EXDATE: %v", uid, err)
+			}
+			event.ExDates = append(event.ExDates, exdate)
+		}
+	}
+	if prop := vevent.Get("RECURRENCE-ID"); prop != nil {
+		recurrenceID, err := ParseDateTime(prop)
+		if err != nil {
+			return nil, fmt.Errorf("VEVENT %s: invalid RECURRENCE-ID: %v", uid, err)
+		}
+		event.ID = uuid.NewSHA1(uuid.NameSpaceURL, []byte(uid+"/"+formatUTC(recurrenceID))).String()
+		event.RecurringEventID = uid
+		event.RecurrenceID = &recurrenceID
+	}
+	return event, nil
+}
+
+// ParseDateTime parses DATE-TIME and DATE values. UTC ("Z") and
+// TZID-qualified times are converted to UTC; floating times are taken as UTC.
+func ParseDateTime(prop *Property) (time.Time, error) {
+	value := strings.TrimSpace(prop.Value)
+	if prop.Params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		return time.Parse("20060102", value)
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse(utcLayout, value)
+	}
+
+	loc := time.UTC
+	if tzid := prop.Params["TZID"]; tzid != "" {
+		var err error
+		if loc, err = time.LoadLocation(strings.TrimPrefix(tzid, "/")); err != nil {
+			return time.Time{}, fmt.Errorf("unknown TZID %q", tzid)
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
+
+// parseDuration parses RFC 5545 durations such as "PT1H30M" or "P1D".
+func parseDuration(value string) (time.Duration, error) {
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(value, "-"):
+		sign = -1
+		value = value[1:]
+	case strings.HasPrefix(value, "+"):
+		value = value[1:]
+	}
+	if !strings.HasPrefix(value, "P") || len(value) < 3 {
+		return 0, fmt.Errorf("malformed duration %q", value)
+	}
+
+	var total time.Duration
+	inTime := false
+	number := 0
+	digits := 0
+	for _, r := range value[1:] {
+		switch {
+		case r >= '0' && r <= '9':
+			number = number*10 + int(r-'0')
+			digits++
+			continue
+		case r == 'T':
+			inTime = true
+			continue
+		}
+		if digits == 0 {
+			return 0, fmt.Errorf("malformed duration %q", value)
+		}
+		unit := map[rune]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour}[r]
+		if inTime {
+			unit = map[rune]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second}[r]
+		}
+		if unit == 0 {
+			return 0, fmt.Errorf("malformed duration %q", value)
+		}
+		total += time.Duration(number) * unit
+		number, digits = 0, 0
+	}
+	if digits != 0 {
+		return 0, fmt.Errorf("malformed duration %q", value)
+	}
+	return sign * total, nil
+}
+
+func unescapeText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func summary(vevent *Component) string {
+	if prop := vevent.Get("SUMMARY"); prop != nil {
+		return unescapeText(prop.Value)
+	}
+	return ""
+}
@@ -0,0 +1,247 @@
+package ical
+
+import (
+	"bytes"
+	"calendar-backend/internal/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	productID   = "-//calendar-bot//Calendar Bot//EN"
+	utcLayout   = "20060102T150405Z"
+	maxLineSize = 75
+)
+
+// iTIP methods (RFC 5546): how a calendar is published, or which
+// scheduling message it is in emails exchanged with attendees.
+const (
+	MethodPublish = "PUBLISH"
+	MethodRequest = "REQUEST"
+	MethodReply   = "REPLY"
+	MethodCancel  = "CANCEL"
+)
+
+// Calendar is a VCALENDAR object to be serialised.
+type Calendar struct {
+	Name string
+	// Method is the iTIP method (e.g. MethodPublish); it is omitted when empty.
+	// CANCEL marks the events as cancelled.
+	Method string
+	// RefreshInterval hints subscribing clients how often to poll the feed.
+	RefreshInterval time.Duration
+	Events          []models.Event
+	// Reminders maps calendar IDs to the default reminder of their events,
+	// which is added to them as a VALARM.
+	Reminders map[string]time.Duration
+}
+
+// Encode serialises the calendar as an RFC 5545 iCalendar stream. Recurring
+// events are written as a single VEVENT carrying the RRULE and EXDATEs, and
+// override rows as VEVENTs with the series' UID and a RECURRENCE-ID.
+func Encode(cal Calendar) []byte {
+	w := &writer{}
+	w.line("BEGIN", nil, "VCALENDAR")
+	w.line("VERSION", nil, "2.0")
+	w.line("PRODID", nil, productID)
+	w.line("CALSCALE", nil, "GREGORIAN")
+	if cal.Method != "" {
+		w.line("METHOD", nil, cal.Method)
+	}
+	if cal.Name != "" {
+		w.line("X-WR-CALNAME", nil, escapeText(cal.Name))
+	}
+	if cal.RefreshInterval > 0 {
+		interval := formatDuration(cal.RefreshInterval)
+		w.line("REFRESH-INTERVAL", []string{"VALUE=DURATION"}, interval)
+		w.line("X-PUBLISHED-TTL", nil, interval)
+	}
+	writeTimezones(w, cal.Events)
+	for i := range cal.Events {
+		writeEvent(w, &cal.Events[i], cal.Method, cal.Reminders)
+	}
+	w.line("END", nil, "VCALENDAR")
+	return w.buf.Bytes()
+}
+
+func writeEvent(w *writer, event *models.Event, method string, reminders map[string]time.Duration) {
+	uid := event.ID
+	if event.IsOverride() {
+		uid = event.RecurringEventID
+	}
+
+	w.line("BEGIN", nil, "VEVENT")
+	w.line("UID", nil, uid)
+	w.line("DTSTAMP", nil, formatUTC(event.UpdatedAt))
+	params := timeParams(event)
+	w.line("DTSTART", params, formatTime(event, event.Start))
+	w.line("DTEND", params, formatTime(event, event.End))
+	if event.IsOverride() && event.RecurrenceID != nil {
+		w.line("RECURRENCE-ID", params, formatTime(event, *event.RecurrenceID))
+	}
+	if !event.CreatedAt.IsZero() {
+		w.line("CREATED", nil, formatUTC(event.CreatedAt))
+	}
+	if !event.UpdatedAt.IsZero() {
+		w.line("LAST-MODIFIED", nil, formatUTC(event.UpdatedAt))
+	}
+	w.line("SUMMARY", nil, escapeText(event.Title))
+	if event.Description != "" {
+		w.line("DESCRIPTION", nil, escapeText(event.Description))
+	}
+	if event.Color != "" {
+		w.line("X-EVENT-COLOR", nil, escapeText(event.Color))
+	}
+	if event.Transparent {
+		w.line("TRANSP", nil, "TRANSPARENT")
+	}
+	if method == MethodCancel {
+		w.line("STATUS", nil, "CANCELLED")
+	}
+	writeAttendees(w, event)
+	if event.IsRecurring() {
+		w.line("RRULE", nil, event.RRule)
+		if len(event.ExDates) > 0 {
+			exdates := make([]string, len(event.ExDates))
+			for i, exdate := range event.ExDates {
+				exdates[i] = formatTime(event, exdate)
+			}
+			w.line("EXDATE", params, strings.Join(exdates, ","))
+		}
+	}
+	if reminder, ok := reminders[event.CalendarID]; ok {
+		writeAlarm(w, event, reminder)
+	}
+	w.line("END", nil, "VEVENT")
+}
+
+// writeAlarm adds a display alarm going off before the start of the event.
+func writeAlarm(w *writer, event *models.Event, before time.Duration) {
+	trigger := formatDuration(before)
+	if before > 0 {
+		trigger = "-" + trigger
+	}
+	w.line("BEGIN", nil, "VALARM")
+	w.line("ACTION", nil, "DISPLAY")
+	w.line("DESCRIPTION", nil, escapeText(event.Title))
+	w.line("TRIGGER", nil, trigger)
+	w.line("END", nil, "VALARM")
+}
+
+func formatUTC(t time.Time) string {
+	return t.UTC().Format(utcLayout)
+}
+
+// timeParams returns the parameters of the event's DATE-TIME properties.
+func timeParams(event *models.Event) []string {
+	switch {
+	case event.AllDay:
+		return []string{"VALUE=DATE"}
+	case event.Floating:
+		return nil
+	case event.TimeZone != "":
+		return []string{"TZID=" + event.TimeZone}
+	default:
+		return nil
+	}
+}
+
+// formatTime renders one of the event's times to go with timeParams: a DATE
+// for all-day events, a floating or TZID-local time, or UTC.
+func formatTime(event *models.Event, t time.Time) string {
+	switch {
+	case event.AllDay:
+		return t.UTC().Format("20060102")
+	case event.Floating:
+		return t.UTC().Format(localLayout)
+	case event.TimeZone != "":
+		return t.In(event.Location()).Format(localLayout)
+	default:
+		return formatUTC(t)
+	}
+}
+
+// writeTimezones emits a VTIMEZONE for every zone the events refer to, based
+// on the rules in force in the year of the earliest event using the zone.
+func writeTimezones(w *writer, events []models.Event) {
+	years := map[string]int{}
+	var zones []string
+	for i := range events {
+		event := &events[i]
+		if event.TimeZone == "" || event.AllDay || event.Floating {
+			continue
+		}
+		year, seen := years[event.TimeZone]
+		if !seen {
+			zones = append(zones, event.TimeZone)
+		}
+		if !seen || event.Start.Year() < year {
+			years[event.TimeZone] = event.Start.Year()
+		}
+	}
+	sort.Strings(zones)
+	for _, zone := range zones {
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			continue
+		}
+		writeTimezone(w, loc, years[zone])
+	}
+}
+
+// formatDuration renders whole minutes as an RFC 5545 duration such as "PT1H".
+func formatDuration(d time.Duration) string {
+	minutes := int(d / time.Minute)
+	var b strings.Builder
+	b.WriteString("PT")
+	if h := minutes / 60; h > 0 {
+		b.WriteString(strconv.Itoa(h) + "H")
+	}
+	if m := minutes % 60; m > 0 || minutes == 0 {
+		b.WriteString(strconv.Itoa(m) + "M")
+	}
+	return b.String()
+}
+
+// escapeText escapes a TEXT value as described in RFC 5545 section 3.3.11.
+func escapeText(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(s)
+}
+
+// writer emits content lines folded at 75 octets with CRLF line endings.
+type writer struct {
+	buf bytes.Buffer
+}
+
+func (w *writer) line(name string, params []string, value string) {
+	line := name
+	for _, param := range params {
+		line += ";" + param
+	}
+	line += ":" + value
+
+	// Continuation lines lose one octet to the leading space.
+	limit := maxLineSize
+	for len(line) > limit {
+		cut := limit
+		// Never split a multi-byte UTF-8 sequence across lines.
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.buf.WriteString(line[:cut])
+		w.buf.WriteString("\r\n ")
+		line = line[cut:]
+		limit = maxLineSize - 1
+	}
+	w.buf.WriteString(line)
+	w.buf.WriteString("\r\n")
+}
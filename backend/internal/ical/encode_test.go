@@ -0,0 +1,267 @@
+package ical
+
+import (
+	"bytes"
+	"calendar-backend/internal/models"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func date(year int, month time.Month, day, hour, min int) time.Time {
+	return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	updated := date(2026, 10, 1, 8, 30)
+	recurrenceID := date(2026, 10, 21, 7, 0)
+	tests := []struct {
+		name   string
+		events []models.Event
+	}{
+		{
+			name: "utc",
+			events: []models.Event{{
+				ID: "utc@example.com", Title: "Planning", Start: date(2026, 10, 20, 13, 0), End: date(2026, 10, 20, 14, 0),
+				Color: "#3b82f6", Transparent: true,
+			}},
+		},
+		{
+			name: "all-day",
+			events: []models.Event{{
+				ID: "all-day@example.com", Title: "Offsite", Start: date(2026, 10, 20, 0, 0), End: date(2026, 10, 23, 0, 0),
+				AllDay: true,
+			}},
+		},
+		{
+			name: "floating",
+			events: []models.Event{{
+				ID: "floating@example.com", Title: "Lunch", Start: date(2026, 10, 20, 12, 0), End: date(2026, 10, 20, 13, 0),
+				Floating: true,
+			}},
+		},
+		{
+			name: "tzid",
+			events: []models.Event{{
+				// 09:00 in New York, still on daylight saving time.
+				ID: "tzid@example.com", Title: "Standup", Start: date(2026, 10, 20, 13, 0), End: date(2026, 10, 20, 13, 15),
+				TimeZone: "America/New_York",
+			}},
+		},
+		{
+			name: "recurring",
+			events: []models.Event{
+				{
+					ID: "series@example.com", Title: "Team sync", Start: date(2026, 10, 19, 7, 0), End: date(2026, 10, 19, 8, 0),
+					TimeZone: "Europe/Paris", RRule: "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10",
+					ExDates:   []time.Time{date(2026, 10, 26, 8, 0), date(2026, 11, 2, 8, 0)},
+					Organizer: "alex@example.com",
+					Attendees: []models.Attendee{
+						{Email: "sam@example.com", Name: "Sam, Jr.", Role: models.AttendeeRequired, Status: models.PartStatAccepted},
+						{Email: "kim@example.com", Role: models.AttendeeOptional, Status: models.PartStatNeedsAction},
+					},
+				},
+				{
+					ID: "override", Title: "Team sync (moved)", Start: date(2026, 10, 21, 9, 0), End: date(2026, 10, 21, 10, 0),
+					TimeZone: "Europe/Paris", RecurringEventID: "series@example.com", RecurrenceID: &recurrenceID,
+				},
+			},
+		},
+		{
+			name: "escaped and folded text",
+			events: []models.Event{{
+				ID:    "text@example.com",
+				Title: `Budget; Q3, Q4 \ review`,
+				Description: "Agenda:\n1. Numbers, forecasts; risks\n2. Überprüfung der Kostenstellen für das nächste Geschäftsjahr 🎯 " +
+					strings.Repeat("and a very long line that has to be folded ", 4),
+				Start: date(2026, 10, 20, 13, 0), End: date(2026, 10, 20, 14, 0),
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i := range test.events {
+				test.events[i].CreatedAt, test.events[i].UpdatedAt = updated, updated
+			}
+			data := Encode(Calendar{Name: "Work", Events: test.events})
+			roots := parseStrict(t, data)
+
+			decoded, errs := Events(roots)
+			if len(errs) > 0 {
+				t.Fatalf("failed to decode events: %v", errs)
+			}
+			if len(decoded) != len(test.events) {
+				t.Fatalf("got %d events, want %d", len(decoded), len(test.events))
+			}
+			for i, got := range decoded {
+				assertSameEvent(t, &got, &test.events[i])
+			}
+			for _, vevent := range roots[0].Children {
+				if vevent.Name == "VEVENT" {
+					if got := vevent.Get("DTSTAMP").Value; got != formatUTC(updated) {
+						t.Errorf("DTSTAMP = %s, want %s", got, formatUTC(updated))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeTimeProperties(t *testing.T) {
+	events := []models.Event{
+		{ID: "utc", Start: date(2026, 10, 20, 13, 0), End: date(2026, 10, 20, 14, 0)},
+		{ID: "all-day", Start: date(2026, 10, 20, 0, 0), End: date(2026, 10, 21, 0, 0), AllDay: true},
+		{ID: "floating", Start: date(2026, 10, 20, 12, 0), End: date(2026, 10, 20, 13, 0), Floating: true},
+		{ID: "tzid", Start: date(2026, 10, 20, 13, 0), End: date(2026, 10, 20, 14, 0), TimeZone: "America/New_York"},
+	}
+	want := map[string]string{
+		"utc":      "DTSTART:20261020T130000Z",
+		"all-day":  "DTSTART;VALUE=DATE:20261020",
+		"floating": "DTSTART:20261020T120000",
+		"tzid":     "DTSTART;TZID=America/New_York:20261020T090000",
+	}
+	data := Encode(Calendar{Events: events})
+	parseStrict(t, data)
+	for id, line := range want {
+		if !bytes.Contains(data, []byte("UID:"+id+"\r\nDTSTAMP:00010101T000000Z\r\n"+line+"\r\n")) {
+			t.Errorf("event %s has no %q line:\n%s", id, line, data)
+		}
+	}
+}
+
+// textProperties are the properties of a VEVENT written as TEXT values.
+var textProperties = []string{"SUMMARY", "DESCRIPTION", "X-EVENT-COLOR"}
+
+// parseStrict checks that data follows the rules of RFC 5545 that Parse is
+// lenient about, then parses it: CRLF line endings, lines of at most 75
+// octets folded on UTF-8 character boundaries, a single VCALENDAR with
+// VERSION and PRODID, the required properties of VEVENTs, escaped TEXT
+// values and a VTIMEZONE for every TZID.
+func parseStrict(t *testing.T, data []byte) []*Component {
+	t.Helper()
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		t.Fatalf("calendar doesn't end with CRLF")
+	}
+	for n, line := range strings.Split(strings.TrimSuffix(string(data), "\r\n"), "\r\n") {
+		switch {
+		case line == "":
+			t.Fatalf("line %d is empty", n+1)
+		case strings.ContainsAny(line, "\r\n"):
+			t.Fatalf("line %d has a bare CR or LF: %q", n+1, line)
+		case len(line) > 75:
+			t.Fatalf("line %d is %d octets long: %q", n+1, len(line), line)
+		case !utf8.ValidString(line):
+			t.Fatalf("line %d isn't valid UTF-8: %q", n+1, line)
+		}
+	}
+
+	roots, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to parse calendar: %v\n%s", err, data)
+	}
+	if len(roots) != 1 || roots[0].Name != "VCALENDAR" {
+		t.Fatalf("want a single VCALENDAR, got %d components", len(roots))
+	}
+	calendar := roots[0]
+	if prop := calendar.Get("VERSION"); prop == nil || prop.Value != "2.0" {
+		t.Fatalf("VCALENDAR has no VERSION:2.0")
+	}
+	if calendar.Get("PRODID") == nil {
+		t.Fatalf("VCALENDAR has no PRODID")
+	}
+
+	zones := map[string]bool{}
+	for _, child := range calendar.Children {
+		if child.Name != "VTIMEZONE" {
+			continue
+		}
+		if len(child.Children) == 0 {
+			t.Fatalf("VTIMEZONE %s has no STANDARD or DAYLIGHT", child.Get("TZID").Value)
+		}
+		for _, observance := range child.Children {
+			for _, name := range []string{"DTSTART", "TZOFFSETFROM", "TZOFFSETTO"} {
+				if observance.Get(name) == nil {
+					t.Fatalf("%s of VTIMEZONE %s has no %s", observance.Name, child.Get("TZID").Value, name)
+				}
+			}
+		}
+		zones[child.Get("TZID").Value] = true
+	}
+	for _, child := range calendar.Children {
+		if child.Name != "VEVENT" {
+			continue
+		}
+		for _, name := range []string{"UID", "DTSTAMP", "DTSTART"} {
+			if len(child.GetAll(name)) != 1 {
+				t.Fatalf("VEVENT has %d %s properties, want 1", len(child.GetAll(name)), name)
+			}
+		}
+		for _, name := range textProperties {
+			if prop := child.Get(name); prop != nil && !escaped(prop.Value) {
+				t.Fatalf("%s has an unescaped special character: %q", name, prop.Value)
+			}
+		}
+		for _, prop := range child.Properties {
+			if tzid := prop.Params["TZID"]; tzid != "" && !zones[tzid] {
+				t.Fatalf("%s refers to TZID %s without a VTIMEZONE", prop.Name, tzid)
+			}
+		}
+	}
+	return roots
+}
+
+// escaped reports whether every semicolon and comma of a TEXT value is
+// escaped and every backslash starts a valid escape.
+func escaped(value string) bool {
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case ';', ',':
+			return false
+		case '\\':
+			i++
+			if i == len(value) || !strings.ContainsRune(`\;,nN`, rune(value[i])) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// assertSameEvent compares the fields of an event that are written to
+// iCalendar. Overrides are given an ID derived from their series and
+// recurrence ID when read, so their IDs aren't compared.
+func assertSameEvent(t *testing.T, got, want *models.Event) {
+	t.Helper()
+	if !want.IsOverride() && got.ID != want.ID {
+		t.Errorf("ID = %q, want %q", got.ID, want.ID)
+	}
+	fields := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"Title", got.Title, want.Title},
+		{"Description", got.Description, want.Description},
+		{"Color", got.Color, want.Color},
+		{"TimeZone", got.TimeZone, want.TimeZone},
+		{"AllDay", got.AllDay, want.AllDay},
+		{"Floating", got.Floating, want.Floating},
+		{"Transparent", got.Transparent, want.Transparent},
+		{"RRule", got.RRule, want.RRule},
+		{"ExDates", got.ExDates, want.ExDates},
+		{"RecurringEventID", got.RecurringEventID, want.RecurringEventID},
+		{"RecurrenceID", got.RecurrenceID, want.RecurrenceID},
+		{"Organizer", got.Organizer, want.Organizer},
+		{"Attendees", got.Attendees, want.Attendees},
+	}
+	for _, field := range fields {
+		if !reflect.DeepEqual(field.got, field.want) {
+			t.Errorf("%s %s = %#v, want %#v", want.ID, field.name, field.got, field.want)
+		}
+	}
+	if !got.Start.Equal(want.Start) || !got.End.Equal(want.End) {
+		t.Errorf("%s runs %s - %s, want %s - %s", want.ID, got.Start, got.End, want.Start, want.End)
+	}
+}
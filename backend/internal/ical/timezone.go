@@ -0,0 +1,108 @@
+package ical
+
+import (
+	"fmt"
+	"time"
+)
+
+const localLayout = "20060102T150405"
+
+// transition is a change of UTC offset in a zone.
+type transition struct {
+	at         time.Time
+	fromOffset int
+	toOffset   int
+	name       string
+	dst        bool
+}
+
+// writeTimezone emits a VTIMEZONE for loc. Go doesn't expose a zone's rules,
+// so the transitions of the given year are looked up and repeated yearly
+// (e.g. "second Sunday of March"), which is how the rules of current zones
+// are shaped.
+func writeTimezone(w *writer, loc *time.Location, year int) {
+	w.line("BEGIN", nil, "VTIMEZONE")
+	w.line("TZID", nil, loc.String())
+
+	transitions := yearTransitions(loc, year)
+	if len(transitions) == 0 {
+		name, offset := time.Date(year, 1, 1, 0, 0, 0, 0, loc).Zone()
+		w.line("BEGIN", nil, "STANDARD")
+		w.line("DTSTART", nil, "19700101T000000")
+		w.line("TZOFFSETFROM", nil, formatOffset(offset))
+		w.line("TZOFFSETTO", nil, formatOffset(offset))
+		w.line("TZNAME", nil, name)
+		w.line("END", nil, "STANDARD")
+	}
+	for _, t := range transitions {
+		kind := "STANDARD"
+		if t.dst {
+			kind = "DAYLIGHT"
+		}
+		// DTSTART is the wall clock just before the change, in the old offset.
+		local := t.at.UTC().Add(time.Duration(t.fromOffset) * time.Second)
+		w.line("BEGIN", nil, kind)
+		w.line("DTSTART", nil, local.Format(localLayout))
+		w.line("RRULE", nil, yearlyRule(local))
+		w.line("TZOFFSETFROM", nil, formatOffset(t.fromOffset))
+		w.line("TZOFFSETTO", nil, formatOffset(t.toOffset))
+		w.line("TZNAME", nil, t.name)
+		w.line("END", nil, kind)
+	}
+	w.line("END", nil, "VTIMEZONE")
+}
+
+// yearTransitions finds the offset changes of loc during the given year.
+func yearTransitions(loc *time.Location, year int) []transition {
+	var transitions []transition
+	start := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(1, 0, 0)
+	for day := start; day.Before(end); day = day.Add(24 * time.Hour) {
+		next := day.Add(24 * time.Hour)
+		_, before := day.In(loc).Zone()
+		_, after := next.In(loc).Zone()
+		if before == after {
+			continue
+		}
+		// Narrow the change down to the second.
+		lo, hi := day, next
+		for hi.Sub(lo) > time.Second {
+			mid := lo.Add(hi.Sub(lo) / 2)
+			if _, offset := mid.In(loc).Zone(); offset == before {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		name, _ := hi.In(loc).Zone()
+		transitions = append(transitions, transition{
+			at:         hi,
+			fromOffset: before,
+			toOffset:   after,
+			name:       name,
+			dst:        hi.In(loc).IsDST(),
+		})
+	}
+	return transitions
+}
+
+// yearlyRule describes the date of t as a weekday of its month, counting
+// from the end of the month for the last week.
+func yearlyRule(t time.Time) string {
+	days := [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+	ordinal := (t.Day()-1)/7 + 1
+	if t.AddDate(0, 0, 7).Month() != t.Month() {
+		ordinal = -1
+	}
+	return fmt.Sprintf("FREQ=YEARLY;BYMONTH=%d;BYDAY=%d%s", int(t.Month()), ordinal, days[t.Weekday()])
+}
+
+// formatOffset renders a UTC offset in seconds as e.g. "+0100" or "-0530".
+func formatOffset(seconds int) string {
+	sign := '+'
+	if seconds < 0 {
+		sign = '-'
+		seconds = -seconds
+	}
+	return fmt.Sprintf("%c%02d%02d", sign, seconds/3600, seconds%3600/60)
+}
@@ -0,0 +1,153 @@
+// Package imip exchanges scheduling messages with attendees by email, as
+// described by iMIP (RFC 6047): invitations and cancellations are sent
+// through an SMTP server, and replies are read from a mailbox directory.
+package imip
+
+import (
+	"bytes"
+	"calendar-backend/internal/ical"
+	"calendar-backend/internal/models"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Describe returns the subject and plain text body of a message with the
+// given method about event, sent on behalf of its organizer. Updated tells
+// invitations to events the attendees already know about from new ones.
+func Describe(method string, event *models.Event, updated bool) (string, string) {
+	var subject, intro string
+	switch {
+	case method == ical.MethodCancel:
+		subject, intro = "Cancelled: ", "%s has cancelled this event:"
+	case updated:
+		subject, intro = "Updated invitation: ", "%s has updated this event:"
+	default:
+		subject, intro = "Invitation: ", "%s has invited you to this event:"
+	}
+	subject += event.Title
+
+	var body strings.Builder
+	fmt.Fprintf(&body, intro+"\n\n", event.Organizer)
+	fmt.Fprintf(&body, "%s\n", event.Title)
+	fmt.Fprintf(&body, "When: %s\n", when(event))
+	if event.IsRecurring() {
+		fmt.Fprintf(&body, "Repeats: %s\n", event.RRule)
+	}
+	if len(event.Attendees) > 0 {
+		names := make([]string, len(event.Attendees))
+		for i, attendee := range event.Attendees {
+			names[i] = attendee.Email
+			if attendee.Name != "" {
+				names[i] = fmt.Sprintf("%s <%s>", attendee.Name, attendee.Email)
+			}
+		}
+		fmt.Fprintf(&body, "Attendees: %s\n", strings.Join(names, ", "))
+	}
+	if event.Description != "" {
+		fmt.Fprintf(&body, "\n%s\n", event.Description)
+	}
+	return subject, body.String()
+}
+
+// when describes when an event takes place, in its own time zone.
+func when(event *models.Event) string {
+	if event.AllDay {
+		first, last := event.Start.UTC(), event.End.UTC().AddDate(0, 0, -1)
+		if !last.After(first) {
+			return first.Format("Mon 2 Jan 2006")
+		}
+		return first.Format("Mon 2 Jan 2006") + " - " + last.Format("Mon 2 Jan 2006")
+	}
+	loc := event.Location()
+	if event.Floating {
+		loc = time.UTC
+	}
+	start, end := event.Start.In(loc), event.End.In(loc)
+	layout := "15:04"
+	if start.YearDay() != end.YearDay() || start.Year() != end.Year() {
+		layout = "Mon 2 Jan 2006 15:04"
+	}
+	text := start.Format("Mon 2 Jan 2006 15:04") + " - " + end.Format(layout)
+	if !event.Floating {
+		text += " (" + loc.String() + ")"
+	}
+	return text
+}
+
+// Message is an iMIP message to be sent.
+type Message struct {
+	// From is the address the message is sent from, and Organizer the
+	// organizer of the event, whom replies go to.
+	From      string
+	Organizer string
+	To        []string
+	Subject   string
+	Body      string
+	Method    string
+	Calendar  []byte
+}
+
+// Bytes renders the message as a MIME email: the text body followed by the
+// calendar object, once inline as text/calendar for clients that act on
+// invitations and once as an invite.ics attachment for the others.
+func (m *Message) Bytes() []byte {
+	mixed, alternative := boundary(), boundary()
+	var b bytes.Buffer
+	header := func(name, value string) { fmt.Fprintf(&b, "%s: %s\r\n", name, value) }
+	header("From", (&mail.Address{Name: m.Organizer, Address: m.From}).String())
+	if m.Organizer != "" && m.Organizer != m.From {
+		header("Reply-To", m.Organizer)
+	}
+	header("To", strings.Join(m.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", "<"+boundary()+"@"+domain(m.From)+">")
+	header("MIME-Version", "1.0")
+	header("Content-Type", `multipart/mixed; boundary="`+mixed+`"`)
+	b.WriteString("\r\n")
+
+	fmt.Fprintf(&b, "--%s\r\nContent-Type: multipart/alternative; boundary=\"%s\"\r\n\r\n", mixed, alternative)
+	fmt.Fprintf(&b, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n", alternative)
+	text := quotedprintable.NewWriter(&b)
+	text.Write([]byte(strings.ReplaceAll(m.Body, "\n", "\r\n")))
+	text.Close()
+	b.WriteString("\r\n")
+	fmt.Fprintf(&b, "--%s\r\nContent-Type: text/calendar; charset=utf-8; method=%s\r\nContent-Transfer-Encoding: base64\r\n\r\n", alternative, m.Method)
+	writeBase64(&b, m.Calendar)
+	fmt.Fprintf(&b, "--%s--\r\n", alternative)
+
+	fmt.Fprintf(&b, "--%s\r\nContent-Type: application/ics; name=\"invite.ics\"\r\nContent-Disposition: attachment; filename=\"invite.ics\"\r\nContent-Transfer-Encoding: base64\r\n\r\n", mixed)
+	writeBase64(&b, m.Calendar)
+	fmt.Fprintf(&b, "--%s--\r\n", mixed)
+	return b.Bytes()
+}
+
+// writeBase64 writes data base64 encoded in lines of 76 characters.
+func writeBase64(b *bytes.Buffer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+}
+
+func boundary() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func domain(address string) string {
+	if _, domain, ok := strings.Cut(address, "@"); ok {
+		return domain
+	}
+	return "localhost"
+}
@@ -0,0 +1,197 @@
+package imip
+
+import (
+	"bytes"
+	"calendar-backend/internal/ical"
+	"calendar-backend/internal/models"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNoReply is returned for messages that carry no iTIP REPLY.
+var ErrNoReply = errors.New("message carries no calendar reply")
+
+// Response is an attendee's answer to an invitation, for a whole event or,
+// with a RecurrenceID, a single occurrence of a recurring one.
+type Response struct {
+	UID          string
+	RecurrenceID *time.Time
+	Email        string
+	Status       models.PartStat
+}
+
+// ParseReply reads an email carrying an iTIP REPLY and returns the responses
+// in it. Only the responses of the sender count, so that a reply can't
+// answer for other attendees.
+func ParseReply(r io.Reader) ([]Response, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message: %v", err)
+	}
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid From header: %v", err)
+	}
+	data, err := findCalendar(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := ical.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid calendar: %v", err)
+	}
+
+	sender := models.NormalizeEmail(from.Address)
+	var responses []Response
+	for _, root := range roots {
+		if method := root.Get("METHOD"); method == nil || !strings.EqualFold(strings.TrimSpace(method.Value), ical.MethodReply) {
+			continue
+		}
+		for _, vevent := range root.Children {
+			if vevent.Name != "VEVENT" {
+				continue
+			}
+			response, err := readResponse(vevent, sender)
+			if err != nil {
+				return nil, err
+			}
+			responses = append(responses, *response)
+		}
+	}
+	if len(responses) == 0 {
+		return nil, ErrNoReply
+	}
+	return responses, nil
+}
+
+// readResponse reads the response of sender from a VEVENT of a REPLY.
+func readResponse(vevent *ical.Component, sender string) (*Response, error) {
+	uid := vevent.Get("UID")
+	if uid == nil || strings.TrimSpace(uid.Value) == "" {
+		return nil, fmt.Errorf("reply has no UID")
+	}
+	response := &Response{UID: strings.TrimSpace(uid.Value), Email: sender}
+	if prop := vevent.Get("RECURRENCE-ID"); prop != nil {
+		recurrenceID, err := ical.ParseDateTime(prop)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RECURRENCE-ID: %v", err)
+		}
+		response.RecurrenceID = &recurrenceID
+	}
+	for _, prop := range vevent.GetAll("ATTENDEE") {
+		if ical.CalAddress(prop.Value) != sender {
+			continue
+		}
+		response.Status = models.PartStat(strings.ToLower(prop.Params["PARTSTAT"]))
+		if !models.IsPartStat(response.Status) {
+			return nil, fmt.Errorf("unsupported PARTSTAT %q", prop.Params["PARTSTAT"])
+		}
+		return response, nil
+	}
+	return nil, fmt.Errorf("reply to %s has no ATTENDEE for its sender %s", response.UID, sender)
+}
+
+// findCalendar returns the decoded content of the first text/calendar or
+// application/ics part of a message body with the given content type.
+func findCalendar(contentType, encoding string, body io.Reader) ([]byte, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil, ErrNoReply
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid multipart message: %v", err)
+			}
+			data, err := findCalendar(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if !errors.Is(err, ErrNoReply) {
+				return data, err
+			}
+		}
+	case mediaType == "text/calendar" || mediaType == "application/ics":
+		switch strings.ToLower(strings.TrimSpace(encoding)) {
+		case "base64":
+			body = base64.NewDecoder(base64.StdEncoding, body)
+		case "quoted-printable":
+			body = quotedprintable.NewReader(body)
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid calendar part: %v", err)
+		}
+		return data, nil
+	default:
+		return nil, ErrNoReply
+	}
+}
+
+// settleTime is how long a message must have been left alone before it is
+// read, so that messages still being written are picked up next time.
+const settleTime = 2 * time.Second
+
+// ProcessInbox applies the replies of the messages in dir, one message per
+// file, and moves each message to the processed or failed subdirectory once
+// it has been read. Messages are best moved into dir once complete, as mail
+// delivery agents do with maildirs. It returns how many messages were
+// applied.
+func ProcessInbox(dir string, apply func([]Response) error) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read inbox: %v", err)
+	}
+	applied := 0
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if info, err := entry.Info(); err != nil || time.Since(info.ModTime()) < settleTime {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		err := processMessage(path, apply)
+		target := "processed"
+		if err != nil {
+			log.Printf("Failed to process inbox message %s: %v", entry.Name(), err)
+			target = "failed"
+		} else {
+			applied++
+		}
+		if err := os.MkdirAll(filepath.Join(dir, target), 0o755); err != nil {
+			return applied, fmt.Errorf("failed to create %s directory: %v", target, err)
+		}
+		if err := os.Rename(path, filepath.Join(dir, target, entry.Name())); err != nil {
+			return applied, fmt.Errorf("failed to move %s: %v", entry.Name(), err)
+		}
+	}
+	return applied, nil
+}
+
+func processMessage(path string, apply func([]Response) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	responses, err := ParseReply(file)
+	if err != nil {
+		return err
+	}
+	return apply(responses)
+}
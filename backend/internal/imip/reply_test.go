@@ -0,0 +1,153 @@
+package imip
+
+import (
+	"calendar-backend/internal/models"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// replyCalendar is a REPLY of sam to one occurrence of a series, which also
+// lists another attendee as clients sometimes do.
+const replyCalendar = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Example//Mail//EN\r\n" +
+	"METHOD:REPLY\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:series@example.com\r\n" +
+	"RECURRENCE-ID;TZID=Europe/Paris:20261021T090000\r\n" +
+	"DTSTAMP:20261017T090000Z\r\n" +
+	"DTSTART;TZID=Europe/Paris:20261021T090000\r\n" +
+	"ORGANIZER:mailto:alex@example.com\r\n" +
+	"ATTENDEE;PARTSTAT=DECLINED:mailto:kim@example.com\r\n" +
+	"ATTENDEE;PARTSTAT=ACCEPTED;CN=Sam:mailto:Sam@Example.com\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+// replyMessage returns an email from sender carrying calendar as a base64
+// text/calendar part next to a text part, the way mail clients send replies.
+func replyMessage(sender, calendar string) string {
+	encoded := base64.StdEncoding.EncodeToString([]byte(calendar))
+	var lines []string
+	for len(encoded) > 76 {
+		lines = append(lines, encoded[:76])
+		encoded = encoded[76:]
+	}
+	lines = append(lines, encoded)
+	return strings.Join([]string{
+		"From: " + sender,
+		"To: alex@example.com",
+		"Subject: Accepted: Team sync",
+		"MIME-Version: 1.0",
+		`Content-Type: multipart/mixed; boundary="outer"`,
+		"",
+		"--outer",
+		`Content-Type: multipart/alternative; boundary="inner"`,
+		"",
+		"--inner",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		"Sam has accepted this invitation.",
+		"--inner",
+		"Content-Type: text/calendar; charset=utf-8; method=REPLY",
+		"Content-Transfer-Encoding: base64",
+		"",
+		strings.Join(lines, "\r\n"),
+		"--inner--",
+		"--outer--",
+		"",
+	}, "\r\n")
+}
+
+func TestParseReply(t *testing.T) {
+	responses, err := ParseReply(strings.NewReader(replyMessage(`"Sam" <sam@example.com>`, replyCalendar)))
+	if err != nil {
+		t.Fatalf("failed to parse reply: %v", err)
+	}
+	if len(responses) != 1 {
+		t.Fatalf("got %d responses, want 1", len(responses))
+	}
+	response := responses[0]
+	// Only the sender's own ATTENDEE counts, not kim's.
+	if response.UID != "series@example.com" || response.Email != "sam@example.com" || response.Status != models.PartStatAccepted {
+		t.Errorf("response = %+v, want sam accepting series@example.com", response)
+	}
+	want := time.Date(2026, 10, 21, 7, 0, 0, 0, time.UTC)
+	if response.RecurrenceID == nil || !response.RecurrenceID.Equal(want) {
+		t.Errorf("RecurrenceID = %v, want %s", response.RecurrenceID, want)
+	}
+}
+
+func TestParseReplyPlainCalendar(t *testing.T) {
+	message := "From: sam@example.com\r\n" +
+		"Content-Type: text/calendar; method=REPLY\r\n" +
+		"\r\n" +
+		strings.Replace(replyCalendar, "RECURRENCE-ID;TZID=Europe/Paris:20261021T090000\r\n", "", 1)
+	responses, err := ParseReply(strings.NewReader(message))
+	if err != nil {
+		t.Fatalf("failed to parse reply: %v", err)
+	}
+	if len(responses) != 1 || responses[0].RecurrenceID != nil || responses[0].Status != models.PartStatAccepted {
+		t.Errorf("responses = %+v, want sam accepting the whole series", responses)
+	}
+}
+
+func TestParseReplyRejectsSpoofedAttendee(t *testing.T) {
+	_, err := ParseReply(strings.NewReader(replyMessage("mallory@example.com", replyCalendar)))
+	if err == nil || !strings.Contains(err.Error(), "no ATTENDEE for its sender mallory@example.com") {
+		t.Errorf("error = %v, want the reply rejected", err)
+	}
+}
+
+func TestParseReplyWithoutReply(t *testing.T) {
+	request := strings.Replace(replyCalendar, "METHOD:REPLY", "METHOD:REQUEST", 1)
+	tests := map[string]string{
+		"request":     replyMessage("sam@example.com", request),
+		"no calendar": "From: sam@example.com\r\nContent-Type: text/plain\r\n\r\nSee you there!\r\n",
+	}
+	for name, message := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseReply(strings.NewReader(message)); !errors.Is(err, ErrNoReply) {
+				t.Errorf("error = %v, want ErrNoReply", err)
+			}
+		})
+	}
+}
+
+func TestProcessInbox(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-time.Minute)
+	write := func(name, content string, modified time.Time) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("reply.eml", replyMessage("sam@example.com", replyCalendar), old)
+	write("spoofed.eml", replyMessage("mallory@example.com", replyCalendar), old)
+	write("writing.eml", replyMessage("sam@example.com", replyCalendar), time.Now())
+
+	var applied []Response
+	count, err := ProcessInbox(dir, func(responses []Response) error {
+		applied = append(applied, responses...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to process inbox: %v", err)
+	}
+	if count != 1 || len(applied) != 1 || applied[0].Email != "sam@example.com" {
+		t.Errorf("applied %d messages with %+v, want sam's reply", count, applied)
+	}
+	for _, path := range []string{"processed/reply.eml", "failed/spoofed.eml", "writing.eml"} {
+		if _, err := os.Stat(filepath.Join(dir, path)); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
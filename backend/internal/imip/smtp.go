@@ -0,0 +1,104 @@
+package imip
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config is the SMTP server messages are sent through.
+type Config struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// From is the address messages are sent from.
+	From string
+	// TLS connects with implicit TLS (usually port 465) rather than
+	// upgrading a plain connection with STARTTLS when the server offers it.
+	TLS bool
+}
+
+// ConfigFromEnv reads the SMTP server from SMTP_HOST, SMTP_PORT (default
+// 587), SMTP_USERNAME, SMTP_PASSWORD, SMTP_FROM and SMTP_TLS. It returns nil
+// if SMTP_HOST isn't set, which leaves sending invitations off.
+func ConfigFromEnv() (*Config, error) {
+	config := &Config{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     587,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+		TLS:      os.Getenv("SMTP_TLS") == "true",
+	}
+	if config.Host == "" {
+		return nil, nil
+	}
+	if port := os.Getenv("SMTP_PORT"); port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil || n <= 0 || n > 65535 {
+			return nil, fmt.Errorf("invalid SMTP_PORT %q", port)
+		}
+		config.Port = n
+	}
+	if config.From == "" {
+		return nil, fmt.Errorf("SMTP_FROM must be set along with SMTP_HOST")
+	}
+	return config, nil
+}
+
+// Send delivers a message to its recipients.
+func (c *Config) Send(m *Message) error {
+	address := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if c.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: c.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", address, err)
+	}
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to greet %s: %v", address, err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && !c.TLS {
+		if err := client.StartTLS(&tls.Config{ServerName: c.Host}); err != nil {
+			return fmt.Errorf("failed to start TLS: %v", err)
+		}
+	}
+	if c.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.Username, c.Password, c.Host)); err != nil {
+			return fmt.Errorf("failed to authenticate: %v", err)
+		}
+	}
+	if err := client.Mail(c.From); err != nil {
+		return fmt.Errorf("sender rejected: %v", err)
+	}
+	for _, to := range m.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s rejected: %v", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send message: %v", err)
+	}
+	if _, err := w.Write(m.Bytes()); err != nil {
+		return fmt.Errorf("failed to send message: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %v", err)
+	}
+	return client.Quit()
+}
@@ -0,0 +1,237 @@
+package imip
+
+import (
+	"bufio"
+	"bytes"
+	"calendar-backend/internal/ical"
+	"calendar-backend/internal/models"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSink is a minimal SMTP server that accepts every message and keeps
+// the envelope and data of the last one.
+type smtpSink struct {
+	listener net.Listener
+	done     chan struct{}
+	from     string
+	to       []string
+	data     []byte
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	sink := &smtpSink{listener: listener, done: make(chan struct{})}
+	go sink.serve()
+	t.Cleanup(func() { listener.Close() })
+	return sink
+}
+
+func (s *smtpSink) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// serve handles a single session.
+func (s *smtpSink) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	reply("220 localhost ESMTP sink")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250-localhost")
+			reply("250 8BITMIME")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			s.from = envelopeAddress(line[len("MAIL FROM:"):])
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			s.to = append(s.to, envelopeAddress(line[len("RCPT TO:"):]))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data bytes.Buffer
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			s.data = data.Bytes()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// envelopeAddress returns the address of a MAIL FROM or RCPT TO argument
+// such as "<sam@example.com> BODY=8BITMIME".
+func envelopeAddress(argument string) string {
+	address, _, _ := strings.Cut(strings.TrimSpace(argument), ">")
+	return strings.TrimPrefix(address, "<")
+}
+
+func TestSendThroughSink(t *testing.T) {
+	sink := newSMTPSink(t)
+	config := &Config{Host: "127.0.0.1", Port: sink.port(), From: "calendar@example.com"}
+
+	event := models.Event{
+		ID: "standup@example.com", Title: "Standup",
+		Start:     time.Date(2026, 10, 20, 13, 0, 0, 0, time.UTC),
+		End:       time.Date(2026, 10, 20, 13, 15, 0, 0, time.UTC),
+		Organizer: "alex@example.com",
+		Attendees: []models.Attendee{{Email: "sam@example.com", Status: models.PartStatNeedsAction}},
+	}
+	subject, body := Describe(ical.MethodRequest, &event, false)
+	message := &Message{
+		From:      config.From,
+		Organizer: event.Organizer,
+		To:        []string{"sam@example.com", "kim@example.com"},
+		Subject:   subject,
+		Body:      body,
+		Method:    ical.MethodRequest,
+		Calendar:  ical.Encode(ical.Calendar{Method: ical.MethodRequest, Events: []models.Event{event}}),
+	}
+	if err := config.Send(message); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	<-sink.done
+
+	if sink.from != "calendar@example.com" {
+		t.Errorf("MAIL FROM = %q", sink.from)
+	}
+	if strings.Join(sink.to, ",") != "sam@example.com,kim@example.com" {
+		t.Errorf("RCPT TO = %v", sink.to)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(sink.data))
+	if err != nil {
+		t.Fatalf("invalid message: %v", err)
+	}
+	if got := msg.Header.Get("Subject"); got != "Invitation: Standup" {
+		t.Errorf("Subject = %q", got)
+	}
+	if got := msg.Header.Get("Reply-To"); got != "alex@example.com" {
+		t.Errorf("Reply-To = %q", got)
+	}
+
+	mixed := parts(t, msg.Header.Get("Content-Type"), msg.Body, "multipart/mixed")
+	if len(mixed) != 2 {
+		t.Fatalf("got %d parts in multipart/mixed, want 2", len(mixed))
+	}
+	alternative := parts(t, mixed[0].header.Get("Content-Type"), bytes.NewReader(mixed[0].body), "multipart/alternative")
+	if len(alternative) != 2 {
+		t.Fatalf("got %d parts in multipart/alternative, want 2", len(alternative))
+	}
+	if mediaType, _, _ := mime.ParseMediaType(alternative[0].header.Get("Content-Type")); mediaType != "text/plain" {
+		t.Errorf("first alternative is %s, want text/plain", mediaType)
+	}
+	if !strings.Contains(string(alternative[0].body), "Standup") {
+		t.Errorf("text body doesn't name the event: %q", alternative[0].body)
+	}
+
+	inline := alternative[1]
+	mediaType, params, _ := mime.ParseMediaType(inline.header.Get("Content-Type"))
+	if mediaType != "text/calendar" || params["method"] != ical.MethodRequest {
+		t.Errorf("second alternative is %s with method %q, want text/calendar with method REQUEST", mediaType, params["method"])
+	}
+	assertCalendar(t, decodeBase64(t, inline.body), ical.MethodRequest, event.ID)
+
+	attachment := mixed[1]
+	mediaType, params, _ = mime.ParseMediaType(attachment.header.Get("Content-Type"))
+	if mediaType != "application/ics" || params["name"] != "invite.ics" {
+		t.Errorf("attachment is %s named %q, want application/ics named invite.ics", mediaType, params["name"])
+	}
+	if disposition, params, _ := mime.ParseMediaType(attachment.header.Get("Content-Disposition")); disposition != "attachment" || params["filename"] != "invite.ics" {
+		t.Errorf("attachment disposition = %q", attachment.header.Get("Content-Disposition"))
+	}
+	assertCalendar(t, decodeBase64(t, attachment.body), ical.MethodRequest, event.ID)
+}
+
+// part is a part of a multipart body.
+type part struct {
+	header mail.Header
+	body   []byte
+}
+
+// parts reads the parts of a multipart body of the given type.
+func parts(t *testing.T, contentType string, body io.Reader, want string) []part {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != want {
+		t.Fatalf("Content-Type = %q, want %s", contentType, want)
+	}
+	var result []part
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		p, err := reader.NextRawPart()
+		if err == io.EOF {
+			return result
+		}
+		if err != nil {
+			t.Fatalf("invalid %s: %v", want, err)
+		}
+		data, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatalf("invalid %s part: %v", want, err)
+		}
+		result = append(result, part{mail.Header(p.Header), data})
+	}
+}
+
+func decodeBase64(t *testing.T, data []byte) []byte {
+	t.Helper()
+	decoded, err := base64.StdEncoding.DecodeString(strings.NewReplacer("\r", "", "\n", "").Replace(string(data)))
+	if err != nil {
+		t.Fatalf("invalid base64: %v", err)
+	}
+	return decoded
+}
+
+// assertCalendar checks that data is a calendar with the given method
+// holding the event uid.
+func assertCalendar(t *testing.T, data []byte, method, uid string) {
+	t.Helper()
+	roots, err := ical.Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("invalid calendar: %v", err)
+	}
+	if prop := roots[0].Get("METHOD"); prop == nil || prop.Value != method {
+		t.Errorf("calendar has no METHOD:%s", method)
+	}
+	events, errs := ical.Events(roots)
+	if len(errs) > 0 || len(events) != 1 || events[0].ID != uid {
+		t.Errorf("calendar holds %v (errors %v), want event %s", events, errs, uid)
+	}
+}
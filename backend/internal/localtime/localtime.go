@@ -0,0 +1,111 @@
+// Package localtime converts between wall-clock times in a named time zone
+// and instants, reporting times that fall into DST gaps or overlaps.
+package localtime
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Layout is the wall-clock format exchanged with the AI: no UTC offset, the
+// zone is given separately.
+const Layout = "2006-01-02T15:04:05"
+
+// DateLayout is the format of dates, e.g. of all-day events.
+const DateLayout = "2006-01-02"
+
+// Pattern matches Layout, with or without seconds, or a plain date.
+const Pattern = `^\d{4}-\d{2}-\d{2}(T\d{2}:\d{2}(:\d{2})?)?$`
+
+// LoadLocation resolves an IANA time zone name such as "Europe/Paris". An
+// empty name means UTC; "Local" is rejected since the server's zone means
+// nothing to the user.
+func LoadLocation(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return time.UTC, nil
+	}
+	if name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+// Parse reads a wall-clock time in Layout (seconds optional, or a plain date
+// meaning midnight) and resolves it in loc. It returns a note when the time
+// doesn't exist because clocks skip forward (the time is moved past the gap)
+// or occurs twice because clocks go back (the earlier instant is used).
+func Parse(value string, loc *time.Location) (time.Time, string, error) {
+	wall, err := ParseWall(value)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	return Resolve(wall, loc)
+}
+
+// ParseWall reads a wall-clock time like Parse without resolving it: the
+// result holds the wall clock in UTC.
+func ParseWall(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{Layout, "2006-01-02T15:04", DateLayout} {
+		if wall, err := time.Parse(layout, value); err == nil {
+			return wall, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a local time like 2025-01-31T14:00:00", value)
+}
+
+// Resolve maps the wall clock of wall (whose own location is ignored) onto
+// an instant in loc, as described for Parse.
+func Resolve(wall time.Time, loc *time.Location) (time.Time, string, error) {
+	naive := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), time.UTC)
+
+	// Any transition near the wall time shows up in the offsets a day either side.
+	offsets := map[int]bool{}
+	for _, d := range []time.Duration{-26 * time.Hour, 0, 26 * time.Hour} {
+		_, offset := naive.Add(d).In(loc).Zone()
+		offsets[offset] = true
+	}
+	var instants []time.Time
+	for offset := range offsets {
+		instant := naive.Add(-time.Duration(offset) * time.Second)
+		if sameWallClock(instant.In(loc), naive) {
+			instants = append(instants, instant)
+		}
+	}
+	sort.Slice(instants, func(i, j int) bool { return instants[i].Before(instants[j]) })
+
+	switch len(instants) {
+	case 1:
+		return instants[0].In(loc), "", nil
+	case 0:
+		// In a gap: apply the offset from before the transition, which moves
+		// the time forward by the length of the gap.
+		_, before := naive.Add(-26 * time.Hour).In(loc).Zone()
+		moved := naive.Add(-time.Duration(before) * time.Second).In(loc)
+		note := fmt.Sprintf("%s doesn't exist in %s because the clocks skip forward, so %s is used instead",
+			naive.Format("3:04 PM on Jan 2"), loc, moved.Format("3:04 PM MST"))
+		return moved, note, nil
+	default:
+		first := instants[0].In(loc)
+		note := fmt.Sprintf("%s happens twice in %s because the clocks go back, so the first one (%s) is used",
+			naive.Format("3:04 PM on Jan 2"), loc, first.Format("3:04 PM MST"))
+		return first, note, nil
+	}
+}
+
+// Format renders t as a wall-clock time in loc, in Layout.
+func Format(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format(Layout)
+}
+
+func sameWallClock(t, wall time.Time) bool {
+	return t.Year() == wall.Year() && t.YearDay() == wall.YearDay() &&
+		t.Hour() == wall.Hour() && t.Minute() == wall.Minute() && t.Second() == wall.Second()
+}
@@ -0,0 +1,93 @@
+package localtime
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name string
+		zone string
+		wall string
+		want string
+		// note is part of the expected note, empty when there should be none.
+		note string
+	}{
+		{name: "normal time", zone: "Europe/Paris", wall: "2025-06-15T14:00:00", want: "2025-06-15T14:00:00+02:00"},
+		{name: "winter time", zone: "Europe/Paris", wall: "2025-01-15T14:00:00", want: "2025-01-15T14:00:00+01:00"},
+		{name: "utc", zone: "", wall: "2025-03-30T02:30:00", want: "2025-03-30T02:30:00Z"},
+		{
+			// Clocks skip from 02:00 to 03:00.
+			name: "spring forward gap", zone: "Europe/Paris", wall: "2025-03-30T02:30:00",
+			want: "2025-03-30T03:30:00+02:00", note: "skip forward",
+		},
+		{
+			name: "spring forward gap in New York", zone: "America/New_York", wall: "2025-03-09T02:30:00",
+			want: "2025-03-09T03:30:00-04:00", note: "skip forward",
+		},
+		{
+			// Clocks go back from 03:00 to 02:00.
+			name: "fall back overlap", zone: "Europe/Paris", wall: "2025-10-26T02:30:00",
+			want: "2025-10-26T02:30:00+02:00", note: "go back",
+		},
+		{name: "just after the overlap", zone: "Europe/Paris", wall: "2025-10-26T03:00:00", want: "2025-10-26T03:00:00+01:00"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loc, err := LoadLocation(test.zone)
+			if err != nil {
+				t.Fatalf("failed to load %q: %v", test.zone, err)
+			}
+			wall, err := ParseWall(test.wall)
+			if err != nil {
+				t.Fatalf("failed to parse %q: %v", test.wall, err)
+			}
+			got, note, err := Resolve(wall, loc)
+			if err != nil {
+				t.Fatalf("failed to resolve %q: %v", test.wall, err)
+			}
+			if got.Format(time.RFC3339) != test.want {
+				t.Errorf("got %s, want %s", got.Format(time.RFC3339), test.want)
+			}
+			if test.note == "" && note != "" {
+				t.Errorf("got note %q, want none", note)
+			}
+			if !strings.Contains(note, test.note) {
+				t.Errorf("note %q doesn't say %q", note, test.note)
+			}
+		})
+	}
+}
+
+func TestLoadLocation(t *testing.T) {
+	for _, name := range []string{"", "UTC", "Europe/Paris", " America/New_York "} {
+		if _, err := LoadLocation(name); err != nil {
+			t.Errorf("failed to load %q: %v", name, err)
+		}
+	}
+	for _, name := range []string{"Local", "Mars/Olympus_Mons", "+02:00"} {
+		if _, err := LoadLocation(name); err == nil {
+			t.Errorf("loaded invalid time zone %q", name)
+		}
+	}
+}
+
+func TestParseWall(t *testing.T) {
+	want := time.Date(2025, 1, 31, 14, 0, 0, 0, time.UTC)
+	for _, value := range []string{"2025-01-31T14:00:00", "2025-01-31T14:00", " 2025-01-31T14:00:00 "} {
+		if got, err := ParseWall(value); err != nil || !got.Equal(want) {
+			t.Errorf("ParseWall(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	if got, err := ParseWall("2025-01-31"); err != nil || !got.Equal(time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("ParseWall of a date = %v, %v, want midnight", got, err)
+	}
+	for _, value := range []string{"2025-01-31T14:00:00Z", "2025-01-31T14:00:00+01:00", "tomorrow at 2"} {
+		if _, err := ParseWall(value); err == nil {
+			t.Errorf("parsed %q as a wall-clock time", value)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Statuses of a PendingAction.
+const (
+	ActionPending  = "pending"
+	ActionApplied  = "applied"
+	ActionRejected = "rejected"
+	ActionFailed   = "failed"
+	ActionUndone   = "undone" // applied, then reverted
+)
+
+// PendingAction is a batch of calendar changes proposed by the assistant in
+// one reply. The batch is only applied, as a whole, once the user confirms
+// it, unless auto-apply is enabled for every action type in it. Results holds
+// the per-action outcome once it has been applied or has failed. MessageID is
+// the user message the actions were proposed in reply to, and Provider and
+// Model the AI that proposed them.
+type PendingAction struct {
+	ID             string          `gorm:"primarykey" json:"id"`
+	ConversationID string          `gorm:"index" json:"conversationId"`
+	UserID         string          `gorm:"index" json:"-"`
+	MessageID      string          `json:"messageId,omitempty"`
+	Provider       string          `json:"provider,omitempty"`
+	Model          string          `json:"model,omitempty"`
+	Actions        json.RawMessage `gorm:"type:text" json:"actions"`
+	Results        json.RawMessage `gorm:"type:text" json:"results,omitempty"`
+	Status         string          `gorm:"index" json:"status"`
+	Error          string          `json:"error,omitempty"`
+	CreatedAt      time.Time       `gorm:"index" json:"createdAt"`
+	UpdatedAt      time.Time       `json:"updatedAt"`
+}
+
+// Actor identifies the batch as the author of the changes it makes.
+func (p *PendingAction) Actor() Actor {
+	return Actor{
+		Type:           ActorAI,
+		UserID:         p.UserID,
+		Provider:       p.Provider,
+		Model:          p.Model,
+		ConversationID: p.ConversationID,
+		MessageID:      p.MessageID,
+		ActionID:       p.ID,
+	}
+}
+
+// AutoApplySetting records whether actions of one type skip confirmation
+// for a user.
+type AutoApplySetting struct {
+	UserID     string `gorm:"primarykey" json:"-"`
+	ActionType string `gorm:"primarykey" json:"actionType"`
+	AutoApply  bool   `json:"autoApply"`
+}
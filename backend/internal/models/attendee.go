@@ -0,0 +1,140 @@
+package models
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// AttendeeRole is the part an attendee plays in an event, after the RFC 5545
+// ROLE parameter.
+type AttendeeRole string
+
+const (
+	AttendeeChair       AttendeeRole = "chair"
+	AttendeeRequired    AttendeeRole = "required"
+	AttendeeOptional    AttendeeRole = "optional"
+	AttendeeInformation AttendeeRole = "non-participant"
+)
+
+// AttendeeRoles are the valid attendee roles.
+var AttendeeRoles = []AttendeeRole{AttendeeChair, AttendeeRequired, AttendeeOptional, AttendeeInformation}
+
+// PartStat is whether an attendee takes part in an event, after the RFC 5545
+// PARTSTAT parameter.
+type PartStat string
+
+const (
+	PartStatNeedsAction PartStat = "needs-action"
+	PartStatAccepted    PartStat = "accepted"
+	PartStatDeclined    PartStat = "declined"
+	PartStatTentative   PartStat = "tentative"
+)
+
+// PartStats are the valid participation statuses.
+var PartStats = []PartStat{PartStatNeedsAction, PartStatAccepted, PartStatDeclined, PartStatTentative}
+
+// MaxAttendees limits how many people can be invited to an event.
+const MaxAttendees = 100
+
+// Attendee is someone invited to an event, identified by their email.
+type Attendee struct {
+	Email  string       `json:"email"`
+	Name   string       `json:"name,omitempty"`
+	Role   AttendeeRole `json:"role"`
+	Status PartStat     `json:"status"`
+}
+
+// NormalizeEmail returns the form emails are stored and compared in.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizeAttendees fills in the defaults of the event's attendees: emails
+// are normalized, roles default to required and statuses to needs-action.
+func (e *Event) NormalizeAttendees() {
+	for i := range e.Attendees {
+		attendee := &e.Attendees[i]
+		attendee.Email = NormalizeEmail(attendee.Email)
+		attendee.Name = strings.TrimSpace(attendee.Name)
+		if attendee.Role == "" {
+			attendee.Role = AttendeeRequired
+		}
+		if attendee.Status == "" {
+			attendee.Status = PartStatNeedsAction
+		}
+	}
+	e.Organizer = NormalizeEmail(e.Organizer)
+}
+
+// Attendee returns the attendee with the given email, or nil.
+func (e *Event) Attendee(email string) *Attendee {
+	email = NormalizeEmail(email)
+	for i := range e.Attendees {
+		if e.Attendees[i].Email == email {
+			return &e.Attendees[i]
+		}
+	}
+	return nil
+}
+
+// validateAttendees adds the problems with the organizer and attendees to
+// errs. Each attendee is reported under its index, e.g. "attendees[1].email".
+func (e *Event) validateAttendees(errs *ValidationError) {
+	if e.Organizer != "" && !isEmail(e.Organizer) {
+		errs.Add("organizer", "must be an email address")
+	}
+	if len(e.Attendees) > MaxAttendees {
+		errs.Add("attendees", "must be at most %d", MaxAttendees)
+		return
+	}
+	seen := map[string]bool{}
+	for i, attendee := range e.Attendees {
+		field := fmt.Sprintf("attendees[%d]", i)
+		switch {
+		case !isEmail(attendee.Email):
+			errs.Add(field+".email", "must be an email address")
+		case seen[NormalizeEmail(attendee.Email)]:
+			errs.Add(field+".email", "is invited twice")
+		}
+		seen[NormalizeEmail(attendee.Email)] = true
+		if !isAttendeeRole(attendee.Role) {
+			errs.Add(field+".role", "must be one of %s", joinValues(AttendeeRoles))
+		}
+		if !IsPartStat(attendee.Status) {
+			errs.Add(field+".status", "must be one of %s", joinValues(PartStats))
+		}
+	}
+}
+
+func isEmail(value string) bool {
+	address, err := mail.ParseAddress(value)
+	return err == nil && address.Address == strings.TrimSpace(value)
+}
+
+func isAttendeeRole(role AttendeeRole) bool {
+	for _, r := range AttendeeRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPartStat reports whether status is a valid participation status.
+func IsPartStat(status PartStat) bool {
+	for _, s := range PartStats {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func joinValues[T ~string](values []T) string {
+	names := make([]string, len(values))
+	for i, value := range values {
+		names[i] = string(value)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// Kinds of Actor.
+const (
+	ActorUser   = "user"
+	ActorAI     = "ai"
+	ActorImport = "import"
+	ActorAdmin  = "admin"
+	ActorMail   = "mail"   // e.g. an attendee's emailed reply to an invitation
+	ActorSystem = "system" // e.g. the scheduled trash purge
+)
+
+// Kinds of change recorded in an AuditEntry.
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+)
+
+// Actor identifies who made a change: UserID is the user making it, or the
+// user the assistant acted for. Changes made by the assistant carry the
+// provider and model that proposed them, along with the conversation, user
+// message and pending action they came from.
+type Actor struct {
+	Type           string `gorm:"index" json:"type"`
+	UserID         string `json:"userId,omitempty"`
+	Provider       string `json:"provider,omitempty"`
+	Model          string `json:"model,omitempty"`
+	ConversationID string `json:"conversationId,omitempty"`
+	MessageID      string `json:"messageId,omitempty"`
+	ActionID       string `gorm:"index" json:"actionId,omitempty"`
+}
+
+// AuditEntry records a change to one event row. Before and After are
+// snapshots of the row; Before is nil for creates and After is nil for
+// deletes and purges. SeriesID is the master of an override and UserID the
+// owner of the event. Entries are numbered in the order they were recorded.
+type AuditEntry struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	UserID    string    `gorm:"index" json:"-"`
+	EventID   string    `gorm:"index" json:"eventId"`
+	SeriesID  string    `gorm:"index" json:"seriesId,omitempty"`
+	Action    string    `json:"action"`
+	Actor     Actor     `gorm:"embedded;embeddedPrefix:actor_" json:"actor"`
+	Before    *Event    `gorm:"serializer:json;type:text" json:"before,omitempty"`
+	After     *Event    `gorm:"serializer:json;type:text" json:"after,omitempty"`
+	CreatedAt time.Time `gorm:"index" json:"createdAt"`
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"calendar-backend/internal/localtime"
+)
+
+const (
+	MaxCalendarNameLength = 100
+	// MaxDefaultReminder is the earliest a reminder may go off, four weeks
+	// before the event.
+	MaxDefaultReminder = 4 * 7 * 24 * 60
+)
+
+// Calendar groups events of a user, e.g. "Work" or "Family". Every event
+// belongs to one calendar and every user has a default calendar, which new
+// events go to unless another one is chosen.
+//
+// Color is the default color of the calendar's events. DefaultReminder is
+// in minutes before the start of its events, and TimeZone is given to timed
+// events created in the calendar without one. Hidden calendars keep their
+// events out of the calendar view unless they are asked for explicitly.
+//
+// Role and Owner describe the calendar as seen by a user: the user's role
+// and, for calendars shared with the user, the email of the owner.
+type Calendar struct {
+	ID              string    `gorm:"primarykey" json:"id"`
+	UserID          string    `gorm:"index" json:"-"`
+	Name            string    `json:"name"`
+	Color           string    `json:"color"`
+	DefaultReminder *int      `json:"defaultReminder,omitempty"`
+	TimeZone        string    `json:"timezone,omitempty"`
+	Hidden          bool      `json:"hidden"`
+	IsDefault       bool      `json:"isDefault"`
+	Role            Role      `gorm:"-" json:"role,omitempty"`
+	Owner           string    `gorm:"-" json:"owner,omitempty"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+// Reminder returns how long before its events the calendar's reminder goes
+// off, or 0 and false if it has none.
+func (c *Calendar) Reminder() (time.Duration, bool) {
+	if c.DefaultReminder == nil {
+		return 0, false
+	}
+	return time.Duration(*c.DefaultReminder) * time.Minute, true
+}
+
+// ApplyTimeZone gives a timed event without a time zone the calendar's.
+func (c *Calendar) ApplyTimeZone(e *Event) {
+	if e.TimeZone == "" && !e.AllDay && !e.Floating {
+		e.TimeZone = c.TimeZone
+	}
+}
+
+// Validate checks the calendar as it is about to be stored, like
+// Event.Validate.
+func (c *Calendar) Validate() error {
+	errs := &ValidationError{}
+
+	name := strings.TrimSpace(c.Name)
+	switch {
+	case name == "":
+		errs.Add("name", "is required")
+	case utf8.RuneCountInString(name) > MaxCalendarNameLength:
+		errs.Add("name", "must be at most %d characters", MaxCalendarNameLength)
+	}
+	if c.Color != "" && !isEventColor(c.Color) {
+		errs.Add("color", "must be one of %s", strings.Join(EventColors, ", "))
+	}
+	if c.DefaultReminder != nil && (*c.DefaultReminder < 0 || *c.DefaultReminder > MaxDefaultReminder) {
+		errs.Add("defaultReminder", "must be between 0 and %d minutes", MaxDefaultReminder)
+	}
+	if c.TimeZone != "" {
+		if _, err := localtime.LoadLocation(c.TimeZone); err != nil {
+			errs.Add("timezone", "%v", err)
+		}
+	}
+	return errs.Err()
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Conversation groups the messages of one chat session with the assistant.
+type Conversation struct {
+	ID        string        `gorm:"primarykey" json:"id"`
+	UserID    string        `gorm:"index" json:"-"`
+	Title     string        `json:"title"`
+	Messages  []ChatMessage `gorm:"constraint:OnDelete:CASCADE" json:"messages,omitempty"`
+	CreatedAt time.Time     `json:"createdAt"`
+	UpdatedAt time.Time     `gorm:"index" json:"updatedAt"`
+}
+
+// ChatMessage is a single user or assistant turn of a conversation. Actions
+// holds the JSON of the calendar actions proposed or taken with an assistant
+// reply, so later turns can refer back to the events they touched.
+type ChatMessage struct {
+	ID             string          `gorm:"primarykey" json:"id"`
+	ConversationID string          `gorm:"index" json:"conversationId"`
+	UserID         string          `gorm:"index" json:"-"`
+	Role           string          `json:"role"` // "user" or "assistant"
+	Content        string          `json:"content"`
+	Actions        json.RawMessage `gorm:"type:text" json:"actions,omitempty"`
+	ActionID       string          `json:"actionId,omitempty"`
+	ActionStatus   string          `json:"actionStatus,omitempty"`
+	CreatedAt      time.Time       `gorm:"index" json:"createdAt"`
+}
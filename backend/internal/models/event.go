@@ -6,14 +6,78 @@ import (
 	"gorm.io/gorm"
 )
 
+// Event is a calendar entry. When RRule holds an RFC 5545 recurrence rule
+// (e.g. "FREQ=DAILY;COUNT=10") the event is the master of a series: Start/End
+// describe its first occurrence and ExDates lists the cancelled ones.
+//
+// RecurrenceID holds the original start time of an occurrence within its
+// series. It is set on expanded occurrences and on override rows, which
+// replace a single occurrence of the series identified by RecurringEventID.
+//
+// Start and End are stored in UTC. TimeZone anchors the event to an IANA
+// zone, so its recurrences keep their wall-clock time across DST changes and
+// its times are rendered in that zone. All-day events span whole dates: Start
+// is midnight of the first day and End midnight after the last one. Floating
+// events happen at the same wall-clock time in every zone. Both keep their
+// wall clock in UTC, and neither has a TimeZone.
+//
+// Transparent events (RFC 5545 TRANSP:TRANSPARENT) show the user as free.
+//
+// Attendees are the people invited to the event by the Organizer, both by
+// email. The organizer is the owner of the event unless it was imported.
+//
+// UserID is the owner of the event; overrides and split series inherit it.
+// CalendarID is the calendar the event belongs to, which is the same for
+// the whole series.
 type Event struct {
-	ID          string         `gorm:"primarykey" json:"id"`
-	Title       string         `json:"title"`
-	Description string         `json:"description"`
-	Start       time.Time      `json:"start"`
-	End         time.Time      `json:"end"`
-	Color       string         `json:"color"`
-	CreatedAt   time.Time      `json:"createdAt"`
-	UpdatedAt   time.Time      `json:"updatedAt"`
-	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
-} 
\ No newline at end of file
+	ID               string         `gorm:"primarykey" json:"id"`
+	UserID           string         `gorm:"index" json:"userId,omitempty"`
+	CalendarID       string         `gorm:"index" json:"calendarId"`
+	Title            string         `json:"title"`
+	Description      string         `json:"description"`
+	Start            time.Time      `gorm:"index" json:"start"`
+	End              time.Time      `gorm:"index" json:"end"`
+	TimeZone         string         `json:"timezone,omitempty"`
+	AllDay           bool           `json:"allDay,omitempty"`
+	Floating         bool           `json:"floating,omitempty"`
+	Transparent      bool           `json:"transparent,omitempty"`
+	Color            string         `json:"color"`
+	RRule            string         `gorm:"column:rrule;not null;default:''" json:"rrule,omitempty"`
+	ExDates          []time.Time    `gorm:"serializer:json;type:text" json:"exdates,omitempty"`
+	Organizer        string         `json:"organizer,omitempty"`
+	Attendees        []Attendee     `gorm:"serializer:json;type:text" json:"attendees,omitempty"`
+	RecurringEventID string         `gorm:"index;not null;default:''" json:"recurringEventId,omitempty"`
+	RecurrenceID     *time.Time     `json:"recurrenceId,omitempty"`
+	CreatedAt        time.Time      `json:"createdAt"`
+	UpdatedAt        time.Time      `gorm:"index" json:"updatedAt"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// IsOverride reports whether the event replaces one occurrence of a series.
+func (e *Event) IsOverride() bool {
+	return e.RecurringEventID != ""
+}
+
+// IsRecurring reports whether the event is the master of a recurring series.
+func (e *Event) IsRecurring() bool {
+	return e.RRule != ""
+}
+
+// IsBusy reports whether the event blocks its time, so that other events
+// overlapping it conflict with it. All-day events mark days rather than
+// block hours, so only timed, opaque events are busy.
+func (e *Event) IsBusy() bool {
+	return !e.Transparent && !e.AllDay
+}
+
+// Location returns the zone the event's recurrences are expanded in: its
+// TimeZone, or UTC, which also holds the wall clock of floating and all-day
+// events.
+func (e *Event) Location() *time.Location {
+	if e.TimeZone != "" {
+		if loc, err := time.LoadLocation(e.TimeZone); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
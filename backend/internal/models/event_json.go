@@ -0,0 +1,101 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"calendar-backend/internal/localtime"
+)
+
+// MarshalJSON renders Start and End the way the event is anchored: dates for
+// all-day events, wall-clock times without an offset for floating events and
+// times with the zone's offset for events with a TimeZone.
+func (e Event) MarshalJSON() ([]byte, error) {
+	type plain Event
+	return json.Marshal(struct {
+		plain
+		Start string `json:"start"`
+		End   string `json:"end"`
+	}{plain(e), e.FormatTime(e.Start), e.FormatTime(e.End)})
+}
+
+// UnmarshalJSON accepts Start and End in the forms written by MarshalJSON.
+// Events with a TimeZone may also give wall-clock times in that zone.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	type plain Event
+	raw := struct {
+		*plain
+		Start *string `json:"start"`
+		End   *string `json:"end"`
+	}{plain: (*plain)(e)}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	errs := &ValidationError{}
+	var err error
+	if raw.Start != nil {
+		if e.Start, err = e.ParseTime(*raw.Start); err != nil {
+			errs.Add("start", "is invalid: %v", err)
+		}
+	}
+	if raw.End != nil {
+		if e.End, err = e.ParseTime(*raw.End); err != nil {
+			errs.Add("end", "is invalid: %v", err)
+		}
+	}
+	return errs.Err()
+}
+
+// FormatTime renders one of the event's times as described for MarshalJSON.
+func (e *Event) FormatTime(t time.Time) string {
+	switch {
+	case e.AllDay:
+		return t.UTC().Format(localtime.DateLayout)
+	case e.Floating:
+		return t.UTC().Format(localtime.Layout)
+	case e.TimeZone != "":
+		return t.In(e.Location()).Format(time.RFC3339)
+	default:
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+}
+
+// ParseTime reads a time given for the event and returns it as stored.
+func (e *Event) ParseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if e.AllDay || e.Floating {
+		// Only the wall clock matters; drop any offset that came along.
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			value = t.Format(localtime.Layout)
+		}
+		wall, err := localtime.ParseWall(value)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if e.AllDay {
+			return wall.Truncate(24 * time.Hour), nil
+		}
+		return wall, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	if e.TimeZone == "" {
+		return time.Time{}, fmt.Errorf("%q is not an RFC3339 timestamp", value)
+	}
+	loc, err := localtime.LoadLocation(e.TimeZone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, _, err := localtime.Parse(value, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
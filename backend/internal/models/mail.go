@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// MaxMailAttempts is how often sending an OutgoingMail is tried before it is
+// given up.
+const MaxMailAttempts = 5
+
+// OutgoingMail is an iMIP message (RFC 6047) in the outbox: an invitation,
+// update or cancellation sent to attendees on behalf of the organizer of an
+// event. Calendar is the iCalendar object it carries, whose METHOD is Method.
+// UserID is the owner of the event.
+type OutgoingMail struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	UserID     string     `gorm:"index" json:"-"`
+	EventID    string     `gorm:"index" json:"eventId"`
+	Method     string     `json:"method"`
+	Organizer  string     `json:"organizer"`
+	Recipients []string   `gorm:"serializer:json;type:text" json:"recipients"`
+	Subject    string     `json:"subject"`
+	Body       string     `gorm:"type:text" json:"body"`
+	Calendar   string     `gorm:"type:text" json:"-"`
+	Attempts   int        `json:"attempts"`
+	LastError  string     `json:"lastError,omitempty"`
+	SentAt     *time.Time `gorm:"index" json:"sentAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
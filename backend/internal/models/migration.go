@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// Migration records a one-off data migration that has been applied, so that
+// it isn't run again on the next start.
+type Migration struct {
+	Name      string `gorm:"primarykey"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,85 @@
+package models
+
+import "time"
+
+// Role is what a user may do with a calendar. Shares grant one of
+// ShareRoles; the owner of a calendar has RoleOwner.
+type Role string
+
+const (
+	// RoleFreeBusy only shows when the calendar's events take place.
+	RoleFreeBusy Role = "freebusy"
+	// RoleRead shows the events in full.
+	RoleRead Role = "read"
+	// RoleEdit also allows creating, changing and deleting events.
+	RoleEdit Role = "edit"
+	// RoleManage also allows changing the calendar's settings and shares.
+	RoleManage Role = "manage"
+	// RoleOwner also allows deleting the calendar.
+	RoleOwner Role = "owner"
+)
+
+// ShareRoles are the roles a calendar can be shared with, least access first.
+var ShareRoles = []Role{RoleFreeBusy, RoleRead, RoleEdit, RoleManage}
+
+// Allows reports whether the role grants at least the access of min. The
+// empty role grants nothing.
+func (r Role) Allows(min Role) bool {
+	return r.rank() >= min.rank() && r.rank() > 0
+}
+
+func (r Role) rank() int {
+	if r == RoleOwner {
+		return len(ShareRoles) + 1
+	}
+	for i, role := range ShareRoles {
+		if role == r {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// IsShareRole reports whether a calendar can be shared with the role.
+func (r Role) IsShareRole() bool {
+	return r != RoleOwner && r.rank() > 0
+}
+
+// CalendarShare gives another user access to a calendar. Hidden is that
+// user's own visibility toggle for the calendar, like Calendar.Hidden is the
+// owner's.
+type CalendarShare struct {
+	ID         string    `gorm:"primarykey" json:"id"`
+	CalendarID string    `gorm:"uniqueIndex:idx_calendar_share" json:"calendarId"`
+	UserID     string    `gorm:"uniqueIndex:idx_calendar_share;index" json:"userId"`
+	Email      string    `gorm:"-" json:"email,omitempty"`
+	Role       Role      `json:"role"`
+	Hidden     bool      `json:"hidden"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// BusyTitle replaces the title of events that may only be seen as busy.
+const BusyTitle = "Busy"
+
+// FreeBusy returns a copy of the event that only tells when it takes place,
+// for users who may see no more of it.
+func (e Event) FreeBusy() Event {
+	return Event{
+		ID:               e.ID,
+		CalendarID:       e.CalendarID,
+		Title:            BusyTitle,
+		Start:            e.Start,
+		End:              e.End,
+		TimeZone:         e.TimeZone,
+		AllDay:           e.AllDay,
+		Floating:         e.Floating,
+		Transparent:      e.Transparent,
+		RRule:            e.RRule,
+		ExDates:          e.ExDates,
+		RecurringEventID: e.RecurringEventID,
+		RecurrenceID:     e.RecurrenceID,
+		CreatedAt:        e.CreatedAt,
+		UpdatedAt:        e.UpdatedAt,
+	}
+}
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// MinPasswordLength is the shortest password an account may have.
+const MinPasswordLength = 8
+
+// User owns events, conversations and the rest of the per-user data, which
+// reference it through their UserID column. The feed token grants read-only
+// access to the user's iCalendar feed for clients that can't log in; like
+// other tokens only its hash is stored, in FeedTokenHash.
+type User struct {
+	ID            string    `gorm:"primarykey" json:"id"`
+	Email         string    `gorm:"uniqueIndex" json:"email"`
+	Name          string    `json:"name"`
+	PasswordHash  string    `json:"-"`
+	FeedTokenHash string    `gorm:"index" json:"-"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// Session is a login of a user, identified by a random token of which only
+// the SHA-256 hash is stored.
+type Session struct {
+	TokenHash string    `gorm:"primarykey"`
+	UserID    string    `gorm:"index"`
+	ExpiresAt time.Time `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// APIToken is a long-lived credential for scripts and calendar clients. Like
+// sessions, only the hash of the token is stored; the token itself is shown
+// once, when it is created.
+type APIToken struct {
+	ID         string     `gorm:"primarykey" json:"id"`
+	UserID     string     `gorm:"index" json:"-"`
+	Name       string     `json:"name"`
+	TokenHash  string     `gorm:"uniqueIndex" json:"-"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"calendar-backend/internal/localtime"
+	"calendar-backend/internal/recurrence"
+)
+
+const (
+	MaxTitleLength       = 200
+	MaxDescriptionLength = 5000
+)
+
+// EventColors are the colors an event may have, matching the palette of the
+// frontend. An empty color leaves the choice to the client.
+var EventColors = []string{
+	"var(--tokyo-purple)",
+	"var(--tokyo-blue)",
+	"var(--tokyo-cyan)",
+	"var(--tokyo-green)",
+	"var(--tokyo-red)",
+}
+
+// FieldError describes what is wrong with one field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a model.
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Field + " " + field.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Add records a problem with a field.
+func (e *ValidationError) Add(field, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns the error, or nil if no field was reported.
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate checks the event as it is about to be stored, returning a
+// *ValidationError naming each invalid field. Field names are those of the
+// JSON API.
+func (e *Event) Validate() error {
+	errs := &ValidationError{}
+
+	title := strings.TrimSpace(e.Title)
+	switch {
+	case title == "":
+		errs.Add("title", "is required")
+	case utf8.RuneCountInString(title) > MaxTitleLength:
+		errs.Add("title", "must be at most %d characters", MaxTitleLength)
+	}
+	if utf8.RuneCountInString(e.Description) > MaxDescriptionLength {
+		errs.Add("description", "must be at most %d characters", MaxDescriptionLength)
+	}
+
+	if e.Start.IsZero() {
+		errs.Add("start", "is required")
+	}
+	switch {
+	case e.End.IsZero():
+		errs.Add("end", "is required")
+	case !e.Start.IsZero() && !e.End.After(e.Start):
+		errs.Add("end", "must be after start")
+	}
+
+	if e.Color != "" && !isEventColor(e.Color) {
+		errs.Add("color", "must be one of %s", strings.Join(EventColors, ", "))
+	}
+
+	if e.TimeZone != "" {
+		if e.AllDay || e.Floating {
+			errs.Add("timezone", "must be empty for all-day and floating events")
+		} else if _, err := localtime.LoadLocation(e.TimeZone); err != nil {
+			errs.Add("timezone", "%v", err)
+		}
+	}
+	if e.AllDay && e.Floating {
+		errs.Add("floating", "must be false for all-day events")
+	}
+
+	if e.IsRecurring() {
+		if _, err := recurrence.Parse(e.RRule); err != nil {
+			errs.Add("rrule", "is invalid: %v", err)
+		}
+	}
+	e.validateAttendees(errs)
+	return errs.Err()
+}
+
+func isEventColor(color string) bool {
+	for _, c := range EventColors {
+		if c == color {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,366 @@
+package recurrence
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	utcLayout   = "20060102T150405Z"
+	localLayout = "20060102T150405"
+)
+
+// maxIterations bounds the number of periods walked while expanding a rule
+// so that a malformed or very dense rule can't spin forever.
+const maxIterations = 100000
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// WeekdayNum is a BYDAY entry such as "MO" or "-1FR". Ordinal is zero when
+// the entry applies to every matching weekday in the period.
+type WeekdayNum struct {
+	Ordinal int
+	Weekday time.Weekday
+}
+
+// Rule is the subset of an RFC 5545 RRULE supported by the calendar. When
+// FloatingUntil is set, Until was given without a time zone and holds a wall
+// clock time that is taken in the time zone of the series it belongs to.
+type Rule struct {
+	Freq          Frequency
+	Interval      int
+	Count         int
+	Until         *time.Time
+	FloatingUntil bool
+	ByDay         []WeekdayNum
+}
+
+var weekdayCodes = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+var weekdayNames = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// Parse parses an RRULE value such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE".
+// A leading "RRULE:" prefix is accepted.
+func Parse(value string) (*Rule, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "RRULE:")
+	if value == "" {
+		return nil, fmt.Errorf("empty recurrence rule")
+	}
+
+	rule := &Rule{Interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		if part == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rule part %q", part)
+		}
+
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			switch freq := Frequency(strings.ToUpper(val)); freq {
+			case Daily, Weekly, Monthly, Yearly:
+				rule.Freq = freq
+			default:
+				return nil, fmt.Errorf("unsupported FREQ %q", val)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid INTERVAL %q", val)
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid COUNT %q", val)
+			}
+			rule.Count = n
+		case "UNTIL":
+			until, floating, err := parseUntil(val)
+			if err != nil {
+				return nil, err
+			}
+			rule.Until, rule.FloatingUntil = &until, floating
+		case "BYDAY":
+			for _, code := range strings.Split(val, ",") {
+				day, err := parseWeekdayNum(code)
+				if err != nil {
+					return nil, err
+				}
+				rule.ByDay = append(rule.ByDay, day)
+			}
+		case "WKST":
+			if strings.ToUpper(val) != "MO" {
+				return nil, fmt.Errorf("only WKST=MO is supported")
+			}
+		default:
+			return nil, fmt.Errorf("unsupported rule part %q", key)
+		}
+	}
+
+	if rule.Freq == "" {
+		return nil, fmt.Errorf("recurrence rule is missing FREQ")
+	}
+	if rule.Count > 0 && rule.Until != nil {
+		return nil, fmt.Errorf("COUNT and UNTIL must not both be set")
+	}
+	for _, day := range rule.ByDay {
+		if day.Ordinal != 0 && rule.Freq != Monthly {
+			return nil, fmt.Errorf("numbered BYDAY values are only supported with FREQ=MONTHLY")
+		}
+	}
+	if rule.Freq == Yearly && len(rule.ByDay) > 0 {
+		return nil, fmt.Errorf("BYDAY is not supported with FREQ=YEARLY")
+	}
+	return rule, nil
+}
+
+// parseUntil parses an UNTIL value, reporting whether it is floating, i.e.
+// a local time or a date rather than a UTC time.
+func parseUntil(value string) (time.Time, bool, error) {
+	for _, layout := range []string{utcLayout, localLayout, "20060102"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			if layout == "20060102" {
+				// A date-only UNTIL includes the whole day.
+				t = t.Add(24*time.Hour - time.Second)
+			}
+			return t, layout != utcLayout, nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("invalid UNTIL %q", value)
+}
+
+// until returns the end of the rule as an instant, taking a floating UNTIL
+// in loc.
+func (r *Rule) until(loc *time.Location) *time.Time {
+	if r.Until == nil || !r.FloatingUntil {
+		return r.Until
+	}
+	u := r.Until
+	t := time.Date(u.Year(), u.Month(), u.Day(), u.Hour(), u.Minute(), u.Second(), 0, loc)
+	return &t
+}
+
+func parseWeekdayNum(code string) (WeekdayNum, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) < 2 {
+		return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", code)
+	}
+	weekday, ok := weekdayCodes[code[len(code)-2:]]
+	if !ok {
+		return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", code)
+	}
+	day := WeekdayNum{Weekday: weekday}
+	if prefix := code[:len(code)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", code)
+		}
+		day.Ordinal = n
+	}
+	return day, nil
+}
+
+// String formats the rule back into its RRULE value.
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.Until != nil && r.FloatingUntil {
+		parts = append(parts, "UNTIL="+r.Until.Format(localLayout))
+	} else if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(utcLayout))
+	}
+	if len(r.ByDay) > 0 {
+		codes := make([]string, len(r.ByDay))
+		for i, day := range r.ByDay {
+			codes[i] = weekdayNames[day.Weekday]
+			if day.Ordinal != 0 {
+				codes[i] = strconv.Itoa(day.Ordinal) + codes[i]
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	return strings.Join(parts, ";")
+}
+
+// All walks the occurrence start times of the rule anchored at dtstart in
+// chronological order, calling fn for each one until fn returns false or the
+// rule is exhausted. COUNT and UNTIL are honoured; exclusions are the
+// caller's concern. As in RFC 5545, dtstart itself is always the first
+// occurrence and counts towards COUNT, even if the rule wouldn't generate
+// it. A floating UNTIL is taken in the time zone of dtstart.
+func (r *Rule) All(dtstart time.Time, fn func(time.Time) bool) {
+	until := r.until(dtstart.Location())
+	if until != nil && dtstart.After(*until) {
+		return
+	}
+	if !fn(dtstart) {
+		return
+	}
+	emitted := 1
+	if r.Count > 0 && emitted >= r.Count {
+		return
+	}
+	for period := 0; period < maxIterations; period++ {
+		for _, occurrence := range r.candidates(dtstart, period) {
+			if !occurrence.After(dtstart) {
+				continue
+			}
+			if until != nil && occurrence.After(*until) {
+				return
+			}
+			if !fn(occurrence) {
+				return
+			}
+			emitted++
+			if r.Count > 0 && emitted >= r.Count {
+				return
+			}
+		}
+	}
+}
+
+// Between returns the occurrence start times whose [start, start+duration)
+// span overlaps the [from, to) window, skipping any listed in exdates.
+func (r *Rule) Between(dtstart time.Time, duration time.Duration, from, to time.Time, exdates []time.Time) []time.Time {
+	var occurrences []time.Time
+	r.All(dtstart, func(start time.Time) bool {
+		if !start.Before(to) {
+			return false
+		}
+		if start.Add(duration).After(from) && !containsTime(exdates, start) {
+			occurrences = append(occurrences, start)
+		}
+		return true
+	})
+	return occurrences
+}
+
+func containsTime(times []time.Time, t time.Time) bool {
+	for _, candidate := range times {
+		if candidate.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// candidates returns the sorted occurrence times produced by the n-th period
+// after the one containing dtstart.
+func (r *Rule) candidates(dtstart time.Time, n int) []time.Time {
+	loc := dtstart.Location()
+	hour, min, sec := dtstart.Clock()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, hour, min, sec, 0, loc)
+	}
+
+	var result []time.Time
+	switch r.Freq {
+	case Daily:
+		t := dtstart.AddDate(0, 0, n*r.Interval)
+		if r.matchesWeekday(t) {
+			result = append(result, t)
+		}
+
+	case Weekly:
+		// Weeks start on Monday (WKST=MO).
+		offset := (int(dtstart.Weekday()) + 6) % 7
+		weekStart := dtstart.AddDate(0, 0, -offset+n*7*r.Interval)
+		if len(r.ByDay) == 0 {
+			result = append(result, weekStart.AddDate(0, 0, offset))
+			break
+		}
+		for i := 0; i < 7; i++ {
+			t := weekStart.AddDate(0, 0, i)
+			if r.matchesWeekday(t) {
+				result = append(result, at(t.Year(), t.Month(), t.Day()))
+			}
+		}
+
+	case Monthly:
+		first := time.Date(dtstart.Year(), dtstart.Month()+time.Month(n*r.Interval), 1, 0, 0, 0, 0, loc)
+		if len(r.ByDay) == 0 {
+			if dtstart.Day() <= daysIn(first) {
+				result = append(result, at(first.Year(), first.Month(), dtstart.Day()))
+			}
+			break
+		}
+		for day := 1; day <= daysIn(first); day++ {
+			if r.matchesMonthDay(first.Year(), first.Month(), day, loc) {
+				result = append(result, at(first.Year(), first.Month(), day))
+			}
+		}
+
+	case Yearly:
+		year := dtstart.Year() + n*r.Interval
+		first := time.Date(year, dtstart.Month(), 1, 0, 0, 0, 0, loc)
+		if dtstart.Day() <= daysIn(first) {
+			result = append(result, at(year, dtstart.Month(), dtstart.Day()))
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Before(result[j]) })
+	return result
+}
+
+func (r *Rule) matchesWeekday(t time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, day := range r.ByDay {
+		if day.Weekday == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesMonthDay reports whether the given day of the month is selected by
+// BYDAY, taking ordinals like "2TU" or "-1FR" into account.
+func (r *Rule) matchesMonthDay(year int, month time.Month, day int, loc *time.Location) bool {
+	t := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	total := daysIn(t)
+	for _, byDay := range r.ByDay {
+		if byDay.Weekday != t.Weekday() {
+			continue
+		}
+		switch {
+		case byDay.Ordinal == 0:
+			return true
+		case byDay.Ordinal > 0 && (day-1)/7+1 == byDay.Ordinal:
+			return true
+		case byDay.Ordinal < 0 && (total-day)/7+1 == -byDay.Ordinal:
+			return true
+		}
+	}
+	return false
+}
+
+func daysIn(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
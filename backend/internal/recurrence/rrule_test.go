@@ -0,0 +1,213 @@
+package recurrence
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// paris returns the time zone most tests expand in, so that their series
+// cross daylight saving time changes.
+func paris(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatalf("failed to load time zone: %v", err)
+	}
+	return loc
+}
+
+// format renders times with their offset, so that tests check both the wall
+// clock and the instant of each occurrence.
+func format(times []time.Time) []string {
+	var formatted []string
+	for _, t := range times {
+		formatted = append(formatted, t.Format(time.RFC3339))
+	}
+	return formatted
+}
+
+func TestAll(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		dtstart string
+		want    []string
+	}{
+		{
+			name:    "daily with interval",
+			rule:    "FREQ=DAILY;INTERVAL=2;COUNT=4",
+			dtstart: "2026-10-05T09:00:00+02:00",
+			want:    []string{"2026-10-05T09:00:00+02:00", "2026-10-07T09:00:00+02:00", "2026-10-09T09:00:00+02:00", "2026-10-11T09:00:00+02:00"},
+		},
+		{
+			name:    "weekly with interval and weekdays",
+			rule:    "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;COUNT=5",
+			dtstart: "2026-10-05T09:00:00+02:00",
+			want:    []string{"2026-10-05T09:00:00+02:00", "2026-10-08T09:00:00+02:00", "2026-10-19T09:00:00+02:00", "2026-10-22T09:00:00+02:00", "2026-11-02T09:00:00+01:00"},
+		},
+		{
+			name:    "monthly with interval",
+			rule:    "FREQ=MONTHLY;INTERVAL=3;COUNT=4",
+			dtstart: "2026-01-15T09:00:00+01:00",
+			want:    []string{"2026-01-15T09:00:00+01:00", "2026-04-15T09:00:00+02:00", "2026-07-15T09:00:00+02:00", "2026-10-15T09:00:00+02:00"},
+		},
+		{
+			name:    "yearly with interval",
+			rule:    "FREQ=YEARLY;INTERVAL=2;COUNT=3",
+			dtstart: "2026-06-01T09:00:00+02:00",
+			want:    []string{"2026-06-01T09:00:00+02:00", "2028-06-01T09:00:00+02:00", "2030-06-01T09:00:00+02:00"},
+		},
+		{
+			name:    "count",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: "2026-10-05T09:00:00+02:00",
+			want:    []string{"2026-10-05T09:00:00+02:00", "2026-10-06T09:00:00+02:00", "2026-10-07T09:00:00+02:00"},
+		},
+		{
+			name:    "until includes its own time",
+			rule:    "FREQ=DAILY;UNTIL=20261007T070000Z",
+			dtstart: "2026-10-05T09:00:00+02:00",
+			want:    []string{"2026-10-05T09:00:00+02:00", "2026-10-06T09:00:00+02:00", "2026-10-07T09:00:00+02:00"},
+		},
+		{
+			name:    "until before an occurrence",
+			rule:    "FREQ=DAILY;UNTIL=20261007T065959Z",
+			dtstart: "2026-10-05T09:00:00+02:00",
+			want:    []string{"2026-10-05T09:00:00+02:00", "2026-10-06T09:00:00+02:00"},
+		},
+		{
+			// 08:30 in Paris, rather than 08:30 UTC which is after the
+			// occurrence on the 7th.
+			name:    "floating until",
+			rule:    "FREQ=DAILY;UNTIL=20261007T083000",
+			dtstart: "2026-10-05T09:00:00+02:00",
+			want:    []string{"2026-10-05T09:00:00+02:00", "2026-10-06T09:00:00+02:00"},
+		},
+		{
+			name:    "date-only until includes the whole day",
+			rule:    "FREQ=DAILY;UNTIL=20261007",
+			dtstart: "2026-10-05T23:30:00+02:00",
+			want:    []string{"2026-10-05T23:30:00+02:00", "2026-10-06T23:30:00+02:00", "2026-10-07T23:30:00+02:00"},
+		},
+		{
+			name:    "until before dtstart",
+			rule:    "FREQ=DAILY;UNTIL=20261004T000000Z",
+			dtstart: "2026-10-05T09:00:00+02:00",
+		},
+		{
+			name:    "last friday of the month",
+			rule:    "FREQ=MONTHLY;BYDAY=-1FR;COUNT=3",
+			dtstart: "2026-10-30T09:00:00+01:00",
+			want:    []string{"2026-10-30T09:00:00+01:00", "2026-11-27T09:00:00+01:00", "2026-12-25T09:00:00+01:00"},
+		},
+		{
+			name:    "second monday of the month",
+			rule:    "FREQ=MONTHLY;BYDAY=2MO;COUNT=3",
+			dtstart: "2026-10-12T09:00:00+02:00",
+			want:    []string{"2026-10-12T09:00:00+02:00", "2026-11-09T09:00:00+01:00", "2026-12-14T09:00:00+01:00"},
+		},
+		{
+			name:    "31st skips short months",
+			rule:    "FREQ=MONTHLY;COUNT=4",
+			dtstart: "2026-01-31T09:00:00+01:00",
+			want:    []string{"2026-01-31T09:00:00+01:00", "2026-03-31T09:00:00+02:00", "2026-05-31T09:00:00+02:00", "2026-07-31T09:00:00+02:00"},
+		},
+		{
+			name:    "29 february only in leap years",
+			rule:    "FREQ=YEARLY;COUNT=2",
+			dtstart: "2028-02-29T09:00:00+01:00",
+			want:    []string{"2028-02-29T09:00:00+01:00", "2032-02-29T09:00:00+01:00"},
+		},
+		{
+			name:    "dtstart not matching the rule counts as the first occurrence",
+			rule:    "FREQ=WEEKLY;BYDAY=MO;COUNT=3",
+			dtstart: "2026-10-06T09:00:00+02:00",
+			want:    []string{"2026-10-06T09:00:00+02:00", "2026-10-12T09:00:00+02:00", "2026-10-19T09:00:00+02:00"},
+		},
+		{
+			name:    "spring forward keeps the wall clock",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: "2026-03-28T09:00:00+01:00",
+			want:    []string{"2026-03-28T09:00:00+01:00", "2026-03-29T09:00:00+02:00", "2026-03-30T09:00:00+02:00"},
+		},
+		{
+			name:    "fall back keeps the wall clock",
+			rule:    "FREQ=WEEKLY;COUNT=2",
+			dtstart: "2026-10-19T09:00:00+02:00",
+			want:    []string{"2026-10-19T09:00:00+02:00", "2026-10-26T09:00:00+01:00"},
+		},
+	}
+
+	loc := paris(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := Parse(test.rule)
+			if err != nil {
+				t.Fatalf("failed to parse %q: %v", test.rule, err)
+			}
+			dtstart, err := time.Parse(time.RFC3339, test.dtstart)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []time.Time
+			rule.All(dtstart.In(loc), func(start time.Time) bool {
+				got = append(got, start)
+				return len(got) < 100
+			})
+			if formatted := format(got); !reflect.DeepEqual(formatted, test.want) {
+				t.Errorf("got %v, want %v", formatted, test.want)
+			}
+		})
+	}
+}
+
+func TestBetween(t *testing.T) {
+	loc := paris(t)
+	at := func(day int) time.Time { return time.Date(2026, 10, day, 9, 0, 0, 0, loc) }
+	rule, err := Parse("FREQ=DAILY;COUNT=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The occurrence on the 6th is still running at the start of the window
+	// and the one on the 9th starts when it ends.
+	got := rule.Between(at(5), time.Hour, at(6).Add(30*time.Minute), at(9), []time.Time{at(7).UTC()})
+	want := []string{"2026-10-06T09:00:00+02:00", "2026-10-08T09:00:00+02:00"}
+	if !reflect.DeepEqual(format(got), want) {
+		t.Errorf("got %v, want %v", format(got), want)
+	}
+}
+
+func TestParse(t *testing.T) {
+	valid := []string{
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE",
+		"FREQ=MONTHLY;COUNT=3;BYDAY=-1FR",
+		"FREQ=DAILY;UNTIL=20261007T070000Z",
+		"FREQ=DAILY;UNTIL=20261007T083000",
+	}
+	for _, value := range valid {
+		rule, err := Parse(value)
+		if err != nil {
+			t.Errorf("failed to parse %q: %v", value, err)
+		} else if rule.String() != value {
+			t.Errorf("%q formats as %q", value, rule.String())
+		}
+	}
+
+	invalid := []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=3;UNTIL=20261007",
+		"FREQ=WEEKLY;BYDAY=2MO",
+		"FREQ=YEARLY;BYDAY=MO",
+		"FREQ=DAILY;UNTIL=tomorrow",
+	}
+	for _, value := range invalid {
+		if _, err := Parse(value); err == nil {
+			t.Errorf("parsed invalid rule %q", value)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"calendar-backend/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrActionNotFound is returned when a pending action ID doesn't exist.
+	ErrActionNotFound = errors.New("action not found")
+	// ErrActionResolved is returned when confirming or rejecting an action
+	// that was already confirmed or rejected.
+	ErrActionResolved = errors.New("action already resolved")
+)
+
+// CreatePendingAction stores a batch of proposed calendar actions awaiting
+// confirmation. The actor names the AI that proposed them, the user they
+// were proposed to and the conversation and message they were proposed in.
+func CreatePendingAction(db *gorm.DB, actor models.Actor, actions json.RawMessage) (*models.PendingAction, error) {
+	pending := &models.PendingAction{
+		ID:             uuid.New().String(),
+		ConversationID: actor.ConversationID,
+		UserID:         actor.UserID,
+		MessageID:      actor.MessageID,
+		Provider:       actor.Provider,
+		Model:          actor.Model,
+		Actions:        actions,
+		Status:         models.ActionPending,
+	}
+	if err := db.Create(pending).Error; err != nil {
+		return nil, fmt.Errorf("failed to save pending action: %v", err)
+	}
+	return pending, nil
+}
+
+// GetPendingAction returns a proposed action by ID, whatever its status.
+func GetPendingAction(db *gorm.DB, id string) (*models.PendingAction, error) {
+	var pending models.PendingAction
+	err := db.First(&pending, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrActionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load action: %v", err)
+	}
+	return &pending, nil
+}
+
+// ListPendingActions returns proposed actions, newest first, optionally
+// restricted to a conversation and/or a status.
+func ListPendingActions(db *gorm.DB, conversationID, status string) ([]models.PendingAction, error) {
+	query := db.Order("created_at DESC")
+	if conversationID != "" {
+		query = query.Where("conversation_id = ?", conversationID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var actions []models.PendingAction
+	if err := query.Find(&actions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list actions: %v", err)
+	}
+	return actions, nil
+}
+
+// ClaimPendingAction moves a pending action to status, failing with
+// ErrActionResolved if it is no longer pending. The check and the update are
+// a single statement so an action can't be confirmed twice.
+func ClaimPendingAction(db *gorm.DB, id, status string) (*models.PendingAction, error) {
+	result := db.Model(&models.PendingAction{}).
+		Where("id = ? AND status = ?", id, models.ActionPending).
+		Update("status", status)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to update action: %v", result.Error)
+	}
+	pending, err := GetPendingAction(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if result.RowsAffected == 0 {
+		return pending, ErrActionResolved
+	}
+	return pending, nil
+}
+
+// FinishPendingAction records the outcome of applying a claimed batch: the
+// actions as executed (e.g. with the created events' IDs), the per-action
+// results and the error, if any.
+func FinishPendingAction(db *gorm.DB, pending *models.PendingAction, actions, results json.RawMessage, execErr error) error {
+	updates := map[string]interface{}{"status": models.ActionApplied}
+	pending.Status = models.ActionApplied
+	if actions != nil {
+		updates["actions"] = actions
+		pending.Actions = actions
+	}
+	if results != nil {
+		updates["results"] = results
+		pending.Results = results
+	}
+	if execErr != nil {
+		updates["status"] = models.ActionFailed
+		updates["error"] = execErr.Error()
+		pending.Status = models.ActionFailed
+		pending.Error = execErr.Error()
+	}
+	if err := db.Model(pending).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update action %s: %v", pending.ID, err)
+	}
+	return nil
+}
+
+// AutoApplySettings returns, for each action type with a stored setting,
+// whether it is applied without confirmation.
+func AutoApplySettings(db *gorm.DB) (map[string]bool, error) {
+	var settings []models.AutoApplySetting
+	if err := db.Find(&settings).Error; err != nil {
+		return nil, fmt.Errorf("failed to load auto-apply settings: %v", err)
+	}
+	result := make(map[string]bool, len(settings))
+	for _, setting := range settings {
+		result[setting.ActionType] = setting.AutoApply
+	}
+	return result, nil
+}
+
+// AutoApplyEnabled reports whether actions of the given type skip
+// confirmation. It is off unless explicitly enabled.
+func AutoApplyEnabled(db *gorm.DB, actionType string) (bool, error) {
+	settings, err := AutoApplySettings(db)
+	if err != nil {
+		return false, err
+	}
+	return settings[actionType], nil
+}
+
+// SetAutoApply stores the user's auto-apply setting of each given action type.
+func SetAutoApply(db *gorm.DB, userID string, settings map[string]bool) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for actionType, autoApply := range settings {
+			setting := models.AutoApplySetting{UserID: userID, ActionType: actionType, AutoApply: autoApply}
+			err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&setting).Error
+			if err != nil {
+				return fmt.Errorf("failed to save auto-apply setting for %s: %v", actionType, err)
+			}
+		}
+		return nil
+	})
+}
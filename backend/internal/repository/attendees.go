@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"calendar-backend/internal/models"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FillOrganizer makes the owner of an event with attendees its organizer,
+// unless it already names one. Stored events are updated in place.
+func FillOrganizer(tx *gorm.DB, event *models.Event) error {
+	if len(event.Attendees) == 0 || event.Organizer != "" {
+		return nil
+	}
+	raw := tx.Session(&gorm.Session{NewDB: true})
+	var owner models.User
+	if err := raw.Select("email").First(&owner, "id = ?", event.UserID).Error; err != nil {
+		return fmt.Errorf("failed to load organizer: %v", err)
+	}
+	event.Organizer = owner.Email
+	if event.CreatedAt.IsZero() {
+		return nil
+	}
+	if err := raw.Model(event).UpdateColumn("organizer", event.Organizer).Error; err != nil {
+		return fmt.Errorf("failed to set organizer: %v", err)
+	}
+	return nil
+}
+
+// InvitationAccess returns a handle on tx that sees the rows of the owner of
+// the event id, provided email is one of its attendees. It returns
+// ErrNotFound otherwise, so that invitations don't reveal other events.
+func InvitationAccess(tx *gorm.DB, email, id string) (*gorm.DB, error) {
+	var event models.Event
+	if err := tx.Session(&gorm.Session{NewDB: true}).Limit(1).Find(&event, "id = ?", id).Error; err != nil {
+		return nil, fmt.Errorf("failed to load event: %v", err)
+	}
+	if event.ID == "" || event.Attendee(email) == nil {
+		return nil, ErrNotFound
+	}
+	return forOwner(tx, event.UserID), nil
+}
+
+// RespondToEvent records the participation status of the attendee email,
+// for the part of a recurring series selected by scope. It returns the row
+// holding the response, or ErrNotFound if email isn't invited to that part.
+func RespondToEvent(tx *gorm.DB, id, email string, status models.PartStat, scope Scope, recurrenceID *time.Time) (*models.Event, error) {
+	if !models.IsPartStat(status) {
+		invalid := &models.ValidationError{}
+		invalid.Add("status", "must be one of needs-action, accepted, declined, tentative")
+		return nil, invalid
+	}
+	target, scope, recurrenceID, err := resolveTarget(tx, id, scope, recurrenceID)
+	if err != nil {
+		return nil, err
+	}
+	// An occurrence that has been changed before keeps its own attendees.
+	if scope == ScopeThis && target.IsRecurring() {
+		var override models.Event
+		err := tx.Where("recurring_event_id = ? AND recurrence_id = ?", target.ID, recurrenceID.UTC()).
+			Limit(1).Find(&override).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to load occurrence: %v", err)
+		}
+		if override.ID != "" {
+			target = &override
+		}
+	}
+
+	attendees := append([]models.Attendee{}, target.Attendees...)
+	event := models.Event{Attendees: attendees}
+	attendee := event.Attendee(email)
+	if attendee == nil {
+		return nil, ErrNotFound
+	}
+	attendee.Status = status
+	// Map updates bypass the column's JSON serializer.
+	data, err := json.Marshal(attendees)
+	if err != nil {
+		return nil, err
+	}
+	return UpdateEvent(tx, target.ID, map[string]interface{}{"attendees": string(data)}, scope, recurrenceID)
+}
+
+// FindPeople returns the people whose name or email starts with query: users
+// with an account and the attendees of the events of the user userID. They
+// are sorted by email.
+func FindPeople(db *gorm.DB, userID, query string) ([]models.Attendee, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil, nil
+	}
+	pattern := "%" + escapeLike(query) + "%"
+	raw := db.Session(&gorm.Session{NewDB: true})
+
+	var users []models.User
+	err := raw.Where("LOWER(name) LIKE ? ESCAPE '\\' OR email LIKE ? ESCAPE '\\'", pattern, pattern).
+		Limit(100).Find(&users).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up users: %v", err)
+	}
+	var events []models.Event
+	err = forOwner(raw, userID).Select("attendees").
+		Where("LOWER(attendees) LIKE ? ESCAPE '\\'", pattern).
+		Order("updated_at DESC").Limit(500).Find(&events).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up attendees: %v", err)
+	}
+
+	found := map[string]models.Attendee{}
+	add := func(email, name string) {
+		if !matchesPerson(query, email, name) {
+			return
+		}
+		if person, ok := found[email]; !ok || person.Name == "" {
+			found[email] = models.Attendee{Email: email, Name: name}
+		}
+	}
+	for _, user := range users {
+		add(user.Email, user.Name)
+	}
+	for _, event := range events {
+		for _, attendee := range event.Attendees {
+			add(attendee.Email, attendee.Name)
+		}
+	}
+
+	people := make([]models.Attendee, 0, len(found))
+	for _, person := range found {
+		people = append(people, person)
+	}
+	sort.Slice(people, func(i, j int) bool { return people[i].Email < people[j].Email })
+	return people, nil
+}
+
+// matchesPerson reports whether query is the start of the full name, of a
+// word of the name or of a part of the email's local part, e.g. "sam" for
+// "Sam Lee" or "sam.lee@example.com".
+func matchesPerson(query, email, name string) bool {
+	name = strings.ToLower(name)
+	if strings.HasPrefix(name, query) || strings.HasPrefix(email, query) {
+		return true
+	}
+	local, _, _ := strings.Cut(email, "@")
+	words := strings.Fields(name)
+	words = append(words, strings.FieldsFunc(local, func(r rune) bool { return strings.ContainsRune("._-+", r) })...)
+	for _, word := range words {
+		if strings.HasPrefix(word, query) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package repository
+
+import (
+	"calendar-backend/internal/models"
+	"errors"
+	"fmt"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultAuditLimit = 50
+	MaxAuditLimit     = 500
+)
+
+// Audit records the changes made to events in a transaction. It snapshots
+// the series a change may touch beforehand so that Record can tell which
+// rows were created, updated, deleted, restored or purged.
+type Audit struct {
+	tx     *gorm.DB
+	roots  map[string]bool
+	before map[string]models.Event
+}
+
+// BeginAudit snapshots the series of the events ids: the series masters (an
+// override stands for its master) with all their overrides, deleted rows
+// included. IDs that don't exist yet are ignored.
+func BeginAudit(tx *gorm.DB, ids ...string) (*Audit, error) {
+	audit := &Audit{tx: tx, roots: map[string]bool{}}
+	if err := audit.addSeries(ids); err != nil {
+		return nil, err
+	}
+	var err error
+	if audit.before, err = audit.snapshot(); err != nil {
+		return nil, err
+	}
+	return audit, nil
+}
+
+// Record adds an audit entry on behalf of actor for every row of the
+// snapshot that changed, and for the rows of the series of ids, which is
+// how events created by the change (including split series) are picked up.
+func (a *Audit) Record(actor models.Actor, ids ...string) error {
+	if err := a.addSeries(ids); err != nil {
+		return err
+	}
+	after, err := a.snapshot()
+	if err != nil {
+		return err
+	}
+
+	var entries []models.AuditEntry
+	for id := range after {
+		row := after[id]
+		var before *models.Event
+		if stored, ok := a.before[id]; ok {
+			before = &stored
+		}
+		if entry := auditEntry(before, &row); entry != nil {
+			entries = append(entries, *entry)
+		}
+	}
+	for id := range a.before {
+		if _, ok := after[id]; !ok {
+			row := a.before[id]
+			entries = append(entries, *auditEntry(&row, nil))
+		}
+	}
+	// Masters first, so a series is created before its overrides.
+	sort.Slice(entries, func(i, j int) bool {
+		if (entries[i].SeriesID == "") != (entries[j].SeriesID == "") {
+			return entries[i].SeriesID == ""
+		}
+		return entries[i].EventID < entries[j].EventID
+	})
+	if err := recordEntries(a.tx, actor, entries); err != nil {
+		return err
+	}
+	return queueInvitations(a.tx, entries)
+}
+
+func (a *Audit) addSeries(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var rows []models.Event
+	if err := a.tx.Unscoped().Select("id", "recurring_event_id").Where("id IN ?", ids).Find(&rows).Error; err != nil {
+		return fmt.Errorf("failed to load events: %v", err)
+	}
+	for _, row := range rows {
+		if row.IsOverride() {
+			a.roots[row.RecurringEventID] = true
+		} else {
+			a.roots[row.ID] = true
+		}
+	}
+	return nil
+}
+
+func (a *Audit) snapshot() (map[string]models.Event, error) {
+	rows := map[string]models.Event{}
+	if len(a.roots) == 0 {
+		return rows, nil
+	}
+	roots := make([]string, 0, len(a.roots))
+	for root := range a.roots {
+		roots = append(roots, root)
+	}
+	var events []models.Event
+	if err := a.tx.Unscoped().Where("id IN ? OR recurring_event_id IN ?", roots, roots).Find(&events).Error; err != nil {
+		return nil, fmt.Errorf("failed to snapshot events: %v", err)
+	}
+	for _, event := range events {
+		rows[event.ID] = event
+	}
+	return rows, nil
+}
+
+// auditEntry describes how a row went from before to after, where nil means
+// the row didn't exist. It returns nil if the row is unchanged.
+func auditEntry(before, after *models.Event) *models.AuditEntry {
+	live := func(event *models.Event) bool {
+		return event != nil && !event.DeletedAt.Valid
+	}
+
+	entry := &models.AuditEntry{Before: before}
+	switch {
+	case after == nil:
+		entry.Action = models.AuditPurge
+	case !live(after):
+		if !live(before) {
+			return nil
+		}
+		entry.Action = models.AuditDelete
+	case before == nil:
+		entry.Action = models.AuditCreate
+	case !live(before):
+		entry.Action = models.AuditRestore
+	case !before.UpdatedAt.Equal(after.UpdatedAt):
+		entry.Action = models.AuditUpdate
+	default:
+		return nil
+	}
+	if live(after) {
+		entry.After = after
+	}
+
+	row := after
+	if row == nil {
+		row = before
+	}
+	entry.EventID = row.ID
+	entry.SeriesID = row.RecurringEventID
+	entry.UserID = row.UserID
+	return entry
+}
+
+func recordEntries(tx *gorm.DB, actor models.Actor, entries []models.AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	for i := range entries {
+		entries[i].Actor = actor
+	}
+	if err := tx.Create(&entries).Error; err != nil {
+		return fmt.Errorf("failed to record audit entries: %v", err)
+	}
+	return nil
+}
+
+// EventHistory returns the audit entries of an event, oldest first. The
+// history of a series includes that of its overrides. It returns ErrNotFound
+// if the event never existed.
+func EventHistory(db *gorm.DB, id string) ([]models.AuditEntry, error) {
+	entries := []models.AuditEntry{}
+	if err := db.Where("event_id = ? OR series_id = ?", id, id).Order("id").Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to load history: %v", err)
+	}
+	if len(entries) == 0 {
+		var event models.Event
+		err := db.Unscoped().Select("id").First(&event, "id = ?", id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load event: %v", err)
+		}
+	}
+	return entries, nil
+}
+
+// AuditFilter narrows down the audit feed. Before pages through it: only
+// entries recorded before the entry with that ID are returned.
+type AuditFilter struct {
+	ActorType      string
+	ConversationID string
+	Before         uint
+	Limit          int
+}
+
+// ListAudit returns the audit feed, newest first.
+func ListAudit(db *gorm.DB, filter AuditFilter) ([]models.AuditEntry, error) {
+	query := db.Order("id DESC")
+	if filter.ActorType != "" {
+		query = query.Where("actor_type = ?", filter.ActorType)
+	}
+	if filter.ConversationID != "" {
+		query = query.Where("actor_conversation_id = ?", filter.ConversationID)
+	}
+	if filter.Before > 0 {
+		query = query.Where("id < ?", filter.Before)
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultAuditLimit
+	}
+	if limit > MaxAuditLimit {
+		limit = MaxAuditLimit
+	}
+
+	entries := []models.AuditEntry{}
+	if err := query.Limit(limit).Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to load audit log: %v", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,197 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TimeSlot is a half-open [Start, End) interval.
+type TimeSlot struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// WorkingHours restricts free slots to a daily wall-clock window, e.g.
+// 09:00-17:00, on some days of the week.
+type WorkingHours struct {
+	Start time.Duration // since midnight
+	End   time.Duration
+	Days  [7]bool // indexed by time.Weekday
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseWorkingHours reads working hours such as "09:00-17:00" (every day),
+// "Mon-Fri 09:00-17:00" or "Mon,Wed,Fri 10:00-16:00".
+func ParseWorkingHours(value string) (*WorkingHours, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid working hours %q, expected e.g. \"Mon-Fri 09:00-17:00\"", value)
+	}
+
+	hours := &WorkingHours{}
+	if len(fields) == 1 {
+		for day := range hours.Days {
+			hours.Days[day] = true
+		}
+	} else if err := hours.parseDays(strings.ToLower(fields[0])); err != nil {
+		return nil, err
+	}
+
+	start, end, ok := strings.Cut(fields[len(fields)-1], "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid working hours %q, expected e.g. \"09:00-17:00\"", value)
+	}
+	var err error
+	if hours.Start, err = parseClock(start); err != nil {
+		return nil, err
+	}
+	if hours.End, err = parseClock(end); err != nil {
+		return nil, err
+	}
+	if hours.End <= hours.Start {
+		return nil, fmt.Errorf("working hours must end after they start")
+	}
+	return hours, nil
+}
+
+// parseClock reads a time of day like "09:30", or "24:00" for the end of the day.
+func parseClock(value string) (time.Duration, error) {
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q in working hours", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseDays reads comma-separated days and day ranges like "mon-fri".
+func (h *WorkingHours) parseDays(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		from, ok := weekdays[first]
+		if !ok {
+			return fmt.Errorf("invalid day %q in working hours", first)
+		}
+		to := from
+		if isRange {
+			if to, ok = weekdays[last]; !ok {
+				return fmt.Errorf("invalid day %q in working hours", last)
+			}
+		}
+		for day := from; ; day = (day + 1) % 7 {
+			h.Days[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// periods returns the working periods overlapping [from, to), with the wall
+// clock of the working hours taken in loc.
+func (h *WorkingHours) periods(from, to time.Time, loc *time.Location) []TimeSlot {
+	var periods []TimeSlot
+	local := from.In(loc)
+	for day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc); day.Before(to); day = day.AddDate(0, 0, 1) {
+		if !h.Days[day.Weekday()] {
+			continue
+		}
+		// time.Date keeps the wall clock on DST changes, unlike adding
+		// the offset to midnight.
+		start := time.Date(day.Year(), day.Month(), day.Day(), 0, int(h.Start/time.Minute), 0, 0, loc)
+		end := time.Date(day.Year(), day.Month(), day.Day(), 0, int(h.End/time.Minute), 0, 0, loc)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			periods = append(periods, TimeSlot{Start: start, End: end})
+		}
+	}
+	return periods
+}
+
+// FreeSlots returns the gaps of at least minDuration between the busy events
+// (including expanded recurring occurrences) in the [from, to) window, on all
+// the calendars the user can see, including those shared as free/busy. When
+// hours is set, only gaps within the working hours count. loc is the caller's
+// time zone, which working hours and floating events are taken in.
+func (a *Access) FreeSlots(db *gorm.DB, from, to time.Time, minDuration time.Duration, hours *WorkingHours, loc *time.Location) ([]TimeSlot, error) {
+	// Floating events are stored by their wall clock, so widen the search
+	// to catch those that fall within the window in loc.
+	queryFrom, queryTo := from.Add(-24*time.Hour), to.Add(24*time.Hour)
+	events, err := a.FindEvents(db, EventFilter{From: &queryFrom, To: &queryTo})
+	if err != nil {
+		return nil, err
+	}
+
+	busy := make([]TimeSlot, 0, len(events))
+	for _, event := range events {
+		if !event.IsBusy() {
+			continue
+		}
+		slot := TimeSlot{Start: event.Start, End: event.End}
+		if event.Floating {
+			slot = TimeSlot{Start: floatingInstant(event.Start, loc), End: floatingInstant(event.End, loc)}
+		}
+		busy = append(busy, slot)
+	}
+
+	if hours == nil {
+		return freeBetween(busy, from, to, minDuration), nil
+	}
+	free := []TimeSlot{}
+	for _, period := range hours.periods(from, to, loc) {
+		free = append(free, freeBetween(busy, period.Start, period.End, minDuration)...)
+	}
+	return free, nil
+}
+
+// floatingInstant maps the wall clock of a floating time onto loc.
+func floatingInstant(wall time.Time, loc *time.Location) time.Time {
+	wall = wall.UTC()
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, loc)
+}
+
+// freeBetween returns the gaps of at least minDuration left in [from, to)
+// by the busy intervals, which may overlap and be in any order.
+func freeBetween(busy []TimeSlot, from, to time.Time, minDuration time.Duration) []TimeSlot {
+	sort.Slice(busy, func(i, j int) bool { return busy[i].Start.Before(busy[j].Start) })
+
+	free := []TimeSlot{}
+	cursor := from
+	for _, slot := range busy {
+		if slot.Start.After(cursor) {
+			end := slot.Start
+			if end.After(to) {
+				end = to
+			}
+			if end.Sub(cursor) >= minDuration {
+				free = append(free, TimeSlot{Start: cursor, End: end})
+			}
+		}
+		if slot.End.After(cursor) {
+			cursor = slot.End
+		}
+		if !cursor.Before(to) {
+			return free
+		}
+	}
+	if cursor.Before(to) && to.Sub(cursor) >= minDuration {
+		free = append(free, TimeSlot{Start: cursor, End: to})
+	}
+	return free
+}
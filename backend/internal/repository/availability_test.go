@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"calendar-backend/internal/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFreeSlotsSharedCalendars(t *testing.T) {
+	db, own := testDB(t)
+	at := func(hour int) time.Time { return time.Date(2026, 10, 20, hour, 0, 0, 0, time.UTC) }
+	calendars := map[string]*models.Calendar{"user-1": own}
+	for _, userID := range []string{"user-2", "user-3"} {
+		calendar, err := DefaultCalendar(db, userID)
+		if err != nil {
+			t.Fatalf("failed to create calendar: %v", err)
+		}
+		calendars[userID] = calendar
+	}
+	// Only free/busy access is needed for an event to count as busy.
+	share := models.CalendarShare{ID: "share", CalendarID: calendars["user-2"].ID, UserID: "user-1", Role: models.RoleFreeBusy}
+	if err := db.Create(&share).Error; err != nil {
+		t.Fatalf("failed to share calendar: %v", err)
+	}
+	events := []models.Event{
+		{ID: "own", UserID: "user-1", CalendarID: own.ID, Start: at(9), End: at(10)},
+		{ID: "shared", UserID: "user-2", CalendarID: calendars["user-2"].ID, Start: at(11), End: at(12)},
+		{ID: "transparent", UserID: "user-2", CalendarID: calendars["user-2"].ID, Start: at(12), End: at(13), Transparent: true},
+		{ID: "unshared", UserID: "user-3", CalendarID: calendars["user-3"].ID, Start: at(13), End: at(14)},
+	}
+	if err := db.Create(&events).Error; err != nil {
+		t.Fatalf("failed to create events: %v", err)
+	}
+
+	access, err := LoadAccess(db, "user-1")
+	if err != nil {
+		t.Fatalf("failed to load access: %v", err)
+	}
+	slots, err := access.FreeSlots(forOwner(db, "user-1"), at(8), at(15), time.Hour, nil, time.UTC)
+	if err != nil {
+		t.Fatalf("failed to find free slots: %v", err)
+	}
+	want := []TimeSlot{{at(8), at(9)}, {at(10), at(11)}, {at(12), at(15)}}
+	if !reflect.DeepEqual(slots, want) {
+		t.Errorf("got %v, want %v", slots, want)
+	}
+}
@@ -0,0 +1,264 @@
+package repository
+
+import (
+	"calendar-backend/internal/models"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultCalendarName names the calendar every user starts with.
+	DefaultCalendarName = "Calendar"
+	// DefaultColor is given to calendars created without an explicit color.
+	DefaultColor = "var(--tokyo-purple)"
+)
+
+var (
+	ErrCalendarNotFound = errors.New("calendar not found")
+	ErrDefaultCalendar  = errors.New("the default calendar can't be deleted; make another calendar the default first")
+)
+
+// GetCalendar returns a calendar by ID, or ErrCalendarNotFound.
+func GetCalendar(db *gorm.DB, id string) (*models.Calendar, error) {
+	var calendar models.Calendar
+	if err := db.First(&calendar, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCalendarNotFound
+		}
+		return nil, fmt.Errorf("failed to load calendar: %v", err)
+	}
+	return &calendar, nil
+}
+
+// DefaultCalendar returns the user's default calendar, creating it if the
+// user has none yet.
+func DefaultCalendar(db *gorm.DB, userID string) (*models.Calendar, error) {
+	var calendar models.Calendar
+	err := db.Where("user_id = ? AND is_default", userID).Limit(1).Find(&calendar).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load default calendar: %v", err)
+	}
+	if calendar.ID != "" {
+		return &calendar, nil
+	}
+
+	calendar = models.Calendar{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Name:      DefaultCalendarName,
+		Color:     DefaultColor,
+		IsDefault: true,
+	}
+	if err := db.Create(&calendar).Error; err != nil {
+		return nil, fmt.Errorf("failed to create default calendar: %v", err)
+	}
+	return &calendar, nil
+}
+
+// CreateCalendar validates and stores a new calendar of the user. Calendars
+// without a color get DefaultColor.
+func CreateCalendar(tx *gorm.DB, userID string, calendar *models.Calendar) error {
+	calendar.ID = uuid.New().String()
+	calendar.UserID = userID
+	calendar.Name = strings.TrimSpace(calendar.Name)
+	if calendar.Color == "" {
+		calendar.Color = DefaultColor
+	}
+	if err := checkCalendar(tx, calendar); err != nil {
+		return err
+	}
+	if calendar.IsDefault {
+		if err := clearDefault(tx); err != nil {
+			return err
+		}
+	}
+	if err := tx.Create(calendar).Error; err != nil {
+		return fmt.Errorf("failed to create calendar: %v", err)
+	}
+	return nil
+}
+
+// UpdateCalendar stores the changed settings of a calendar. Making it the
+// default takes that role from the user's current default calendar.
+func UpdateCalendar(tx *gorm.DB, calendar *models.Calendar) error {
+	current, err := GetCalendar(tx, calendar.ID)
+	if err != nil {
+		return err
+	}
+	calendar.Name = strings.TrimSpace(calendar.Name)
+	if err := checkCalendar(tx, calendar); err != nil {
+		return err
+	}
+	if current.IsDefault && !calendar.IsDefault {
+		invalid := &models.ValidationError{}
+		invalid.Add("isDefault", "can only be moved by making another calendar the default")
+		return invalid
+	}
+	if calendar.IsDefault && !current.IsDefault {
+		if err := clearDefault(tx); err != nil {
+			return err
+		}
+	}
+	err = tx.Model(current).
+		Select("name", "color", "default_reminder", "time_zone", "hidden", "is_default").
+		Updates(calendar).Error
+	if err != nil {
+		return fmt.Errorf("failed to update calendar: %v", err)
+	}
+	updated, err := GetCalendar(tx, calendar.ID)
+	if err != nil {
+		return err
+	}
+	*calendar = *updated
+	return nil
+}
+
+// checkCalendar validates a calendar and makes sure no other calendar of the
+// user has its name, so that calendars can be told apart by name.
+func checkCalendar(tx *gorm.DB, calendar *models.Calendar) error {
+	if err := calendar.Validate(); err != nil {
+		return err
+	}
+	var count int64
+	err := tx.Model(&models.Calendar{}).
+		Where("LOWER(name) = ? AND id <> ?", strings.ToLower(calendar.Name), calendar.ID).
+		Count(&count).Error
+	if err != nil {
+		return fmt.Errorf("failed to look up calendar name: %v", err)
+	}
+	if count > 0 {
+		invalid := &models.ValidationError{}
+		invalid.Add("name", "is already used by another calendar")
+		return invalid
+	}
+	return nil
+}
+
+func clearDefault(tx *gorm.DB) error {
+	if err := tx.Model(&models.Calendar{}).Where("is_default").Update("is_default", false).Error; err != nil {
+		return fmt.Errorf("failed to unset default calendar: %v", err)
+	}
+	return nil
+}
+
+// CalendarEventIDs returns the IDs of the events and series of a calendar,
+// deleted ones included, e.g. to audit changes to all of them.
+func CalendarEventIDs(tx *gorm.DB, id string) ([]string, error) {
+	var ids []string
+	err := tx.Unscoped().Model(&models.Event{}).
+		Where("calendar_id = ? AND recurring_event_id = ''", id).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events of calendar: %v", err)
+	}
+	return ids, nil
+}
+
+// DeleteCalendar deletes a calendar other than the default one. Its events
+// are moved to the calendar moveTo or, if that is empty, moved to the
+// default calendar and put in the trash, so that they can still be restored.
+func DeleteCalendar(tx *gorm.DB, id, moveTo string) error {
+	calendar, err := GetCalendar(tx, id)
+	if err != nil {
+		return err
+	}
+	if calendar.IsDefault {
+		return ErrDefaultCalendar
+	}
+
+	var target *models.Calendar
+	switch moveTo {
+	case "":
+		target, err = DefaultCalendar(tx, calendar.UserID)
+	case id:
+		err = ErrCalendarNotFound
+	default:
+		target, err = GetCalendar(tx, moveTo)
+	}
+	if errors.Is(err, ErrCalendarNotFound) {
+		invalid := &models.ValidationError{}
+		invalid.Add("moveTo", "must be another of your calendars")
+		return invalid
+	}
+	if err != nil {
+		return err
+	}
+
+	var live []string
+	if moveTo == "" {
+		err := tx.Model(&models.Event{}).
+			Where("calendar_id = ? AND recurring_event_id = ''", id).
+			Pluck("id", &live).Error
+		if err != nil {
+			return fmt.Errorf("failed to list events of calendar: %v", err)
+		}
+	}
+	err = tx.Unscoped().Model(&models.Event{}).Where("calendar_id = ?", id).Update("calendar_id", target.ID).Error
+	if err != nil {
+		return fmt.Errorf("failed to move events of calendar: %v", err)
+	}
+	for _, eventID := range live {
+		if err := DeleteEvent(tx, eventID, ScopeAll, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Session(&gorm.Session{NewDB: true}).Where("calendar_id = ?", id).Delete(&models.CalendarShare{}).Error; err != nil {
+		return fmt.Errorf("failed to delete shares of calendar: %v", err)
+	}
+	if err := tx.Delete(calendar).Error; err != nil {
+		return fmt.Errorf("failed to delete calendar: %v", err)
+	}
+	return nil
+}
+
+// AssignCalendar puts a new event into its calendar: the one it names, or
+// the user's default calendar if it names none. Events without a color get
+// the calendar's. It returns the calendar.
+func AssignCalendar(tx *gorm.DB, event *models.Event) (*models.Calendar, error) {
+	var calendar *models.Calendar
+	var err error
+	if event.CalendarID == "" {
+		calendar, err = DefaultCalendar(tx, event.UserID)
+	} else {
+		calendar, err = GetCalendar(tx, event.CalendarID)
+		if errors.Is(err, ErrCalendarNotFound) {
+			invalid := &models.ValidationError{}
+			invalid.Add("calendarId", "does not exist")
+			return nil, invalid
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	event.CalendarID = calendar.ID
+	if event.Color == "" {
+		event.Color = calendar.Color
+	}
+	return calendar, nil
+}
+
+// moveSeriesCalendar checks the calendar of a series master after an update
+// and moves the series' overrides along with it.
+func moveSeriesCalendar(tx *gorm.DB, master *models.Event) error {
+	if _, err := GetCalendar(tx, master.CalendarID); err != nil {
+		if errors.Is(err, ErrCalendarNotFound) {
+			invalid := &models.ValidationError{}
+			invalid.Add("calendarId", "does not exist")
+			return invalid
+		}
+		return err
+	}
+	err := tx.Unscoped().Model(&models.Event{}).
+		Where("recurring_event_id = ? AND calendar_id <> ?", master.ID, master.CalendarID).
+		Update("calendar_id", master.CalendarID).Error
+	if err != nil {
+		return fmt.Errorf("failed to move occurrences to calendar: %v", err)
+	}
+	return nil
+}
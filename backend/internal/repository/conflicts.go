@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"calendar-backend/internal/models"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// conflictHorizon bounds how far ahead the occurrences of a recurring event
+// are checked for conflicts.
+const conflictHorizon = 366 * 24 * time.Hour
+
+// ConflictError reports the busy events an event would overlap.
+type ConflictError struct {
+	Conflicts []models.Event
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("event overlaps %d existing event(s)", len(e.Conflicts))
+}
+
+// FindConflicts returns the busy events (occurrences for recurring series)
+// overlapping the event, or any of its occurrences within the next year.
+// Events of the event's own series never conflict with it, and neither do
+// transparent or all-day events. The lookup runs against db so that it sees
+// the changes of the current transaction.
+func FindConflicts(db *gorm.DB, event *models.Event) ([]models.Event, error) {
+	if !event.IsBusy() || !event.End.After(event.Start) {
+		return nil, nil
+	}
+
+	slots := []TimeSlot{{Start: event.Start, End: event.End}}
+	if event.IsRecurring() {
+		occurrences, err := ExpandEvent(*event, event.Start, event.Start.Add(conflictHorizon), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand event: %v", err)
+		}
+		slots = slots[:0]
+		for _, occurrence := range occurrences {
+			slots = append(slots, TimeSlot{Start: occurrence.Start, End: occurrence.End})
+		}
+		if len(slots) == 0 {
+			return nil, nil
+		}
+	}
+
+	from, to := slots[0].Start, slots[len(slots)-1].End
+	existing, err := findEvents(db, EventFilter{From: &from, To: &to})
+	if err != nil {
+		return nil, err
+	}
+
+	series := event.ID
+	if event.IsOverride() {
+		series = event.RecurringEventID
+	}
+	var conflicts []models.Event
+	for _, other := range existing {
+		if !other.IsBusy() || (series != "" && (other.ID == series || other.RecurringEventID == series)) {
+			continue
+		}
+		// Both lists are ordered by start time; slots ending before other
+		// starts can't overlap it.
+		i := sort.Search(len(slots), func(i int) bool { return slots[i].End.After(other.Start) })
+		if i < len(slots) && slots[i].Start.Before(other.End) {
+			conflicts = append(conflicts, other)
+		}
+	}
+	return conflicts, nil
+}
+
+// CheckConflicts returns a *ConflictError if the event overlaps busy events.
+func CheckConflicts(db *gorm.DB, event *models.Event) error {
+	conflicts, err := FindConflicts(db, event)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return &ConflictError{Conflicts: conflicts}
+	}
+	return nil
+}
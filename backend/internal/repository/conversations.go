@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"calendar-backend/internal/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrConversationNotFound is returned when a conversation ID doesn't exist.
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// maxTitleLength bounds conversation titles derived from the first message.
+const maxTitleLength = 60
+
+// CreateConversation starts a new conversation titled after its first message.
+func CreateConversation(db *gorm.DB, userID, firstMessage string) (*models.Conversation, error) {
+	title := []rune(firstMessage)
+	if len(title) > maxTitleLength {
+		title = append(title[:maxTitleLength-1], '…')
+	}
+
+	conversation := &models.Conversation{
+		ID:     uuid.New().String(),
+		UserID: userID,
+		Title:  string(title),
+	}
+	if err := db.Create(conversation).Error; err != nil {
+		return nil, fmt.Errorf("failed to create conversation: %v", err)
+	}
+	return conversation, nil
+}
+
+// ListConversations returns all conversations, most recently active first,
+// without their messages.
+func ListConversations(db *gorm.DB) ([]models.Conversation, error) {
+	var conversations []models.Conversation
+	if err := db.Order("updated_at DESC").Find(&conversations).Error; err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %v", err)
+	}
+	return conversations, nil
+}
+
+// GetConversation returns a conversation with its full message history.
+func GetConversation(db *gorm.DB, id string) (*models.Conversation, error) {
+	var conversation models.Conversation
+	err := db.Preload("Messages", func(query *gorm.DB) *gorm.DB {
+		return query.Order("created_at")
+	}).First(&conversation, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load conversation: %v", err)
+	}
+	return &conversation, nil
+}
+
+// RecentMessages returns up to limit of the latest messages of a conversation
+// in chronological order.
+func RecentMessages(db *gorm.DB, conversationID string, limit int) ([]models.ChatMessage, error) {
+	var messages []models.ChatMessage
+	err := db.Where("conversation_id = ?", conversationID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&messages).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load messages: %v", err)
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// AppendMessages stores messages at the end of a conversation, keeping their
+// order, and marks the conversation as updated. Messages get an ID unless
+// they already have one, and belong to the owner of the conversation.
+func AppendMessages(db *gorm.DB, conversationID string, messages ...*models.ChatMessage) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var conversation models.Conversation
+		err := tx.Select("id", "user_id").First(&conversation, "id = ?", conversationID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrConversationNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load conversation: %v", err)
+		}
+
+		now := time.Now()
+		for i, message := range messages {
+			if message.ID == "" {
+				message.ID = uuid.New().String()
+			}
+			message.ConversationID = conversationID
+			message.UserID = conversation.UserID
+			// Keep a strict order even when both turns are saved in the same instant.
+			message.CreatedAt = now.Add(time.Duration(i) * time.Microsecond)
+			if err := tx.Create(message).Error; err != nil {
+				return fmt.Errorf("failed to save message: %v", err)
+			}
+		}
+		err = tx.Model(&conversation).Update("updated_at", now).Error
+		if err != nil {
+			return fmt.Errorf("failed to update conversation: %v", err)
+		}
+		return nil
+	})
+}
+
+// DeleteConversation removes a conversation and its messages.
+func DeleteConversation(db *gorm.DB, id string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("conversation_id = ?", id).Delete(&models.ChatMessage{}).Error; err != nil {
+			return fmt.Errorf("failed to delete messages: %v", err)
+		}
+		result := tx.Delete(&models.Conversation{}, "id = ?", id)
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete conversation: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrConversationNotFound
+		}
+		return nil
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"calendar-backend/internal/models"
 	"fmt"
 	"log"
+	"os"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -31,11 +32,34 @@ func InitDB() error {
 		return fmt.Errorf("failed to enable foreign keys: %v", err)
 	}
 
+	// Auto-apply settings used to be global, keyed by action type alone.
+	// SQLite can't change a primary key in place, so keep the settings and
+	// recreate the table.
+	var globalSettings []models.AutoApplySetting
+	migrator := DB.Migrator()
+	if migrator.HasTable(&models.AutoApplySetting{}) && !migrator.HasColumn(&models.AutoApplySetting{}, "user_id") {
+		if err := DB.Table("auto_apply_settings").Select("action_type", "auto_apply").Find(&globalSettings).Error; err != nil {
+			return fmt.Errorf("failed to read auto-apply settings: %v", err)
+		}
+		if err := migrator.DropTable(&models.AutoApplySetting{}); err != nil {
+			return fmt.Errorf("failed to drop auto-apply settings: %v", err)
+		}
+	}
+
 	// Auto migrate the schema
 	log.Println("Migrating database schema...")
-	if err := DB.AutoMigrate(&models.Event{}); err != nil {
+	if err := DB.AutoMigrate(&models.Migration{}, &models.User{}, &models.Session{}, &models.APIToken{}, &models.Calendar{}, &models.CalendarShare{}, &models.Event{}, &models.Conversation{}, &models.ChatMessage{}, &models.PendingAction{}, &models.AutoApplySetting{}, &models.AuditEntry{}, &models.OutgoingMail{}); err != nil {
 		return fmt.Errorf("failed to migrate database: %v", err)
 	}
+	if err := runOnce("utc-event-times", normalizeTimes); err != nil {
+		return err
+	}
+	if err := assignOrphans(globalSettings); err != nil {
+		return err
+	}
+	if err := assignCalendars(); err != nil {
+		return err
+	}
 
 	// Test database connection
 	var count int64
@@ -46,3 +70,149 @@ func InitDB() error {
 
 	return nil
 }
+
+// ownedTables hold per-user rows, which had no owner before accounts existed.
+var ownedTables = []string{"events", "conversations", "chat_messages", "pending_actions", "audit_entries"}
+
+// assignOrphans makes sure there is at least one user and gives the rows
+// that have no owner, i.e. those stored before accounts existed, to the
+// oldest user, along with the former global auto-apply settings.
+func assignOrphans(settings []models.AutoApplySetting) error {
+	owner, err := DefaultUser()
+	if err != nil {
+		return err
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, table := range ownedTables {
+			result := tx.Table(table).Where("user_id = '' OR user_id IS NULL").Update("user_id", owner.ID)
+			if result.Error != nil {
+				return fmt.Errorf("failed to assign %s to %s: %v", table, owner.Email, result.Error)
+			}
+			if result.RowsAffected > 0 {
+				log.Printf("Assigned %d rows of %s to %s", result.RowsAffected, table, owner.Email)
+			}
+		}
+		for _, setting := range settings {
+			setting.UserID = owner.ID
+			if err := tx.Create(&setting).Error; err != nil {
+				return fmt.Errorf("failed to migrate auto-apply setting for %s: %v", setting.ActionType, err)
+			}
+		}
+		return nil
+	})
+}
+
+// assignCalendars puts the events that belong to no calendar, i.e. those
+// stored before calendars existed, into the default calendar of their owner.
+func assignCalendars() error {
+	var userIDs []string
+	err := DB.Unscoped().Model(&models.Event{}).
+		Where("calendar_id = '' OR calendar_id IS NULL").
+		Distinct().Pluck("user_id", &userIDs).Error
+	if err != nil {
+		return fmt.Errorf("failed to find events without a calendar: %v", err)
+	}
+	for _, userID := range userIDs {
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			calendar, err := DefaultCalendar(tx, userID)
+			if err != nil {
+				return err
+			}
+			result := tx.Unscoped().Model(&models.Event{}).
+				Where("user_id = ? AND (calendar_id = '' OR calendar_id IS NULL)", userID).
+				UpdateColumn("calendar_id", calendar.ID)
+			if result.Error != nil {
+				return fmt.Errorf("failed to assign events to calendar: %v", result.Error)
+			}
+			log.Printf("Assigned %d events of user %s to calendar %q", result.RowsAffected, userID, calendar.Name)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runOnce applies a one-off data migration unless it has been applied
+// before, and records it in the same transaction.
+func runOnce(name string, migrate func(tx *gorm.DB) error) error {
+	var count int64
+	if err := DB.Model(&models.Migration{}).Where("name = ?", name).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to look up migration %s: %v", name, err)
+	}
+	if count > 0 {
+		return nil
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := migrate(tx); err != nil {
+			return err
+		}
+		if err := tx.Create(&models.Migration{Name: name}).Error; err != nil {
+			return fmt.Errorf("failed to record migration %s: %v", name, err)
+		}
+		return nil
+	})
+}
+
+// normalizeTimes converts the times of events stored with the offset they
+// were sent with, i.e. before times were kept in UTC, to UTC. SQLite compares
+// timestamps as text, so range queries would match them wrongly otherwise.
+func normalizeTimes(tx *gorm.DB) error {
+	var events []models.Event
+	err := tx.Unscoped().Select("id", "start", "end").
+		Where("`start` NOT LIKE '%+00:00' OR `end` NOT LIKE '%+00:00'").
+		Find(&events).Error
+	if err != nil {
+		return fmt.Errorf("failed to find events with local times: %v", err)
+	}
+	for _, event := range events {
+		err := tx.Unscoped().Model(&models.Event{}).Where("id = ?", event.ID).
+			UpdateColumns(map[string]interface{}{"start": event.Start.UTC(), "end": event.End.UTC()}).Error
+		if err != nil {
+			return fmt.Errorf("failed to convert times of event %s to UTC: %v", event.ID, err)
+		}
+	}
+	if len(events) > 0 {
+		log.Printf("Converted the times of %d events to UTC", len(events))
+	}
+	return nil
+}
+
+// DefaultUser returns the oldest user, creating one from DEFAULT_USER_EMAIL
+// and DEFAULT_USER_PASSWORD if there are no users yet. Without a password a
+// random one is generated and printed to stderr, only this once and outside
+// of the log.
+func DefaultUser() (*models.User, error) {
+	var user models.User
+	err := DB.Order("created_at").Limit(1).Find(&user).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load users: %v", err)
+	}
+	if user.ID != "" {
+		return &user, nil
+	}
+
+	email := os.Getenv("DEFAULT_USER_EMAIL")
+	if email == "" {
+		email = "admin@localhost"
+	}
+	password := os.Getenv("DEFAULT_USER_PASSWORD")
+	generated := password == ""
+	if generated {
+		if password, err = newToken(""); err != nil {
+			return nil, err
+		}
+	}
+	created, err := CreateUser(email, "", password)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Created default user %s", created.Email)
+	if generated {
+		fmt.Fprintf(os.Stderr, "\nLog in as %s with the password %s\n"+
+			"It won't be shown again: change it with PUT /api/auth/password, or set\n"+
+			"DEFAULT_USER_PASSWORD before creating the database.\n\n", created.Email, password)
+	}
+	return created, nil
+}
@@ -0,0 +1,248 @@
+package repository
+
+import (
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/recurrence"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EventFilter narrows down which events are returned by FindEvents.
+// Zero values mean "no restriction". Floating and all-day events are matched
+// against From/To by their wall clock, as if it were UTC.
+type EventFilter struct {
+	From         *time.Time
+	To           *time.Time
+	Color        string
+	Title        string
+	UpdatedSince *time.Time
+	// CalendarIDs restricts the events to those of the given calendars.
+	CalendarIDs []string
+	// HiddenCalendarIDs leaves out the events of the given calendars.
+	HiddenCalendarIDs []string
+	// Attendee restricts the events to those the given email is invited to.
+	Attendee string
+}
+
+// HasWindow reports whether both ends of the date range are set, which is
+// required to expand recurring events into occurrences.
+func (f EventFilter) HasWindow() bool {
+	return f.From != nil && f.To != nil
+}
+
+// FindEventRows returns the stored rows matching the filter ordered by start
+// time, without expanding recurring series. Non-recurring events and
+// overrides are selected when they overlap the From/To window; series
+// masters when they start before To.
+func FindEventRows(db *gorm.DB, filter EventFilter) ([]models.Event, error) {
+	single, masters, err := findRows(db, filter)
+	if err != nil {
+		return nil, err
+	}
+	events := append(single, masters...)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+	return events, nil
+}
+
+// FindEvents returns the events matching the filter ordered by start time.
+// From/To select events overlapping the window rather than events fully inside it.
+// When both are set, recurring series are expanded into their occurrences
+// inside the window; otherwise the series masters are returned as stored.
+func FindEvents(db *gorm.DB, filter EventFilter) ([]models.Event, error) {
+	if !filter.HasWindow() {
+		return FindEventRows(db, filter)
+	}
+	return findEvents(db, filter)
+}
+
+// findEvents is FindEvents for a windowed filter, run against db so it can
+// be used inside a transaction.
+func findEvents(db *gorm.DB, filter EventFilter) ([]models.Event, error) {
+	single, masters, err := findRows(db, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	overridden, err := overriddenOccurrences(db, masters)
+	if err != nil {
+		return nil, err
+	}
+
+	events := single
+	for _, master := range masters {
+		occurrences, err := ExpandEvent(master, *filter.From, *filter.To, overridden[master.ID])
+		if err != nil {
+			// A broken rule shouldn't hide the rest of the calendar.
+			log.Printf("Skipping recurring event %s: %v", master.ID, err)
+			continue
+		}
+		events = append(events, occurrences...)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+	return events, nil
+}
+
+// GetEvent returns the stored row with the given ID, or ErrNotFound.
+func GetEvent(db *gorm.DB, id string) (*models.Event, error) {
+	var event models.Event
+	if err := db.First(&event, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to load event: %v", err)
+	}
+	return &event, nil
+}
+
+// LastChange returns the time of the most recent create, update or delete
+// along with the number of stored rows, which together identify the current
+// state of the calendar (e.g. for CalDAV's getctag).
+func LastChange(db *gorm.DB) (time.Time, int64, error) {
+	var state struct {
+		Updated *string
+		Deleted *string
+		Count   int64
+	}
+	err := db.Unscoped().Model(&models.Event{}).
+		Select("MAX(updated_at) AS updated, MAX(deleted_at) AS deleted, COUNT(*) AS count").
+		Scan(&state).Error
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("failed to query calendar state: %v", err)
+	}
+
+	var last time.Time
+	for _, value := range []*string{state.Updated, state.Deleted} {
+		if value == nil {
+			continue
+		}
+		if t, err := parseSQLiteTime(*value); err == nil && t.After(last) {
+			last = t
+		}
+	}
+	return last, state.Count, nil
+}
+
+// parseSQLiteTime parses timestamps returned by aggregates, which the SQLite
+// driver hands back as plain strings rather than time.Time values.
+func parseSQLiteTime(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05.999999999", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised timestamp %q", value)
+}
+
+func findRows(db *gorm.DB, filter EventFilter) (single []models.Event, masters []models.Event, err error) {
+	query := applyEventFilter(db.Model(&models.Event{}), filter).Where("rrule = ''")
+	if filter.From != nil {
+		query = query.Where("`end` > ?", filter.From.UTC())
+	}
+	if filter.To != nil {
+		query = query.Where("`start` < ?", filter.To.UTC())
+	}
+	if err := query.Find(&single).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to query events: %v", err)
+	}
+
+	query = applyEventFilter(db.Model(&models.Event{}), filter).Where("rrule <> ''")
+	if filter.To != nil {
+		query = query.Where("`start` < ?", filter.To.UTC())
+	}
+	if err := query.Find(&masters).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to query recurring events: %v", err)
+	}
+	return single, masters, nil
+}
+
+// ExpandEvent returns one copy of the master event per occurrence overlapping
+// the [from, to) window, with Start/End shifted and RecurrenceID set.
+// Occurrences listed in skip (typically those replaced by overrides) are left out
+// along with the master's own exclusions.
+func ExpandEvent(master models.Event, from, to time.Time, skip []time.Time) ([]models.Event, error) {
+	rule, err := recurrence.Parse(master.RRule)
+	if err != nil {
+		return nil, err
+	}
+
+	duration := master.End.Sub(master.Start)
+	exclusions := append(append([]time.Time{}, master.ExDates...), skip...)
+	var occurrences []models.Event
+	// Expand in the event's zone so occurrences keep their wall-clock time across DST.
+	for _, start := range rule.Between(master.Start.In(master.Location()), duration, from, to, exclusions) {
+		start = start.UTC()
+		occurrence := master
+		recurrenceID := start
+		occurrence.Start = start
+		occurrence.End = start.Add(duration)
+		occurrence.RecurrenceID = &recurrenceID
+		occurrences = append(occurrences, occurrence)
+	}
+	return occurrences, nil
+}
+
+// overriddenOccurrences maps each master ID to the recurrence IDs that have
+// been replaced by override rows. Overrides are returned on their own by the
+// non-recurring query, wherever they have been moved to.
+func overriddenOccurrences(db *gorm.DB, masters []models.Event) (map[string][]time.Time, error) {
+	overridden := make(map[string][]time.Time)
+	if len(masters) == 0 {
+		return overridden, nil
+	}
+
+	ids := make([]string, len(masters))
+	for i, master := range masters {
+		ids[i] = master.ID
+	}
+
+	var overrides []models.Event
+	err := db.Select("recurring_event_id", "recurrence_id").
+		Where("recurring_event_id IN ?", ids).
+		Find(&overrides).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query overridden occurrences: %v", err)
+	}
+	for _, override := range overrides {
+		if override.RecurrenceID != nil {
+			overridden[override.RecurringEventID] = append(overridden[override.RecurringEventID], *override.RecurrenceID)
+		}
+	}
+	return overridden, nil
+}
+
+func applyEventFilter(query *gorm.DB, filter EventFilter) *gorm.DB {
+	if filter.Color != "" {
+		query = query.Where("color = ?", filter.Color)
+	}
+	if filter.Title != "" {
+		query = query.Where("LOWER(title) LIKE ? ESCAPE '\\'", "%"+escapeLike(strings.ToLower(filter.Title))+"%")
+	}
+	if filter.UpdatedSince != nil {
+		query = query.Where("updated_at >= ?", filter.UpdatedSince.UTC())
+	}
+	if len(filter.CalendarIDs) > 0 {
+		query = query.Where("calendar_id IN ?", filter.CalendarIDs)
+	}
+	if len(filter.HiddenCalendarIDs) > 0 {
+		query = query.Where("calendar_id NOT IN ?", filter.HiddenCalendarIDs)
+	}
+	if filter.Attendee != "" {
+		// Attendees are stored as JSON with normalized emails.
+		email, _ := json.Marshal(models.NormalizeEmail(filter.Attendee))
+		query = query.Where("attendees LIKE ? ESCAPE '\\'", `%"email":`+escapeLike(string(email))+"%")
+	}
+	return query
+}
+
+// escapeLike escapes the LIKE wildcards so user input is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
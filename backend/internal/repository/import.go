@@ -0,0 +1,282 @@
+package repository
+
+import (
+	"calendar-backend/internal/ical"
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/recurrence"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+type ImportStatus string
+
+const (
+	ImportCreated ImportStatus = "created"
+	ImportUpdated ImportStatus = "updated"
+	ImportSkipped ImportStatus = "skipped"
+)
+
+// ImportResult describes what happened to a single imported event.
+type ImportResult struct {
+	ID     string       `json:"id,omitempty"`
+	Title  string       `json:"title,omitempty"`
+	Status ImportStatus `json:"status"`
+	Reason string       `json:"reason,omitempty"`
+}
+
+// ImportReport summarises an import run.
+type ImportReport struct {
+	Created int            `json:"created"`
+	Updated int            `json:"updated"`
+	Skipped int            `json:"skipped"`
+	Results []ImportResult `json:"results"`
+}
+
+func (r *ImportReport) add(result ImportResult) {
+	switch result.Status {
+	case ImportCreated:
+		r.Created++
+	case ImportUpdated:
+		r.Updated++
+	default:
+		r.Skipped++
+	}
+	r.Results = append(r.Results, result)
+}
+
+// Skip records an event that never made it to the import, e.g. because it
+// couldn't be parsed.
+func (r *ImportReport) Skip(reason string) {
+	r.add(ImportResult{Status: ImportSkipped, Reason: reason})
+}
+
+// ErrRejected is returned by ReplaceSeries when part of the series can't be stored.
+var ErrRejected = errors.New("event rejected")
+
+// importedFields are the columns an import is allowed to overwrite.
+var importedFields = []string{"title", "description", "start", "end", "time_zone", "all_day", "floating", "transparent", "color", "rrule", "ex_dates", "recurring_event_id", "recurrence_id", "organizer", "attendees"}
+
+// ImportICS parses an iCalendar stream and imports its VEVENTs into the
+// calendar of the user. VEVENTs that can't be mapped onto an event are
+// reported as skipped.
+func ImportICS(db *gorm.DB, userID string, r io.Reader) (*ImportReport, error) {
+	roots, err := ical.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid calendar data: %v", err)
+	}
+
+	events, errs := ical.Events(roots)
+	report := &ImportReport{}
+	for _, err := range errs {
+		report.Skip(err.Error())
+	}
+	if err := ImportEvents(db, userID, events, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// ImportEvents upserts events by ID into the calendar of the user inside a
+// single transaction. Events whose stored copy already has the same content
+// are skipped, as are overrides whose series is missing, events that were
+// deleted locally and events whose ID belongs to another user.
+func ImportEvents(db *gorm.DB, userID string, events []models.Event, report *ImportReport) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		audit, err := BeginAudit(tx, seriesIDs(events)...)
+		if err != nil {
+			return err
+		}
+		if err := importEvents(tx, userID, events, report); err != nil {
+			return err
+		}
+		return audit.Record(models.Actor{Type: models.ActorImport, UserID: userID}, seriesIDs(events)...)
+	})
+}
+
+// ReplaceSeries stores events (a master and its overrides, all sharing uid)
+// as the new content of the series of ownerID, dropping overrides that are no
+// longer present, on behalf of actor. It reports whether the series did not
+// exist before.
+func ReplaceSeries(db *gorm.DB, ownerID, uid string, events []models.Event, actor models.Actor) (bool, error) {
+	report := &ImportReport{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		audit, err := BeginAudit(tx, uid)
+		if err != nil {
+			return err
+		}
+		if err := importEvents(tx, ownerID, events, report); err != nil {
+			return err
+		}
+		for _, result := range report.Results {
+			if result.Status == ImportSkipped && result.Reason != "unchanged" {
+				return fmt.Errorf("%w: %s", ErrRejected, result.Reason)
+			}
+		}
+
+		keep := []string{uid}
+		for _, result := range report.Results {
+			keep = append(keep, result.ID)
+		}
+		if err := deleteRows(tx, "recurring_event_id = ? AND id NOT IN ?", uid, keep); err != nil {
+			return err
+		}
+		return audit.Record(actor, uid)
+	})
+	if err != nil {
+		return false, err
+	}
+	created := len(report.Results) > 0 && report.Results[0].Status == ImportCreated
+	return created, nil
+}
+
+// seriesIDs returns the IDs of the series the events belong to.
+func seriesIDs(events []models.Event) []string {
+	ids := make([]string, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+		if event.IsOverride() {
+			ids[i] = event.RecurringEventID
+		}
+	}
+	return ids
+}
+
+func importEvents(tx *gorm.DB, userID string, events []models.Event, report *ImportReport) error {
+	// Series masters must exist before their overrides are imported.
+	sort.SliceStable(events, func(i, j int) bool {
+		return !events[i].IsOverride() && events[j].IsOverride()
+	})
+
+	for i := range events {
+		result, err := importEvent(tx, userID, &events[i])
+		if err != nil {
+			return err
+		}
+		report.add(result)
+	}
+	return nil
+}
+
+func importEvent(tx *gorm.DB, userID string, event *models.Event) (ImportResult, error) {
+	result := ImportResult{ID: event.ID, Title: event.Title}
+
+	if event.IsRecurring() {
+		if _, err := recurrence.Parse(event.RRule); err != nil {
+			result.Status = ImportSkipped
+			result.Reason = fmt.Sprintf("invalid rrule: %v", err)
+			return result, nil
+		}
+	}
+	if event.IsOverride() {
+		var count int64
+		if err := tx.Model(&models.Event{}).Where("id = ?", event.RecurringEventID).Count(&count).Error; err != nil {
+			return result, fmt.Errorf("failed to look up series %s: %v", event.RecurringEventID, err)
+		}
+		if count == 0 {
+			result.Status = ImportSkipped
+			result.Reason = "recurring series not found"
+			return result, nil
+		}
+
+		// The occurrence may already be overridden by a row with another ID,
+		// e.g. one created through the API and exported since.
+		var current models.Event
+		err := tx.Unscoped().
+			Where("recurring_event_id = ? AND recurrence_id = ?", event.RecurringEventID, event.RecurrenceID.UTC()).
+			First(&current).Error
+		if err == nil {
+			event.ID = current.ID
+			result.ID = current.ID
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return result, fmt.Errorf("failed to look up occurrence of %s: %v", event.RecurringEventID, err)
+		}
+	}
+
+	var existing models.Event
+	err := tx.Unscoped().First(&existing, "id = ?", event.ID).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		// tx only sees the user's events, so the ID may still be taken.
+		var count int64
+		err := tx.Session(&gorm.Session{NewDB: true}).Unscoped().Model(&models.Event{}).Where("id = ?", event.ID).Count(&count).Error
+		if err != nil {
+			return result, fmt.Errorf("failed to look up event %s: %v", event.ID, err)
+		}
+		if count > 0 {
+			result.Status = ImportSkipped
+			result.Reason = "event ID is used by another calendar"
+			return result, nil
+		}
+		if event.IsOverride() {
+			// Occurrences stay in the calendar of their series.
+			var master models.Event
+			if err := tx.Unscoped().Select("calendar_id").First(&master, "id = ?", event.RecurringEventID).Error; err != nil {
+				return result, fmt.Errorf("failed to look up series %s: %v", event.RecurringEventID, err)
+			}
+			event.CalendarID = master.CalendarID
+		}
+		event.UserID = userID
+		if _, err := AssignCalendar(tx, event); err != nil {
+			return result, err
+		}
+		if err := tx.Create(event).Error; err != nil {
+			return result, fmt.Errorf("failed to create event %s: %v", event.ID, err)
+		}
+		result.Status = ImportCreated
+		return result, nil
+
+	case err != nil:
+		return result, fmt.Errorf("failed to look up event %s: %v", event.ID, err)
+
+	case existing.DeletedAt.Valid:
+		result.Status = ImportSkipped
+		result.Reason = "event was deleted"
+		return result, nil
+	}
+
+	if event.Color == "" {
+		event.Color = existing.Color
+	}
+	if sameContent(&existing, event) {
+		result.Status = ImportSkipped
+		result.Reason = "unchanged"
+		return result, nil
+	}
+	if err := tx.Model(&existing).Select(importedFields).Updates(event).Error; err != nil {
+		return result, fmt.Errorf("failed to update event %s: %v", event.ID, err)
+	}
+	result.Status = ImportUpdated
+	return result, nil
+}
+
+func sameContent(a, b *models.Event) bool {
+	if a.Title != b.Title || a.Description != b.Description || a.Color != b.Color ||
+		!a.Start.Equal(b.Start) || !a.End.Equal(b.End) ||
+		a.TimeZone != b.TimeZone || a.AllDay != b.AllDay || a.Floating != b.Floating ||
+		a.Transparent != b.Transparent || a.Organizer != b.Organizer ||
+		a.RRule != b.RRule || a.RecurringEventID != b.RecurringEventID {
+		return false
+	}
+	if (a.RecurrenceID == nil) != (b.RecurrenceID == nil) ||
+		(a.RecurrenceID != nil && !a.RecurrenceID.Equal(*b.RecurrenceID)) {
+		return false
+	}
+	if len(a.ExDates) != len(b.ExDates) || len(a.Attendees) != len(b.Attendees) {
+		return false
+	}
+	for i := range a.Attendees {
+		if a.Attendees[i] != b.Attendees[i] {
+			return false
+		}
+	}
+	for i := range a.ExDates {
+		if !a.ExDates[i].Equal(b.ExDates[i]) {
+			return false
+		}
+	}
+	return true
+}
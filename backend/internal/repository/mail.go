@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"calendar-backend/internal/ical"
+	"calendar-backend/internal/imip"
+	"calendar-backend/internal/models"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SendInvitations turns on putting invitations, updates and cancellations
+// for the attendees of changed events into the outbox. It is set when an
+// SMTP server is configured to send them.
+var SendInvitations = false
+
+// queueInvitations adds the iMIP messages for the changes recorded in
+// entries to the outbox, for events organized by their owner: those that
+// were imported from someone else's invitation are left alone. Attendees
+// get a REQUEST when an event is created or changed other than by the
+// responses of the attendees, and a CANCEL when it is deleted or they are
+// removed from it. A series is sent as a whole when its master changed.
+func queueInvitations(tx *gorm.DB, entries []models.AuditEntry) error {
+	if !SendInvitations {
+		return nil
+	}
+	masters := map[string]bool{}
+	for _, entry := range entries {
+		if entry.SeriesID == "" {
+			masters[entry.EventID] = true
+		}
+	}
+	organizers := map[string]string{}
+	for _, entry := range entries {
+		if masters[entry.SeriesID] {
+			continue
+		}
+		row := entry.After
+		if row == nil {
+			row = entry.Before
+		}
+		if _, ok := organizers[row.UserID]; !ok {
+			var owner models.User
+			if err := tx.Session(&gorm.Session{NewDB: true}).Select("email").Limit(1).Find(&owner, "id = ?", row.UserID).Error; err != nil {
+				return fmt.Errorf("failed to load organizer: %v", err)
+			}
+			organizers[row.UserID] = owner.Email
+		}
+		if row.Organizer == "" || row.Organizer != organizers[row.UserID] {
+			continue
+		}
+		if err := queueEntry(tx, &entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queueEntry queues the messages for the change of one row.
+func queueEntry(tx *gorm.DB, entry *models.AuditEntry) error {
+	var before *models.Event
+	if entry.Action != models.AuditCreate && entry.Action != models.AuditRestore && entry.Action != models.AuditPurge {
+		before = entry.Before
+	}
+	after := entry.After
+	if entry.Action == models.AuditCreate && after.IsOverride() {
+		// An occurrence that was changed for the first time is compared
+		// with what it was as part of its series.
+		var master models.Event
+		if err := tx.Session(&gorm.Session{NewDB: true}).Limit(1).Find(&master, "id = ?", after.RecurringEventID).Error; err != nil {
+			return fmt.Errorf("failed to load series: %v", err)
+		}
+		if master.ID != "" {
+			occurrence := master
+			occurrence.ID = after.ID
+			occurrence.Start = after.RecurrenceID.UTC()
+			occurrence.End = occurrence.Start.Add(master.End.Sub(master.Start))
+			occurrence.RRule, occurrence.ExDates = "", nil
+			occurrence.RecurringEventID, occurrence.RecurrenceID = master.ID, after.RecurrenceID
+			before = &occurrence
+		}
+	}
+
+	if before != nil {
+		var removed []string
+		for _, attendee := range before.Attendees {
+			if after == nil || after.Attendee(attendee.Email) == nil {
+				removed = append(removed, attendee.Email)
+			}
+		}
+		if err := queueMail(tx, ical.MethodCancel, before, removed, true); err != nil {
+			return err
+		}
+	}
+	if after == nil || (before != nil && onlyResponsesChanged(before, after)) {
+		return nil
+	}
+	// Attendees who knew about the event get an update, the others an
+	// invitation.
+	var invited, updated []string
+	for _, attendee := range after.Attendees {
+		if before != nil && before.Attendee(attendee.Email) != nil {
+			updated = append(updated, attendee.Email)
+		} else {
+			invited = append(invited, attendee.Email)
+		}
+	}
+	if err := queueMail(tx, ical.MethodRequest, after, invited, false); err != nil {
+		return err
+	}
+	return queueMail(tx, ical.MethodRequest, after, updated, true)
+}
+
+// queueMail puts a message about event into the outbox for the recipients
+// other than the organizer. Series masters are sent along with their
+// overrides.
+func queueMail(tx *gorm.DB, method string, event *models.Event, recipients []string, updated bool) error {
+	var to []string
+	for _, recipient := range recipients {
+		if recipient != event.Organizer {
+			to = append(to, recipient)
+		}
+	}
+	if len(to) == 0 {
+		return nil
+	}
+
+	events := []models.Event{*event}
+	if event.IsRecurring() && method == ical.MethodRequest {
+		var overrides []models.Event
+		if err := tx.Session(&gorm.Session{NewDB: true}).Where("recurring_event_id = ?", event.ID).Order("recurrence_id").Find(&overrides).Error; err != nil {
+			return fmt.Errorf("failed to load overrides: %v", err)
+		}
+		events = append(events, overrides...)
+	}
+	subject, body := imip.Describe(method, event, updated)
+	mail := models.OutgoingMail{
+		UserID:     event.UserID,
+		EventID:    event.ID,
+		Method:     method,
+		Organizer:  event.Organizer,
+		Recipients: to,
+		Subject:    subject,
+		Body:       body,
+		Calendar:   string(ical.Encode(ical.Calendar{Method: method, Events: events})),
+	}
+	if err := tx.Session(&gorm.Session{NewDB: true}).Create(&mail).Error; err != nil {
+		return fmt.Errorf("failed to queue %s for %s: %v", method, event.ID, err)
+	}
+	return nil
+}
+
+// onlyResponsesChanged reports whether the attendees' responses are all
+// that differs between two versions of an event, which attendees need not
+// be told about.
+func onlyResponsesChanged(before, after *models.Event) bool {
+	responded := *after
+	responded.Attendees = make([]models.Attendee, len(after.Attendees))
+	for i, attendee := range after.Attendees {
+		if previous := before.Attendee(attendee.Email); previous != nil {
+			attendee.Status = previous.Status
+		}
+		responded.Attendees[i] = attendee
+	}
+	return sameContent(before, &responded)
+}
+
+// PendingMail returns the messages in the outbox that are still to be sent,
+// oldest first.
+func PendingMail(limit int) ([]models.OutgoingMail, error) {
+	var mails []models.OutgoingMail
+	err := DB.Where("sent_at IS NULL AND attempts < ?", models.MaxMailAttempts).
+		Order("id").Limit(limit).Find(&mails).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load outbox: %v", err)
+	}
+	return mails, nil
+}
+
+// MarkMailSent records that a message has been sent, or the error sending
+// it failed with. Failed messages are retried until MaxMailAttempts.
+func MarkMailSent(mail *models.OutgoingMail, sendErr error) error {
+	mail.Attempts++
+	if sendErr != nil {
+		mail.LastError = sendErr.Error()
+	} else {
+		now := time.Now()
+		mail.SentAt, mail.LastError = &now, ""
+	}
+	if err := DB.Model(mail).Select("attempts", "last_error", "sent_at").Updates(mail).Error; err != nil {
+		return fmt.Errorf("failed to update outbox: %v", err)
+	}
+	return nil
+}
+
+// ApplyResponses records the responses of an emailed reply to an invitation,
+// each in a transaction of its own. Responses to events the sender isn't
+// invited to are reported as ErrNotFound.
+func ApplyResponses(responses []imip.Response) error {
+	for _, response := range responses {
+		scope := ScopeAll
+		if response.RecurrenceID != nil {
+			scope = ScopeThis
+		}
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			tx, err := InvitationAccess(tx, response.Email, response.UID)
+			if err != nil {
+				return err
+			}
+			audit, err := BeginAudit(tx, response.UID)
+			if err != nil {
+				return err
+			}
+			updated, err := RespondToEvent(tx, response.UID, response.Email, response.Status, scope, response.RecurrenceID)
+			if err != nil {
+				return err
+			}
+			return audit.Record(models.Actor{Type: models.ActorMail}, updated.ID)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply reply of %s to %s: %v", response.Email, response.UID, err)
+		}
+		log.Printf("%s responded %s to event %s by email", response.Email, response.Status, response.UID)
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+package repository
+
+import (
+	"calendar-backend/internal/models"
+	"calendar-backend/internal/recurrence"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Scope selects which part of a recurring series an update or delete applies to.
+type Scope string
+
+const (
+	ScopeAll       Scope = "all"
+	ScopeThis      Scope = "this"
+	ScopeFollowing Scope = "following"
+)
+
+var (
+	ErrNotFound          = errors.New("event not found")
+	ErrNotOccurrence     = errors.New("recurrence ID does not match an occurrence of the series")
+	ErrNeedsRecurrenceID = errors.New("a recurrence ID is required to change part of a series")
+)
+
+var (
+	// identityFields are never changed by an update.
+	identityFields = []string{"id", "user_id", "recurring_event_id", "recurrence_id"}
+	// seriesFields are never copied from an update onto an override or a split series.
+	seriesFields = append([]string{"rrule", "ex_dates"}, identityFields...)
+	// overrideFields are never changed on an override: it stays in the
+	// calendar of its series.
+	overrideFields = append([]string{"calendar_id"}, seriesFields...)
+)
+
+// ParseScope converts a request value into a Scope, defaulting to ScopeAll.
+func ParseScope(value string) (Scope, error) {
+	switch scope := Scope(value); scope {
+	case "":
+		return ScopeAll, nil
+	case ScopeAll, ScopeThis, ScopeFollowing:
+		return scope, nil
+	default:
+		return "", fmt.Errorf("invalid scope %q, expected one of: this, following, all", value)
+	}
+}
+
+// UpdateEvent applies changes (a models.Event or a column map, as accepted by
+// gorm's Updates) to an event. For recurring events the scope decides whether
+// a single occurrence is overridden, the series is split at the occurrence,
+// or the whole series is updated. It returns the row that holds the change.
+func UpdateEvent(tx *gorm.DB, id string, changes interface{}, scope Scope, recurrenceID *time.Time) (*models.Event, error) {
+	event, scope, recurrenceID, err := resolveTarget(tx, id, scope, recurrenceID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case event.IsOverride():
+		if err := tx.Model(event).Omit(overrideFields...).Updates(changes).Error; err != nil {
+			return nil, fmt.Errorf("failed to update occurrence: %v", err)
+		}
+		return reload(tx, event)
+
+	case scope == ScopeAll || !event.IsRecurring():
+		if err := tx.Model(event).Omit(identityFields...).Updates(changes).Error; err != nil {
+			return nil, fmt.Errorf("failed to update event: %v", err)
+		}
+		return reloadSeries(tx, event)
+
+	case scope == ScopeThis:
+		override, err := findOrCreateOverride(tx, event, *recurrenceID)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Model(override).Omit(overrideFields...).Updates(changes).Error; err != nil {
+			return nil, fmt.Errorf("failed to update occurrence: %v", err)
+		}
+		return reload(tx, override)
+
+	default:
+		tail, err := splitSeries(tx, event, *recurrenceID)
+		if err != nil {
+			return nil, err
+		}
+		if tail == event {
+			// Splitting at the first occurrence leaves nothing before it.
+			err = tx.Model(event).Omit(identityFields...).Updates(changes).Error
+		} else {
+			err = tx.Model(tail).Omit(seriesFields...).Updates(changes).Error
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to update series: %v", err)
+		}
+		if calendarID := changedCalendar(changes); tail != event && calendarID != "" {
+			// The new series may go to another calendar.
+			if err := tx.Model(tail).Update("calendar_id", calendarID).Error; err != nil {
+				return nil, fmt.Errorf("failed to update series: %v", err)
+			}
+		}
+		return reloadSeries(tx, tail)
+	}
+}
+
+// DeleteEvent deletes an event or, for recurring events, the part of the
+// series selected by scope.
+func DeleteEvent(tx *gorm.DB, id string, scope Scope, recurrenceID *time.Time) error {
+	event, scope, recurrenceID, err := resolveTarget(tx, id, scope, recurrenceID)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case event.IsOverride():
+		// Dropping the override alone would bring the original occurrence back.
+		return DeleteEvent(tx, event.RecurringEventID, ScopeThis, event.RecurrenceID)
+
+	case !event.IsRecurring():
+		return deleteRows(tx, "id = ?", event.ID)
+
+	case scope == ScopeAll:
+		if err := deleteRows(tx, "recurring_event_id = ?", event.ID); err != nil {
+			return err
+		}
+		return deleteRows(tx, "id = ?", event.ID)
+
+	case scope == ScopeThis:
+		if err := deleteRows(tx, "recurring_event_id = ? AND recurrence_id = ?", event.ID, recurrenceID.UTC()); err != nil {
+			return err
+		}
+		event.ExDates = append(event.ExDates, recurrenceID.UTC())
+		if err := tx.Model(event).Select("ex_dates").Updates(event).Error; err != nil {
+			return fmt.Errorf("failed to exclude occurrence: %v", err)
+		}
+		return nil
+
+	default:
+		if recurrenceID.Equal(event.Start) {
+			return DeleteEvent(tx, event.ID, ScopeAll, nil)
+		}
+		rule, err := recurrence.Parse(event.RRule)
+		if err != nil {
+			return err
+		}
+		if err := truncateSeries(tx, event, rule, *recurrenceID); err != nil {
+			return err
+		}
+		return deleteRows(tx, "recurring_event_id = ? AND recurrence_id >= ?", event.ID, recurrenceID.UTC())
+	}
+}
+
+// FindSeries returns the event stored under uid followed by the overrides of
+// its occurrences, i.e. everything that shares the UID in iCalendar terms.
+func FindSeries(db *gorm.DB, uid string) ([]models.Event, error) {
+	var master models.Event
+	if err := db.First(&master, "id = ? AND recurring_event_id = ''", uid).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to load event: %v", err)
+	}
+
+	series := []models.Event{master}
+	if master.IsRecurring() {
+		var overrides []models.Event
+		if err := db.Where("recurring_event_id = ?", uid).Order("recurrence_id").Find(&overrides).Error; err != nil {
+			return nil, fmt.Errorf("failed to load overrides: %v", err)
+		}
+		series = append(series, overrides...)
+	}
+	return series, nil
+}
+
+// resolveTarget loads the event addressed by id. Overrides addressed with a
+// series-wide scope are redirected to their master, and the recurrence ID is
+// checked against the series when a single occurrence or split is requested.
+func resolveTarget(tx *gorm.DB, id string, scope Scope, recurrenceID *time.Time) (*models.Event, Scope, *time.Time, error) {
+	var event models.Event
+	if err := tx.First(&event, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, scope, nil, ErrNotFound
+		}
+		return nil, scope, nil, fmt.Errorf("failed to load event: %v", err)
+	}
+
+	if event.IsOverride() {
+		if scope == ScopeThis {
+			return &event, ScopeAll, nil, nil
+		}
+		recurrenceID = event.RecurrenceID
+		var master models.Event
+		if err := tx.First(&master, "id = ?", event.RecurringEventID).Error; err != nil {
+			return nil, scope, nil, fmt.Errorf("failed to load series: %v", err)
+		}
+		event = master
+	}
+
+	if !event.IsRecurring() || scope == ScopeAll {
+		return &event, scope, recurrenceID, nil
+	}
+	if recurrenceID == nil {
+		return nil, scope, nil, ErrNeedsRecurrenceID
+	}
+	if !isOccurrence(&event, *recurrenceID) {
+		return nil, scope, nil, ErrNotOccurrence
+	}
+	return &event, scope, recurrenceID, nil
+}
+
+func isOccurrence(master *models.Event, recurrenceID time.Time) bool {
+	rule, err := recurrence.Parse(master.RRule)
+	if err != nil {
+		return false
+	}
+	for _, exdate := range master.ExDates {
+		if exdate.Equal(recurrenceID) {
+			return false
+		}
+	}
+	found := false
+	rule.All(master.Start.In(master.Location()), func(start time.Time) bool {
+		found = start.Equal(recurrenceID)
+		return start.Before(recurrenceID)
+	})
+	return found
+}
+
+func findOrCreateOverride(tx *gorm.DB, master *models.Event, recurrenceID time.Time) (*models.Event, error) {
+	var override models.Event
+	err := tx.Where("recurring_event_id = ? AND recurrence_id = ?", master.ID, recurrenceID.UTC()).First(&override).Error
+	if err == nil {
+		return &override, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to load occurrence: %v", err)
+	}
+
+	start := recurrenceID.UTC()
+	override = *master
+	override.ID = uuid.New().String()
+	override.Start = start
+	override.End = start.Add(master.End.Sub(master.Start))
+	override.RRule = ""
+	override.ExDates = nil
+	override.RecurringEventID = master.ID
+	override.RecurrenceID = &start
+	override.CreatedAt = time.Time{}
+	override.UpdatedAt = time.Time{}
+	if err := tx.Create(&override).Error; err != nil {
+		return nil, fmt.Errorf("failed to create occurrence override: %v", err)
+	}
+	return &override, nil
+}
+
+// splitSeries ends the master series just before recurrenceID and starts a
+// new series at that occurrence carrying the rest of the rule, moving any
+// overrides and exclusions that now belong to it. It returns the new series,
+// or the master itself when recurrenceID is the first occurrence.
+func splitSeries(tx *gorm.DB, master *models.Event, recurrenceID time.Time) (*models.Event, error) {
+	if recurrenceID.Equal(master.Start) {
+		return master, nil
+	}
+
+	rule, err := recurrence.Parse(master.RRule)
+	if err != nil {
+		return nil, err
+	}
+	tailRule := *rule
+	if rule.Count > 0 {
+		tailRule.Count = rule.Count - countBefore(master, rule, recurrenceID)
+	}
+
+	tail := *master
+	tail.ID = uuid.New().String()
+	tail.Start = recurrenceID.UTC()
+	tail.End = tail.Start.Add(master.End.Sub(master.Start))
+	tail.RRule = tailRule.String()
+	tail.ExDates = nil
+	for _, exdate := range master.ExDates {
+		if !exdate.Before(recurrenceID) {
+			tail.ExDates = append(tail.ExDates, exdate)
+		}
+	}
+	tail.CreatedAt = time.Time{}
+	tail.UpdatedAt = time.Time{}
+
+	if err := truncateSeries(tx, master, rule, recurrenceID); err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&tail).Error; err != nil {
+		return nil, fmt.Errorf("failed to create split series: %v", err)
+	}
+	err = tx.Model(&models.Event{}).
+		Where("recurring_event_id = ? AND recurrence_id >= ?", master.ID, recurrenceID.UTC()).
+		Update("recurring_event_id", tail.ID).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to move overrides to split series: %v", err)
+	}
+	return &tail, nil
+}
+
+// truncateSeries rewrites the master's rule so its last occurrence is the one
+// before recurrenceID.
+func truncateSeries(tx *gorm.DB, master *models.Event, rule *recurrence.Rule, recurrenceID time.Time) error {
+	headRule := *rule
+	if rule.Count > 0 {
+		headRule.Count = countBefore(master, rule, recurrenceID)
+	} else {
+		until := recurrenceID.UTC().Add(-time.Second)
+		headRule.Until, headRule.FloatingUntil = &until, false
+	}
+
+	var exdates []time.Time
+	for _, exdate := range master.ExDates {
+		if exdate.Before(recurrenceID) {
+			exdates = append(exdates, exdate)
+		}
+	}
+	master.RRule = headRule.String()
+	master.ExDates = exdates
+
+	if err := tx.Model(master).Select("rrule", "ex_dates").Updates(master).Error; err != nil {
+		return fmt.Errorf("failed to truncate series: %v", err)
+	}
+	return nil
+}
+
+// countBefore returns how many occurrences the rule generates before t.
+func countBefore(master *models.Event, rule *recurrence.Rule, t time.Time) int {
+	n := 0
+	rule.All(master.Start.In(master.Location()), func(start time.Time) bool {
+		if !start.Before(t) {
+			return false
+		}
+		n++
+		return true
+	})
+	return n
+}
+
+func reload(tx *gorm.DB, event *models.Event) (*models.Event, error) {
+	var stored models.Event
+	if err := tx.First(&stored, "id = ?", event.ID).Error; err != nil {
+		return nil, fmt.Errorf("failed to reload event: %v", err)
+	}
+	return &stored, nil
+}
+
+// reloadSeries is reload for an event that isn't an override, whose
+// calendar may have changed: the calendar is checked and the overrides of a
+// series are moved along with it.
+func reloadSeries(tx *gorm.DB, event *models.Event) (*models.Event, error) {
+	stored, err := reload(tx, event)
+	if err != nil {
+		return nil, err
+	}
+	if err := moveSeriesCalendar(tx, stored); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// changedCalendar returns the calendar an update moves events to, if any.
+func changedCalendar(changes interface{}) string {
+	switch changes := changes.(type) {
+	case *models.Event:
+		return changes.CalendarID
+	case map[string]interface{}:
+		calendarID, _ := changes["calendar_id"].(string)
+		return calendarID
+	}
+	return ""
+}
+
+func deleteRows(tx *gorm.DB, query string, args ...interface{}) error {
+	if err := tx.Where(query, args...).Delete(&models.Event{}).Error; err != nil {
+		return fmt.Errorf("failed to delete event: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package repository
+
+import (
+	"calendar-backend/internal/models"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB returns an in-memory database holding the calendar of user-1.
+func testDB(t *testing.T) (*gorm.DB, *models.Calendar) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	// Every connection to ":memory:" opens a database of its own.
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.User{}, &models.Calendar{}, &models.CalendarShare{}, &models.Event{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	calendar, err := DefaultCalendar(db, "user-1")
+	if err != nil {
+		t.Fatalf("failed to create calendar: %v", err)
+	}
+	return db, calendar
+}
+
+// standup returns the start of the daily standup at 09:00 in Paris on the
+// given day of October 2026, in UTC as it is stored.
+func standup(day int) time.Time {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		panic(err)
+	}
+	return time.Date(2026, 10, day, 9, 0, 0, 0, paris).UTC()
+}
+
+// createSeries stores a half-hour series starting on October 5th.
+func createSeries(t *testing.T, db *gorm.DB, calendar *models.Calendar, rrule string, exdates ...time.Time) *models.Event {
+	t.Helper()
+	master := models.Event{
+		ID: "standup", UserID: calendar.UserID, CalendarID: calendar.ID, Title: "Standup",
+		Start: standup(5), End: standup(5).Add(30 * time.Minute), TimeZone: "Europe/Paris",
+		RRule: rrule, ExDates: exdates,
+	}
+	if err := db.Create(&master).Error; err != nil {
+		t.Fatalf("failed to create series: %v", err)
+	}
+	return &master
+}
+
+func load(t *testing.T, db *gorm.DB, id string) *models.Event {
+	t.Helper()
+	var event models.Event
+	if err := db.First(&event, "id = ?", id).Error; err != nil {
+		t.Fatalf("failed to load %s: %v", id, err)
+	}
+	return &event
+}
+
+func overrides(t *testing.T, db *gorm.DB) []models.Event {
+	t.Helper()
+	var events []models.Event
+	if err := db.Where("recurring_event_id <> ''").Order("recurrence_id").Find(&events).Error; err != nil {
+		t.Fatalf("failed to load overrides: %v", err)
+	}
+	return events
+}
+
+// occurrences returns the starts of the occurrences of the given series
+// during October.
+func occurrences(t *testing.T, masters ...*models.Event) []time.Time {
+	t.Helper()
+	var starts []time.Time
+	for _, master := range masters {
+		expanded, err := ExpandEvent(*master, standup(1), standup(31), nil)
+		if err != nil {
+			t.Fatalf("failed to expand %s: %v", master.ID, err)
+		}
+		for _, occurrence := range expanded {
+			starts = append(starts, occurrence.Start)
+		}
+	}
+	return starts
+}
+
+func TestUpdateEventThisOccurrence(t *testing.T) {
+	db, calendar := testDB(t)
+	createSeries(t, db, calendar, "FREQ=DAILY;COUNT=10")
+
+	recurrenceID := standup(7)
+	override, err := UpdateEvent(db, "standup", map[string]interface{}{"title": "Demo"}, ScopeThis, &recurrenceID)
+	if err != nil {
+		t.Fatalf("failed to update occurrence: %v", err)
+	}
+	if override.RecurringEventID != "standup" || !override.RecurrenceID.Equal(recurrenceID) {
+		t.Errorf("override of %q at %v, want standup at %v", override.RecurringEventID, override.RecurrenceID, recurrenceID)
+	}
+	if !override.Start.Equal(recurrenceID) || override.End.Sub(override.Start) != 30*time.Minute || override.RRule != "" {
+		t.Errorf("override runs %v - %v with rule %q, want the occurrence alone", override.Start, override.End, override.RRule)
+	}
+	if override.Title != "Demo" || override.TimeZone != "Europe/Paris" || override.CalendarID != calendar.ID {
+		t.Errorf("override is %q in %s on %s, want a copy of the series titled Demo", override.Title, override.TimeZone, override.CalendarID)
+	}
+
+	// Changing the occurrence again updates the same override.
+	again, err := UpdateEvent(db, "standup", map[string]interface{}{"description": "Sprint demo"}, ScopeThis, &recurrenceID)
+	if err != nil {
+		t.Fatalf("failed to update occurrence again: %v", err)
+	}
+	if again.ID != override.ID || again.Title != "Demo" || again.Description != "Sprint demo" {
+		t.Errorf("second update gave %s %q, want %s updated", again.ID, again.Title, override.ID)
+	}
+	if n := len(overrides(t, db)); n != 1 {
+		t.Errorf("got %d overrides, want 1", n)
+	}
+	if master := load(t, db, "standup"); master.Title != "Standup" {
+		t.Errorf("series title = %q, want it unchanged", master.Title)
+	}
+
+	notOccurrence := standup(7).Add(time.Hour)
+	if _, err := UpdateEvent(db, "standup", map[string]interface{}{"title": "Demo"}, ScopeThis, &notOccurrence); err != ErrNotOccurrence {
+		t.Errorf("updating a time that isn't an occurrence gave %v, want ErrNotOccurrence", err)
+	}
+}
+
+func TestUpdateEventAll(t *testing.T) {
+	db, calendar := testDB(t)
+	createSeries(t, db, calendar, "FREQ=DAILY;COUNT=10")
+
+	updated, err := UpdateEvent(db, "standup", map[string]interface{}{"title": "Daily"}, ScopeAll, nil)
+	if err != nil {
+		t.Fatalf("failed to update series: %v", err)
+	}
+	if updated.ID != "standup" || updated.Title != "Daily" || updated.RRule != "FREQ=DAILY;COUNT=10" {
+		t.Errorf("got %s %q with rule %q, want the series renamed", updated.ID, updated.Title, updated.RRule)
+	}
+	var count int64
+	db.Model(&models.Event{}).Count(&count)
+	if count != 1 {
+		t.Errorf("got %d events, want the series alone", count)
+	}
+}
+
+func TestUpdateEventFollowing(t *testing.T) {
+	tests := []struct {
+		name                     string
+		rrule                    string
+		exdates                  []time.Time
+		day                      int
+		headRule, tailRule       string
+		headExDates, tailExDates []time.Time
+	}{
+		{
+			// The series ends one second before the split occurrence.
+			name:     "endless",
+			rrule:    "FREQ=DAILY",
+			day:      8,
+			headRule: "FREQ=DAILY;UNTIL=20261008T065959Z",
+			tailRule: "FREQ=DAILY",
+		},
+		{
+			name:     "until",
+			rrule:    "FREQ=DAILY;UNTIL=20261012T090000",
+			day:      8,
+			headRule: "FREQ=DAILY;UNTIL=20261008T065959Z",
+			tailRule: "FREQ=DAILY;UNTIL=20261012T090000",
+		},
+		{
+			// Excluded occurrences still count towards COUNT.
+			name:        "count with exclusions",
+			rrule:       "FREQ=DAILY;COUNT=10",
+			exdates:     []time.Time{standup(6), standup(9)},
+			day:         8,
+			headRule:    "FREQ=DAILY;COUNT=3",
+			tailRule:    "FREQ=DAILY;COUNT=7",
+			headExDates: []time.Time{standup(6)},
+			tailExDates: []time.Time{standup(9)},
+		},
+		{
+			// Monday 5th, Wednesday 7th, then the split on Friday 9th.
+			name:     "count with weekdays",
+			rrule:    "FREQ=WEEKLY;BYDAY=MO,WE,FR;COUNT=6",
+			day:      9,
+			headRule: "FREQ=WEEKLY;COUNT=2;BYDAY=MO,WE,FR",
+			tailRule: "FREQ=WEEKLY;COUNT=4;BYDAY=MO,WE,FR",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, calendar := testDB(t)
+			before := occurrences(t, createSeries(t, db, calendar, test.rrule, test.exdates...))
+
+			recurrenceID := standup(test.day)
+			tail, err := UpdateEvent(db, "standup", map[string]interface{}{"title": "Later standup"}, ScopeFollowing, &recurrenceID)
+			if err != nil {
+				t.Fatalf("failed to split series: %v", err)
+			}
+			head := load(t, db, "standup")
+			if tail.ID == head.ID || !tail.Start.Equal(recurrenceID) || tail.End.Sub(tail.Start) != 30*time.Minute {
+				t.Errorf("new series %s runs %v - %v, want a new series from %v", tail.ID, tail.Start, tail.End, recurrenceID)
+			}
+			if head.Title != "Standup" || tail.Title != "Later standup" {
+				t.Errorf("series are titled %q and %q, want only the new one changed", head.Title, tail.Title)
+			}
+			if head.RRule != test.headRule || tail.RRule != test.tailRule {
+				t.Errorf("rules are %q and %q, want %q and %q", head.RRule, tail.RRule, test.headRule, test.tailRule)
+			}
+			if !reflect.DeepEqual(head.ExDates, test.headExDates) || !reflect.DeepEqual(tail.ExDates, test.tailExDates) {
+				t.Errorf("exclusions are %v and %v, want %v and %v", head.ExDates, tail.ExDates, test.headExDates, test.tailExDates)
+			}
+			if after := occurrences(t, head, tail); !reflect.DeepEqual(after, before) {
+				t.Errorf("split series occur at %v, want %v", after, before)
+			}
+		})
+	}
+}
+
+func TestUpdateEventFollowingMovesOverrides(t *testing.T) {
+	db, calendar := testDB(t)
+	createSeries(t, db, calendar, "FREQ=DAILY;COUNT=10")
+	for _, day := range []int{6, 8, 10} {
+		recurrenceID := standup(day)
+		if _, err := UpdateEvent(db, "standup", map[string]interface{}{"title": "Moved"}, ScopeThis, &recurrenceID); err != nil {
+			t.Fatalf("failed to override occurrence: %v", err)
+		}
+	}
+
+	// Splitting through the override of the 8th splits its series there.
+	split := overrides(t, db)[1]
+	tail, err := UpdateEvent(db, split.ID, map[string]interface{}{"color": "#ef4444"}, ScopeFollowing, nil)
+	if err != nil {
+		t.Fatalf("failed to split series: %v", err)
+	}
+	if !tail.Start.Equal(standup(8)) || tail.RRule != "FREQ=DAILY;COUNT=7" || tail.Color != "#ef4444" {
+		t.Errorf("new series starts %v with rule %q and color %q, want it from the overridden occurrence", tail.Start, tail.RRule, tail.Color)
+	}
+	want := map[int]string{6: "standup", 8: tail.ID, 10: tail.ID}
+	for _, override := range overrides(t, db) {
+		day := override.RecurrenceID.In(time.UTC).Day()
+		if override.RecurringEventID != want[day] {
+			t.Errorf("override of the %dth belongs to %s, want %s", day, override.RecurringEventID, want[day])
+		}
+		if override.Title != "Moved" {
+			t.Errorf("override of the %dth is titled %q, want it kept", day, override.Title)
+		}
+	}
+}
+
+func TestUpdateEventFollowingFirstOccurrence(t *testing.T) {
+	db, calendar := testDB(t)
+	createSeries(t, db, calendar, "FREQ=DAILY;COUNT=10")
+
+	recurrenceID := standup(5)
+	updated, err := UpdateEvent(db, "standup", map[string]interface{}{"title": "Daily"}, ScopeFollowing, &recurrenceID)
+	if err != nil {
+		t.Fatalf("failed to update series: %v", err)
+	}
+	if updated.ID != "standup" || updated.Title != "Daily" || updated.RRule != "FREQ=DAILY;COUNT=10" {
+		t.Errorf("got %s %q with rule %q, want the whole series updated", updated.ID, updated.Title, updated.RRule)
+	}
+	var count int64
+	db.Model(&models.Event{}).Count(&count)
+	if count != 1 {
+		t.Errorf("got %d events, want no new series", count)
+	}
+}
+
+func TestDeleteEvent(t *testing.T) {
+	tests := []struct {
+		scope   Scope
+		day     int
+		rule    string
+		exdates []time.Time
+		kept    []int
+	}{
+		{scope: ScopeThis, day: 8, rule: "FREQ=DAILY;UNTIL=20261012T090000", exdates: []time.Time{standup(8)}, kept: []int{6, 10}},
+		{scope: ScopeFollowing, day: 8, rule: "FREQ=DAILY;UNTIL=20261008T065959Z", kept: []int{6}},
+		{scope: ScopeAll},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.scope), func(t *testing.T) {
+			db, calendar := testDB(t)
+			createSeries(t, db, calendar, "FREQ=DAILY;UNTIL=20261012T090000")
+			for _, day := range []int{6, 8, 10} {
+				recurrenceID := standup(day)
+				if _, err := UpdateEvent(db, "standup", map[string]interface{}{"title": "Moved"}, ScopeThis, &recurrenceID); err != nil {
+					t.Fatalf("failed to override occurrence: %v", err)
+				}
+			}
+
+			recurrenceID := standup(test.day)
+			if err := DeleteEvent(db, "standup", test.scope, &recurrenceID); err != nil {
+				t.Fatalf("failed to delete: %v", err)
+			}
+
+			var master models.Event
+			err := db.First(&master, "id = ?", "standup").Error
+			if test.scope == ScopeAll {
+				if err != gorm.ErrRecordNotFound {
+					t.Errorf("series is still there: %v", err)
+				}
+			} else if master.RRule != test.rule || !reflect.DeepEqual(master.ExDates, test.exdates) {
+				t.Errorf("series has rule %q excluding %v, want %q excluding %v", master.RRule, master.ExDates, test.rule, test.exdates)
+			}
+			var kept []int
+			for _, override := range overrides(t, db) {
+				kept = append(kept, override.RecurrenceID.Day())
+			}
+			if !reflect.DeepEqual(kept, test.kept) {
+				t.Errorf("overrides of %v are left, want %v", kept, test.kept)
+			}
+		})
+	}
+}
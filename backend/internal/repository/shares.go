@@ -0,0 +1,381 @@
+package repository
+
+import (
+	"calendar-backend/internal/models"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrForbidden     = errors.New("you don't have permission to do this")
+	ErrShareNotFound = errors.New("share not found")
+)
+
+// forOwner is ForUser within db, which may be a transaction: it drops the
+// conditions db already has and restricts it to the rows of userID.
+func forOwner(db *gorm.DB, userID string) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true}).Where("user_id = ?", userID).Session(&gorm.Session{})
+}
+
+// Access is what a user can see: their own calendars and those shared with
+// them, each with the user's Role. Calendars hidden by the user are marked
+// Hidden, whether the user owns them or not.
+type Access struct {
+	UserID    string
+	Calendars []models.Calendar
+}
+
+// LoadAccess returns the calendars userID can see, their own ones first.
+func LoadAccess(db *gorm.DB, userID string) (*Access, error) {
+	raw := db.Session(&gorm.Session{NewDB: true})
+	access := &Access{UserID: userID}
+	err := raw.Where("user_id = ?", userID).Order("is_default DESC").Order("LOWER(name)").Find(&access.Calendars).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load calendars: %v", err)
+	}
+	for i := range access.Calendars {
+		access.Calendars[i].Role = models.RoleOwner
+	}
+
+	var shares []models.CalendarShare
+	if err := raw.Where("user_id = ?", userID).Find(&shares).Error; err != nil {
+		return nil, fmt.Errorf("failed to load shares: %v", err)
+	}
+	if len(shares) == 0 {
+		return access, nil
+	}
+	ids := make([]string, len(shares))
+	for i, share := range shares {
+		ids[i] = share.CalendarID
+	}
+	var shared []models.Calendar
+	if err := raw.Where("id IN ?", ids).Find(&shared).Error; err != nil {
+		return nil, fmt.Errorf("failed to load shared calendars: %v", err)
+	}
+	owners, err := userEmails(raw, shared)
+	if err != nil {
+		return nil, err
+	}
+	for _, calendar := range shared {
+		for _, share := range shares {
+			if share.CalendarID == calendar.ID {
+				calendar.Role = share.Role
+				calendar.Hidden = share.Hidden
+			}
+		}
+		calendar.Owner = owners[calendar.UserID]
+		// The default calendar is the owner's, not the user's.
+		calendar.IsDefault = false
+		access.Calendars = append(access.Calendars, calendar)
+	}
+	own := len(access.Calendars) - len(shared)
+	sort.SliceStable(access.Calendars[own:], func(i, j int) bool {
+		return strings.ToLower(access.Calendars[own+i].Name) < strings.ToLower(access.Calendars[own+j].Name)
+	})
+	return access, nil
+}
+
+func userEmails(raw *gorm.DB, calendars []models.Calendar) (map[string]string, error) {
+	ids := make([]string, len(calendars))
+	for i, calendar := range calendars {
+		ids[i] = calendar.UserID
+	}
+	var users []models.User
+	if err := raw.Select("id", "email").Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to load calendar owners: %v", err)
+	}
+	emails := make(map[string]string, len(users))
+	for _, user := range users {
+		emails[user.ID] = user.Email
+	}
+	return emails, nil
+}
+
+// Calendar returns the calendar with the given ID, or nil if the user can't
+// see it.
+func (a *Access) Calendar(id string) *models.Calendar {
+	for i := range a.Calendars {
+		if a.Calendars[i].ID == id {
+			return &a.Calendars[i]
+		}
+	}
+	return nil
+}
+
+// Role returns the user's role on a calendar, or "" if they have none.
+func (a *Access) Role(calendarID string) models.Role {
+	if calendar := a.Calendar(calendarID); calendar != nil {
+		return calendar.Role
+	}
+	return ""
+}
+
+// CalendarIDs returns the IDs of the calendars on which the user has at
+// least the role min.
+func (a *Access) CalendarIDs(min models.Role) []string {
+	var ids []string
+	for _, calendar := range a.Calendars {
+		if calendar.Role.Allows(min) {
+			ids = append(ids, calendar.ID)
+		}
+	}
+	return ids
+}
+
+// HiddenIDs returns the IDs of the calendars the user has hidden.
+func (a *Access) HiddenIDs() []string {
+	var ids []string
+	for _, calendar := range a.Calendars {
+		if calendar.Hidden {
+			ids = append(ids, calendar.ID)
+		}
+	}
+	return ids
+}
+
+// FindByName returns the calendar with the given name, ignoring case, on
+// which the user has at least the role min, preferring their own calendars.
+func (a *Access) FindByName(name string, min models.Role) *models.Calendar {
+	name = strings.TrimSpace(name)
+	for i := range a.Calendars {
+		if strings.EqualFold(a.Calendars[i].Name, name) && a.Calendars[i].Role.Allows(min) {
+			return &a.Calendars[i]
+		}
+	}
+	return nil
+}
+
+// FindEvents is FindEvents over the events of the calendars the user can
+// see, as Filter returns them.
+func (a *Access) FindEvents(db *gorm.DB, filter EventFilter) ([]models.Event, error) {
+	events, err := FindEvents(a.search(db, filter), filter)
+	if err != nil {
+		return nil, err
+	}
+	return a.Filter(events), nil
+}
+
+// FindEventRows is FindEventRows over the events of the calendars the user
+// can see, as Filter returns them.
+func (a *Access) FindEventRows(db *gorm.DB, filter EventFilter) ([]models.Event, error) {
+	events, err := FindEventRows(a.search(db, filter), filter)
+	if err != nil {
+		return nil, err
+	}
+	return a.Filter(events), nil
+}
+
+// Events returns a handle on db (which may be a transaction) that only sees
+// the events of the calendars the user can see. Events read through it should
+// go through Filter before they are shown to the user.
+func (a *Access) Events(db *gorm.DB) *gorm.DB {
+	return a.eventsOf(db, models.RoleFreeBusy)
+}
+
+// search is Events for looking up events with filter. Searching by title or
+// color needs RoleRead, lest it reveal the details of free/busy events.
+func (a *Access) search(db *gorm.DB, filter EventFilter) *gorm.DB {
+	if filter.Title != "" || filter.Color != "" {
+		return a.eventsOf(db, models.RoleRead)
+	}
+	return a.Events(db)
+}
+
+func (a *Access) eventsOf(db *gorm.DB, min models.Role) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true}).
+		Where("calendar_id IN ?", a.CalendarIDs(min)).
+		Session(&gorm.Session{})
+}
+
+// Filter returns the events as the user may see them: events of calendars
+// the user can't see are left out, and those of calendars shared with the
+// user as free/busy only tell when they take place.
+func (a *Access) Filter(events []models.Event) []models.Event {
+	filtered := make([]models.Event, 0, len(events))
+	for _, event := range events {
+		switch role := a.Role(event.CalendarID); {
+		case role.Allows(models.RoleRead):
+			filtered = append(filtered, event)
+		case role.Allows(models.RoleFreeBusy):
+			filtered = append(filtered, event.FreeBusy())
+		}
+	}
+	return filtered
+}
+
+// Reminders maps the IDs of the calendars that have a default reminder to
+// how long before their events it goes off.
+func (a *Access) Reminders() map[string]time.Duration {
+	reminders := map[string]time.Duration{}
+	for _, calendar := range a.Calendars {
+		if reminder, ok := calendar.Reminder(); ok {
+			reminders[calendar.ID] = reminder
+		}
+	}
+	return reminders
+}
+
+// EventAccess checks that userID has at least the role min on the calendar
+// of the event id, deleted or not, and returns a handle on tx for working on
+// the event: one that sees the rows of the event's owner, like ForUser does
+// for the owner. It returns ErrNotFound if the user can't see the event at
+// all and ErrForbidden if their role isn't enough.
+func EventAccess(tx *gorm.DB, userID, id string, min models.Role) (*gorm.DB, models.Role, error) {
+	raw := tx.Session(&gorm.Session{NewDB: true})
+	var event models.Event
+	err := raw.Unscoped().Select("id", "user_id", "calendar_id").First(&event, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load event: %v", err)
+	}
+	role, err := calendarRole(raw, userID, event.UserID, event.CalendarID)
+	if err != nil {
+		return nil, "", err
+	}
+	if role == "" {
+		return nil, "", ErrNotFound
+	}
+	if !role.Allows(min) {
+		return nil, role, ErrForbidden
+	}
+	return forOwner(tx, event.UserID), role, nil
+}
+
+// CalendarAccess is EventAccess for a calendar: it returns the calendar
+// along with a handle on tx that sees the rows of its owner. It returns
+// ErrCalendarNotFound if the user can't see the calendar.
+func CalendarAccess(tx *gorm.DB, userID, id string, min models.Role) (*gorm.DB, *models.Calendar, error) {
+	raw := tx.Session(&gorm.Session{NewDB: true})
+	calendar, err := GetCalendar(raw, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	role, err := calendarRole(raw, userID, calendar.UserID, calendar.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if role == "" {
+		return nil, nil, ErrCalendarNotFound
+	}
+	if !role.Allows(min) {
+		return nil, nil, ErrForbidden
+	}
+	calendar.Role = role
+	return forOwner(tx, calendar.UserID), calendar, nil
+}
+
+// calendarRole returns the role of userID on the calendar of ownerID, or ""
+// if they have none.
+func calendarRole(raw *gorm.DB, userID, ownerID, calendarID string) (models.Role, error) {
+	if userID == ownerID {
+		return models.RoleOwner, nil
+	}
+	var share models.CalendarShare
+	err := raw.Where("calendar_id = ? AND user_id = ?", calendarID, userID).Limit(1).Find(&share).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to load share: %v", err)
+	}
+	return share.Role, nil
+}
+
+// ListShares returns the shares of a calendar along with the email of each
+// user it is shared with.
+func ListShares(tx *gorm.DB, calendarID string) ([]models.CalendarShare, error) {
+	raw := tx.Session(&gorm.Session{NewDB: true})
+	shares := []models.CalendarShare{}
+	if err := raw.Where("calendar_id = ?", calendarID).Order("created_at").Find(&shares).Error; err != nil {
+		return nil, fmt.Errorf("failed to list shares: %v", err)
+	}
+	for i := range shares {
+		var user models.User
+		if err := raw.Select("email").First(&user, "id = ?", shares[i].UserID).Error; err != nil {
+			return nil, fmt.Errorf("failed to load user of share: %v", err)
+		}
+		shares[i].Email = user.Email
+	}
+	return shares, nil
+}
+
+// ShareCalendar gives the user with the given email the role on a calendar,
+// replacing the role they had on it, if any.
+func ShareCalendar(tx *gorm.DB, calendar *models.Calendar, email string, role models.Role) (*models.CalendarShare, error) {
+	if !role.IsShareRole() {
+		invalid := &models.ValidationError{}
+		invalid.Add("role", "must be one of %s", joinRoles(models.ShareRoles))
+		return nil, invalid
+	}
+	raw := tx.Session(&gorm.Session{NewDB: true})
+	var user models.User
+	err := raw.First(&user, "email = ?", models.NormalizeEmail(email)).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		invalid := &models.ValidationError{}
+		invalid.Add("email", "has no account")
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %v", err)
+	}
+	if user.ID == calendar.UserID {
+		invalid := &models.ValidationError{}
+		invalid.Add("email", "is the owner of the calendar")
+		return nil, invalid
+	}
+
+	var share models.CalendarShare
+	err = raw.Where("calendar_id = ? AND user_id = ?", calendar.ID, user.ID).Limit(1).Find(&share).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load share: %v", err)
+	}
+	if share.ID == "" {
+		share = models.CalendarShare{ID: uuid.New().String(), CalendarID: calendar.ID, UserID: user.ID, Role: role}
+		err = raw.Create(&share).Error
+	} else {
+		err = raw.Model(&share).Update("role", role).Error
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save share: %v", err)
+	}
+	share.Email = user.Email
+	return &share, nil
+}
+
+// Unshare takes away the access of userID to a calendar.
+func Unshare(tx *gorm.DB, calendarID, userID string) error {
+	raw := tx.Session(&gorm.Session{NewDB: true})
+	result := raw.Where("calendar_id = ? AND user_id = ?", calendarID, userID).Delete(&models.CalendarShare{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete share: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+// SetShareHidden hides or shows a calendar shared with userID for that user.
+func SetShareHidden(tx *gorm.DB, calendarID, userID string, hidden bool) error {
+	raw := tx.Session(&gorm.Session{NewDB: true})
+	err := raw.Model(&models.CalendarShare{}).
+		Where("calendar_id = ? AND user_id = ?", calendarID, userID).
+		Update("hidden", hidden).Error
+	if err != nil {
+		return fmt.Errorf("failed to update share: %v", err)
+	}
+	return nil
+}
+
+func joinRoles(roles []models.Role) string {
+	names := make([]string, len(roles))
+	for i, role := range roles {
+		names[i] = string(role)
+	}
+	return strings.Join(names, ", ")
+}
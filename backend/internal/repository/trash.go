@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"calendar-backend/internal/models"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TrashRetention is how long deleted events stay in the trash before
+// PurgeTrash removes them for good.
+var TrashRetention = 30 * 24 * time.Hour
+
+var (
+	ErrNotInTrash    = errors.New("event is not in the trash")
+	ErrSeriesDeleted = errors.New("the series of this occurrence is deleted; restore the series instead")
+)
+
+// TrashItem is a deleted event that can be restored.
+type TrashItem struct {
+	Event     models.Event `json:"event"`
+	DeletedAt time.Time    `json:"deletedAt"`
+	PurgeAt   time.Time    `json:"purgeAt"`
+}
+
+// ListTrash returns the deleted events that can be restored, most recently
+// deleted first. Overrides deleted along with their series are left out since
+// restoring the series brings them back; overrides deleted on their own (an
+// occurrence deleted with scope "this") are listed.
+func ListTrash(db *gorm.DB) ([]TrashItem, error) {
+	var rows []models.Event
+	if err := db.Unscoped().Where("deleted_at IS NOT NULL").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to query trash: %v", err)
+	}
+
+	items := []TrashItem{}
+	masters := map[string]*models.Event{}
+	for i := range rows {
+		event := &rows[i]
+		if event.IsOverride() {
+			master, ok := masters[event.RecurringEventID]
+			if !ok {
+				var err error
+				if master, err = GetEvent(db, event.RecurringEventID); err != nil && !errors.Is(err, ErrNotFound) {
+					return nil, err
+				}
+				masters[event.RecurringEventID] = master
+			}
+			if master == nil || event.RecurrenceID == nil || !isExcluded(master, *event.RecurrenceID) {
+				continue
+			}
+		}
+		deletedAt := event.DeletedAt.Time
+		items = append(items, TrashItem{Event: *event, DeletedAt: deletedAt, PurgeAt: deletedAt.Add(TrashRetention)})
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].DeletedAt.After(items[j].DeletedAt) })
+	return items, nil
+}
+
+func isExcluded(master *models.Event, recurrenceID time.Time) bool {
+	for _, exdate := range master.ExDates {
+		if exdate.Equal(recurrenceID) {
+			return true
+		}
+	}
+	return false
+}
+
+// RestoreEvent brings a deleted event back. Restoring a series also restores
+// the overrides deleted with it; restoring an override puts it back into its
+// series.
+func RestoreEvent(tx *gorm.DB, id string) (*models.Event, error) {
+	var event models.Event
+	if err := tx.Unscoped().First(&event, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to load event: %v", err)
+	}
+	if !event.DeletedAt.Valid {
+		return nil, ErrNotInTrash
+	}
+
+	if event.IsOverride() {
+		master, err := GetEvent(tx, event.RecurringEventID)
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrSeriesDeleted
+		}
+		if err != nil {
+			return nil, err
+		}
+		if event.RecurrenceID == nil || !isExcluded(master, *event.RecurrenceID) {
+			return nil, ErrNotOccurrence
+		}
+		exdates := master.ExDates[:0]
+		for _, exdate := range master.ExDates {
+			if !exdate.Equal(*event.RecurrenceID) {
+				exdates = append(exdates, exdate)
+			}
+		}
+		master.ExDates = exdates
+		if err := tx.Model(master).Select("ex_dates").Updates(master).Error; err != nil {
+			return nil, fmt.Errorf("failed to restore occurrence: %v", err)
+		}
+	}
+
+	if err := undelete(tx, event.ID); err != nil {
+		return nil, err
+	}
+	restored, err := GetEvent(tx, event.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if restored.IsRecurring() {
+		// Overrides of occurrences that were excluded or cut off while the
+		// series was live stay deleted.
+		var overrides []models.Event
+		err := tx.Unscoped().Where("recurring_event_id = ? AND deleted_at IS NOT NULL", restored.ID).Find(&overrides).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to query overrides: %v", err)
+		}
+		for _, override := range overrides {
+			if override.RecurrenceID != nil && isOccurrence(restored, *override.RecurrenceID) {
+				if err := undelete(tx, override.ID); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return restored, nil
+}
+
+func undelete(tx *gorm.DB, id string) error {
+	err := tx.Unscoped().Model(&models.Event{}).Where("id = ?", id).Update("deleted_at", nil).Error
+	if err != nil {
+		return fmt.Errorf("failed to restore event: %v", err)
+	}
+	return nil
+}
+
+// PurgeTrash permanently removes the events deleted before cutoff and
+// returns how many rows were removed.
+func PurgeTrash(cutoff time.Time) (int64, error) {
+	var purged int64
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var rows []models.Event
+		if err := tx.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).Find(&rows).Error; err != nil {
+			return fmt.Errorf("failed to query trash: %v", err)
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		ids := make([]string, len(rows))
+		entries := make([]models.AuditEntry, len(rows))
+		for i := range rows {
+			ids[i] = rows[i].ID
+			entries[i] = *auditEntry(&rows[i], nil)
+		}
+		result := tx.Unscoped().Where("id IN ?", ids).Delete(&models.Event{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to purge trash: %v", result.Error)
+		}
+		purged = result.RowsAffected
+		return recordEntries(tx, models.Actor{Type: models.ActorSystem}, entries)
+	})
+	return purged, err
+}
+
+// HardDeleteEvent permanently removes an event, in the trash or not, along
+// with the overrides of a series.
+func HardDeleteEvent(tx *gorm.DB, id string) error {
+	var event models.Event
+	if err := tx.Unscoped().First(&event, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to load event: %v", err)
+	}
+	if !event.DeletedAt.Valid {
+		// Delete it normally first, so a live override is excluded from its
+		// series rather than letting the original occurrence come back.
+		scope := ScopeAll
+		if event.IsOverride() {
+			scope = ScopeThis
+		}
+		if err := DeleteEvent(tx, id, scope, nil); err != nil {
+			return err
+		}
+	}
+	err := tx.Unscoped().Where("id = ? OR recurring_event_id = ?", id, id).Delete(&models.Event{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to delete event: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"calendar-backend/internal/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrNothingToUndo is returned by UndoLastAction when the conversation has no
+// applied change left to undo.
+var ErrNothingToUndo = errors.New("there is no change by the assistant to undo in this conversation")
+
+// Ways an UndoneChange reverted an event.
+const (
+	UndoRemoved  = "removed"  // the event was created by the change
+	UndoReverted = "reverted" // the event was updated by the change
+	UndoRestored = "restored" // the event was deleted by the change
+)
+
+// EditedError is returned by UndoLastAction when an event the change touched
+// has been edited since.
+type EditedError struct {
+	Event models.Event
+}
+
+func (e *EditedError) Error() string {
+	return fmt.Sprintf("%q has been changed since, so the change can't be undone", e.Event.Title)
+}
+
+// UndoneChange describes how one event was put back by an undo.
+type UndoneChange struct {
+	EventID string `json:"eventId"`
+	Title   string `json:"title"`
+	Change  string `json:"change"`
+}
+
+// revertedChange is the net effect of a batch on one row: its state before
+// the first change and after the last one, either nil if it didn't exist.
+type revertedChange struct {
+	eventID       string
+	before, after *models.Event
+}
+
+// UndoLastAction reverts the batch of actions applied most recently in the
+// conversation, using the snapshots of the audit log: events it created are
+// removed, updated ones get their prior values back and deleted ones are
+// restored, or recreated if they have been purged since. Nothing is changed
+// if any of the events has been edited since; an *EditedError names it. The
+// batch may have changed calendars shared with actor, which they need to be
+// allowed to edit still. The undo is audited as made by actor and the batch
+// is marked as undone.
+func UndoLastAction(db *gorm.DB, conversationID string, actor models.Actor) (*models.PendingAction, []UndoneChange, error) {
+	var pending models.PendingAction
+	var undone []UndoneChange
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("conversation_id = ? AND status = ?", conversationID, models.ActionApplied).
+			Order("updated_at DESC").First(&pending).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNothingToUndo
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load action: %v", err)
+		}
+
+		// The changes to shared calendars are logged and stored as their
+		// owner's, so the rest is done outside of the user's rows.
+		tx = tx.Session(&gorm.Session{NewDB: true})
+		access, err := LoadAccess(tx, actor.UserID)
+		if err != nil {
+			return err
+		}
+		var entries []models.AuditEntry
+		if err := tx.Where("actor_action_id = ?", pending.ID).Order("id").Find(&entries).Error; err != nil {
+			return fmt.Errorf("failed to load audit entries: %v", err)
+		}
+		var changes []*revertedChange
+		byID := map[string]*revertedChange{}
+		for _, entry := range entries {
+			change, ok := byID[entry.EventID]
+			if !ok {
+				change = &revertedChange{eventID: entry.EventID, before: entry.Before}
+				byID[entry.EventID] = change
+				changes = append(changes, change)
+			}
+			change.after = entry.After
+		}
+
+		ids := make([]string, len(changes))
+		current := make([]*models.Event, len(changes))
+		for i, change := range changes {
+			ids[i] = change.eventID
+			var event models.Event
+			err := tx.Unscoped().First(&event, "id = ?", change.eventID).Error
+			if err == nil {
+				current[i] = &event
+			} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("failed to load event: %v", err)
+			}
+			if edited(current[i], change.after) {
+				if current[i] == nil {
+					return &EditedError{Event: *change.after}
+				}
+				return &EditedError{Event: *current[i]}
+			}
+			for _, state := range []*models.Event{change.before, current[i]} {
+				if state != nil && !access.Role(state.CalendarID).Allows(models.RoleEdit) {
+					return ErrForbidden
+				}
+			}
+		}
+
+		audit, err := BeginAudit(tx, ids...)
+		if err != nil {
+			return err
+		}
+		for i, change := range changes {
+			result, err := revert(tx, current[i], change)
+			if err != nil {
+				return err
+			}
+			if result != nil {
+				undone = append(undone, *result)
+			}
+		}
+		if err := audit.Record(actor, ids...); err != nil {
+			return err
+		}
+
+		result := tx.Model(&pending).Where("status = ?", models.ActionApplied).Update("status", models.ActionUndone)
+		if result.Error != nil {
+			return fmt.Errorf("failed to update action: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrActionResolved
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &pending, undone, nil
+}
+
+// edited reports whether the stored row differs from the state the change
+// left it in, where nil means it was deleted.
+func edited(current, after *models.Event) bool {
+	live := current != nil && !current.DeletedAt.Valid
+	if after == nil {
+		return live
+	}
+	return !live || !current.UpdatedAt.Equal(after.UpdatedAt)
+}
+
+// revert puts a row back into its state before the change.
+func revert(tx *gorm.DB, current *models.Event, change *revertedChange) (*UndoneChange, error) {
+	before := change.before
+	switch {
+	case before == nil && current == nil:
+		return nil, nil
+
+	case before == nil:
+		if err := tx.Unscoped().Where("id = ?", change.eventID).Delete(&models.Event{}).Error; err != nil {
+			return nil, fmt.Errorf("failed to remove event: %v", err)
+		}
+		return &UndoneChange{EventID: change.eventID, Title: current.Title, Change: UndoRemoved}, nil
+
+	case current == nil:
+		recreated := *before
+		recreated.UpdatedAt = time.Time{}
+		recreated.DeletedAt = gorm.DeletedAt{}
+		if err := tx.Create(&recreated).Error; err != nil {
+			return nil, fmt.Errorf("failed to recreate event: %v", err)
+		}
+		return &UndoneChange{EventID: change.eventID, Title: before.Title, Change: UndoRestored}, nil
+	}
+
+	if err := tx.Unscoped().Model(current).Select(importedFields).Updates(before).Error; err != nil {
+		return nil, fmt.Errorf("failed to revert event: %v", err)
+	}
+	if !current.DeletedAt.Valid {
+		return &UndoneChange{EventID: change.eventID, Title: before.Title, Change: UndoReverted}, nil
+	}
+	if err := undelete(tx, current.ID); err != nil {
+		return nil, err
+	}
+	return &UndoneChange{EventID: change.eventID, Title: before.Title, Change: UndoRestored}, nil
+}
@@ -0,0 +1,296 @@
+package repository
+
+import (
+	"calendar-backend/internal/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// SessionLifetime is how long a login stays valid.
+var SessionLifetime = 30 * 24 * time.Hour
+
+// APITokenPrefix starts every API token, which tells them apart from
+// session tokens and makes them easy to spot in configuration files.
+const APITokenPrefix = "cal_"
+
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrInvalidToken       = errors.New("invalid or expired token")
+	ErrEmailTaken         = errors.New("an account with this email already exists")
+	ErrTokenNotFound      = errors.New("token not found")
+)
+
+// dummyHash is compared against when an email is unknown, so that the
+// response time doesn't reveal which accounts exist.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// ForUser returns a handle on the database that only sees the rows owned by
+// the user: every query run through it, including inside its transactions,
+// is restricted to the user's ID. Rows created through it must still be
+// given their owner.
+func ForUser(userID string) *gorm.DB {
+	return forOwner(DB, userID)
+}
+
+// CreateUser creates an account with a bcrypt hash of the password, along
+// with its default calendar.
+func CreateUser(email, name, password string) (*models.User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %v", err)
+	}
+	user := &models.User{
+		ID:           uuid.New().String(),
+		Email:        models.NormalizeEmail(email),
+		Name:         strings.TrimSpace(name),
+		PasswordHash: string(hash),
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.User{}).Where("email = ?", user.Email).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to look up email: %v", err)
+		}
+		if count > 0 {
+			return ErrEmailTaken
+		}
+		if err := tx.Create(user).Error; err != nil {
+			return fmt.Errorf("failed to create user: %v", err)
+		}
+		_, err := DefaultCalendar(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetUser returns a user by ID.
+func GetUser(id string) (*models.User, error) {
+	var user models.User
+	if err := DB.First(&user, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to load user: %v", err)
+	}
+	return &user, nil
+}
+
+// Authenticate checks an email and password, returning ErrInvalidCredentials
+// if either is wrong.
+func Authenticate(email, password string) (*models.User, error) {
+	var user models.User
+	err := DB.First(&user, "email = ?", models.NormalizeEmail(email)).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %v", err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &user, nil
+}
+
+// ChangePassword replaces the user's password after checking the current
+// one, and ends the user's other sessions.
+func ChangePassword(user *models.User, current, password, keepSession string) error {
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(current)) != nil {
+		return ErrInvalidCredentials
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("password_hash", string(hash)).Error; err != nil {
+			return fmt.Errorf("failed to update password: %v", err)
+		}
+		err := tx.Where("user_id = ? AND token_hash <> ?", user.ID, hashToken(keepSession)).Delete(&models.Session{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to end sessions: %v", err)
+		}
+		return nil
+	})
+}
+
+// newToken returns a random token with the given prefix.
+func newToken(prefix string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession logs the user in, returning the session token.
+func CreateSession(userID string) (string, *models.Session, error) {
+	token, err := newToken("")
+	if err != nil {
+		return "", nil, err
+	}
+	session := &models.Session{
+		TokenHash: hashToken(token),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(SessionLifetime),
+	}
+	if err := DB.Create(session).Error; err != nil {
+		return "", nil, fmt.Errorf("failed to create session: %v", err)
+	}
+	return token, session, nil
+}
+
+// DeleteSession logs a session out.
+func DeleteSession(token string) error {
+	if err := DB.Delete(&models.Session{}, "token_hash = ?", hashToken(token)).Error; err != nil {
+		return fmt.Errorf("failed to delete session: %v", err)
+	}
+	return nil
+}
+
+// UserForToken returns the user a session or API token belongs to, or
+// ErrInvalidToken if it is unknown or expired.
+func UserForToken(token string) (*models.User, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+	now := time.Now()
+	var userID string
+	if strings.HasPrefix(token, APITokenPrefix) {
+		var apiToken models.APIToken
+		err := DB.First(&apiToken, "token_hash = ?", hashToken(token)).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up token: %v", err)
+		}
+		if apiToken.ExpiresAt != nil && apiToken.ExpiresAt.Before(now) {
+			return nil, ErrInvalidToken
+		}
+		if err := DB.Model(&apiToken).Update("last_used_at", now).Error; err != nil {
+			return nil, fmt.Errorf("failed to update token: %v", err)
+		}
+		userID = apiToken.UserID
+	} else {
+		var session models.Session
+		err := DB.First(&session, "token_hash = ?", hashToken(token)).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up session: %v", err)
+		}
+		if session.ExpiresAt.Before(now) {
+			if err := DB.Delete(&session).Error; err != nil {
+				return nil, fmt.Errorf("failed to delete session: %v", err)
+			}
+			return nil, ErrInvalidToken
+		}
+		userID = session.UserID
+	}
+
+	user, err := GetUser(userID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	return user, err
+}
+
+// UserForFeedToken returns the user whose iCalendar feed the token unlocks.
+func UserForFeedToken(token string) (*models.User, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+	var user models.User
+	err := DB.First(&user, "feed_token_hash = ?", hashToken(token)).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up feed token: %v", err)
+	}
+	return &user, nil
+}
+
+// RegenerateFeedToken gives the user a new feed token, which stops the
+// previous one from working. The token is only ever returned here.
+func RegenerateFeedToken(user *models.User) (string, error) {
+	token, err := newToken("")
+	if err != nil {
+		return "", err
+	}
+	if err := DB.Model(user).Update("feed_token_hash", hashToken(token)).Error; err != nil {
+		return "", fmt.Errorf("failed to save feed token: %v", err)
+	}
+	return token, nil
+}
+
+// RevokeFeedToken turns the user's iCalendar feed off until a new token is
+// generated.
+func RevokeFeedToken(user *models.User) error {
+	if err := DB.Model(user).Update("feed_token_hash", "").Error; err != nil {
+		return fmt.Errorf("failed to revoke feed token: %v", err)
+	}
+	return nil
+}
+
+// CreateAPIToken issues a named API token for the user, optionally expiring.
+// The token is only ever returned here.
+func CreateAPIToken(userID, name string, expiresAt *time.Time) (string, *models.APIToken, error) {
+	token, err := newToken(APITokenPrefix)
+	if err != nil {
+		return "", nil, err
+	}
+	apiToken := &models.APIToken{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Name:      strings.TrimSpace(name),
+		TokenHash: hashToken(token),
+		ExpiresAt: expiresAt,
+	}
+	if err := DB.Create(apiToken).Error; err != nil {
+		return "", nil, fmt.Errorf("failed to create token: %v", err)
+	}
+	return token, apiToken, nil
+}
+
+// ListAPITokens returns the user's API tokens, newest first.
+func ListAPITokens(userID string) ([]models.APIToken, error) {
+	tokens := []models.APIToken{}
+	if err := DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %v", err)
+	}
+	return tokens, nil
+}
+
+// DeleteAPIToken revokes one of the user's API tokens.
+func DeleteAPIToken(userID, id string) error {
+	result := DB.Where("user_id = ?", userID).Delete(&models.APIToken{}, "id = ?", id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete token: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"calendar-backend/internal/models"
+	"testing"
+	"time"
+)
+
+func TestForUser(t *testing.T) {
+	db, calendar := testDB(t)
+	previous := DB
+	DB = db
+	t.Cleanup(func() { DB = previous })
+
+	start := time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC)
+	event := models.Event{ID: "dentist", UserID: calendar.UserID, CalendarID: calendar.ID, Title: "Dentist", Start: start, End: start.Add(time.Hour)}
+	if err := db.Create(&event).Error; err != nil {
+		t.Fatalf("failed to create event: %v", err)
+	}
+
+	other := ForUser("user-2")
+	if _, err := GetEvent(other, "dentist"); err != ErrNotFound {
+		t.Errorf("reading another user's event gave %v, want ErrNotFound", err)
+	}
+	if events, err := FindEvents(other, EventFilter{}); err != nil || len(events) != 0 {
+		t.Errorf("listing events gave %d events of another user, %v", len(events), err)
+	}
+	if _, err := UpdateEvent(other, "dentist", map[string]interface{}{"title": "Hijacked"}, ScopeAll, nil); err != ErrNotFound {
+		t.Errorf("updating another user's event gave %v, want ErrNotFound", err)
+	}
+	if err := DeleteEvent(other, "dentist", ScopeAll, nil); err != ErrNotFound {
+		t.Errorf("deleting another user's event gave %v, want ErrNotFound", err)
+	}
+
+	stored, err := GetEvent(ForUser(calendar.UserID), "dentist")
+	if err != nil {
+		t.Fatalf("the owner reading the event failed: %v", err)
+	}
+	if stored.Title != "Dentist" {
+		t.Errorf("event is titled %q, want it unchanged", stored.Title)
+	}
+}
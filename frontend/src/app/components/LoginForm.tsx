@@ -0,0 +1,65 @@
+import React, { useState } from 'react';
+import { api, User } from '../utils/api';
+
+interface LoginFormProps {
+    onLogin: (user: User) => void;
+}
+
+export const LoginForm: React.FC<LoginFormProps> = ({ onLogin }) => {
+    const [email, setEmail] = useState('');
+    const [password, setPassword] = useState('');
+    const [error, setError] = useState<string | null>(null);
+    const [isSubmitting, setIsSubmitting] = useState(false);
+
+    const handleSubmit = async (e: React.FormEvent) => {
+        e.preventDefault();
+        setIsSubmitting(true);
+        setError(null);
+        try {
+            onLogin(await api.login(email, password));
+        } catch (error) {
+            setError(error instanceof Error ? error.message : 'Failed to log in');
+        } finally {
+            setIsSubmitting(false);
+        }
+    };
+
+    return (
+        <form
+            onSubmit={handleSubmit}
+            className="bg-[var(--tokyo-bg-lighter)] p-6 rounded-lg shadow-xl w-96"
+        >
+            <h2 className="text-xl font-bold text-[var(--tokyo-cyan)] mb-4">
+                Log in
+            </h2>
+            <label className="block text-sm text-[var(--tokyo-purple)] mb-1">Email</label>
+            <input
+                type="email"
+                autoComplete="username"
+                required
+                value={email}
+                onChange={(e) => setEmail(e.target.value)}
+                className="w-full mb-4 p-2 rounded bg-[var(--tokyo-bg)] text-[var(--tokyo-fg)] border border-[var(--tokyo-border)]"
+            />
+            <label className="block text-sm text-[var(--tokyo-purple)] mb-1">Password</label>
+            <input
+                type="password"
+                autoComplete="current-password"
+                required
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                className="w-full mb-4 p-2 rounded bg-[var(--tokyo-bg)] text-[var(--tokyo-fg)] border border-[var(--tokyo-border)]"
+            />
+            {error && (
+                <p className="mb-4 text-sm text-[var(--tokyo-red)]">{error}</p>
+            )}
+            <button
+                type="submit"
+                disabled={isSubmitting}
+                className="w-full px-4 py-2 bg-[var(--tokyo-purple)] text-[var(--tokyo-bg)] rounded hover:bg-[var(--tokyo-purple)]/90 disabled:opacity-50"
+            >
+                {isSubmitting ? 'Logging in...' : 'Log in'}
+            </button>
+        </form>
+    );
+};
@@ -6,11 +6,12 @@ import { DayView } from './components/DayView';
 import { MultiDayView } from './components/MultiDayView';
 import { EventCard } from './components/EventCard';
 import { Event } from './types/Event';
-import { api } from './utils/api';
+import { api, UnauthorizedError, User } from './utils/api';
 import { ThemeToggle } from './components/ThemeToggle';
 import { format } from 'date-fns';
 import { ChatButton } from './components/ChatButton';
 import { ChatWidget } from './components/ChatWidget';
+import { LoginForm } from './components/LoginForm';
 
 type ViewType = 'month' | 'week' | '3day' | 'day';
 
@@ -28,6 +29,18 @@ export default function Home() {
   const [view, setView] = useState<'day' | 'week'>('week');
   const [isLoading, setIsLoading] = useState(true);
   const [isChatOpen, setIsChatOpen] = useState(false);
+  // undefined while the session is being checked, null when logged out
+  const [user, setUser] = useState<User | null | undefined>(undefined);
+
+  // handleApiError shows the login form again when the session has expired.
+  const handleApiError = (message: string, error: unknown) => {
+    if (error instanceof UnauthorizedError) {
+      setUser(null);
+      setEvents([]);
+      return;
+    }
+    console.error(message, error);
+  };
 
   const fetchEvents = async () => {
     try {
@@ -35,16 +48,37 @@ export default function Home() {
       const fetchedEvents = await api.getEvents();
       setEvents(fetchedEvents);
     } catch (error) {
-      console.error('Error fetching events:', error);
+      handleApiError('Error fetching events:', error);
     } finally {
       setIsLoading(false);
     }
   };
 
   useEffect(() => {
-    fetchEvents();
+    api.me()
+      .then(setUser)
+      .catch((error) => {
+        console.error('Error checking session:', error);
+        setUser(null);
+      });
   }, []);
 
+  useEffect(() => {
+    if (user) {
+      fetchEvents();
+    }
+  }, [user]);
+
+  const handleLogout = async () => {
+    try {
+      await api.logout();
+    } catch (error) {
+      handleApiError('Error logging out:', error);
+    }
+    setUser(null);
+    setEvents([]);
+  };
+
   const handleDateSelect = (date: Date) => {
     setSelectedDate(date);
     if (currentView === 'month') {
@@ -83,7 +117,7 @@ export default function Home() {
         color: 'var(--tokyo-blue)'
       });
     } catch (error) {
-      console.error('Error creating event:', error);
+      handleApiError('Error creating event:', error);
     }
   };
 
@@ -92,7 +126,7 @@ export default function Home() {
       await api.deleteEvent(eventToDelete.id);
       await fetchEvents();
     } catch (error) {
-      console.error('Error deleting event:', error);
+      handleApiError('Error deleting event:', error);
     }
   };
 
@@ -105,10 +139,18 @@ export default function Home() {
       await api.updateEvent(updatedEvent);
       await fetchEvents();
     } catch (error) {
-      console.error('Error updating event:', error);
+      handleApiError('Error updating event:', error);
     }
   };
 
+  if (!user) {
+    return (
+      <main className="min-h-screen p-8 bg-[var(--tokyo-bg)] flex items-center justify-center">
+        {user === null && <LoginForm onLogin={setUser} />}
+      </main>
+    );
+  }
+
   return (
     <main className="min-h-screen p-8 bg-[var(--tokyo-bg)]">
       <div className="max-w-7xl mx-auto">
@@ -117,6 +159,13 @@ export default function Home() {
             Calendar
           </h1>
           <div className="flex items-center gap-4">
+            <span className="text-sm text-[var(--tokyo-fg)]">{user.email}</span>
+            <button
+              onClick={handleLogout}
+              className="px-4 py-2 text-[var(--tokyo-purple)] border border-[var(--tokyo-border)] rounded hover:bg-[var(--tokyo-purple)] hover:text-[var(--tokyo-bg)] transition-colors"
+            >
+              Log out
+            </button>
             <ThemeToggle />
             <div className="flex gap-2">
               <button
@@ -1,5 +1,5 @@
 import { Event } from '../types/Event';
-import { api } from '../utils/api';
+import { apiFetch, UnauthorizedError } from '../utils/api';
 
 interface ChatResponse {
     text: string;
@@ -34,7 +34,7 @@ function mapActionType(type: 'response' | 'create' | 'update' | 'delete'): 'add'
 export class ChatService {
     static async processMessage(message: string, events: Event[]): Promise<ChatResponse> {
         try {
-            const response = await fetch('http://localhost:8080/api/chat', {
+            const response = await apiFetch('/chat', {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
@@ -59,6 +59,12 @@ export class ChatService {
             };
         } catch (error) {
             console.error('Error processing message:', error);
+            if (error instanceof UnauthorizedError) {
+                return {
+                    text: "Your session has expired. Please log in again.",
+                    success: false,
+                };
+            }
             return {
                 text: "Something went wrong while processing your message. Could you try again?",
                 success: false,
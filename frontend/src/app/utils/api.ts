@@ -1,10 +1,77 @@
 import { Event } from '../types/Event';
 
-const API_BASE_URL = 'http://localhost:8080/api';
+export const API_BASE_URL = 'http://localhost:8080/api';
+
+export interface User {
+    id: string;
+    email: string;
+    name?: string;
+}
+
+// UnauthorizedError is thrown when the session is missing or has expired,
+// so that the page can ask the user to log in again.
+export class UnauthorizedError extends Error {
+    constructor() {
+        super('Authentication required');
+        this.name = 'UnauthorizedError';
+    }
+}
+
+// apiFetch sends the session cookie along with every request; the backend
+// rejects requests to /api without it.
+export async function apiFetch(path: string, init: RequestInit = {}): Promise<Response> {
+    const response = await fetch(`${API_BASE_URL}${path}`, {
+        ...init,
+        credentials: 'include',
+    });
+    if (response.status === 401) {
+        throw new UnauthorizedError();
+    }
+    return response;
+}
 
 export const api = {
+    async login(email: string, password: string): Promise<User> {
+        const response = await fetch(`${API_BASE_URL}/auth/login`, {
+            method: 'POST',
+            credentials: 'include',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ email, password }),
+        });
+        if (response.status === 401) {
+            throw new Error('Invalid email or password');
+        }
+        if (!response.ok) {
+            throw new Error('Failed to log in');
+        }
+        const data = await response.json();
+        return data.user;
+    },
+
+    async logout(): Promise<void> {
+        await apiFetch('/auth/logout', { method: 'POST' });
+    },
+
+    // me returns the logged in user, or null without a valid session.
+    async me(): Promise<User | null> {
+        try {
+            const response = await apiFetch('/auth/me');
+            if (!response.ok) {
+                throw new Error('Failed to load user');
+            }
+            return await response.json();
+        } catch (error) {
+            if (error instanceof UnauthorizedError) {
+                return null;
+            }
+            throw error;
+        }
+    },
+
     async getEvents(): Promise<Event[]> {
-        const response = await fetch(`${API_BASE_URL}/events`);
+        const response = await apiFetch('/events');
         if (!response.ok) {
             throw new Error('Failed to fetch events');
         }
@@ -17,7 +84,7 @@ export const api = {
     },
 
     async createEvent(event: Omit<Event, 'id'>): Promise<Event> {
-        const response = await fetch(`${API_BASE_URL}/events`, {
+        const response = await apiFetch('/events', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
@@ -36,7 +103,7 @@ export const api = {
     },
 
     async updateEvent(event: Event): Promise<Event> {
-        const response = await fetch(`${API_BASE_URL}/events/${event.id}`, {
+        const response = await apiFetch(`/events/${event.id}`, {
             method: 'PUT',
             headers: {
                 'Content-Type': 'application/json',
@@ -55,11 +122,11 @@ export const api = {
     },
 
     async deleteEvent(id: string): Promise<void> {
-        const response = await fetch(`${API_BASE_URL}/events/${id}`, {
+        const response = await apiFetch(`/events/${id}`, {
             method: 'DELETE',
         });
         if (!response.ok) {
             throw new Error('Failed to delete event');
         }
     }
-}; 
\ No newline at end of file
+}; 